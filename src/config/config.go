@@ -0,0 +1,901 @@
+package config
+
+// The config package gathers the crawl options into a single Config struct.
+// The options can be loaded from a JSON file, with any flags given on the
+// command line overriding the values in the file, and the crawler, fetcher,
+// fronter and sink are then built from the Config.
+// The JSON keys are the same as the flag names. Each option can also be set
+// with an environment variable named after its flag, i.e. CRAWL_MAX_DEPTH for
+// -max-depth, which overrides the file and is overridden by the flag.
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"linkcrawl/crawler"
+	"linkcrawl/data"
+	"linkcrawl/fetcher"
+	"linkcrawl/fronter"
+	"linkcrawl/health"
+	"linkcrawl/relay"
+	"linkcrawl/sink"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds every option that can be set with a flag or in a config file
+type Config struct {
+	Domain              string   `json:"domain"`
+	Workers             int      `json:"workers"`
+	Scope               string   `json:"scope"`
+	Subdomains          bool     `json:"subdomains"`
+	SameDepth           bool     `json:"same-depth"`
+	CaptureHeaders      []string `json:"capture-headers"`
+	LinkRels            []string `json:"link-rels"`
+	CollapseIndex       bool     `json:"collapse-index"`
+	IndexFiles          []string `json:"index-files"`
+	StripParams         []string `json:"strip-params"`
+	AllowedSchemes      []string `json:"allowed-schemes"`
+	PreferHTTPS         bool     `json:"prefer-https"`
+	SamePageFragments   bool     `json:"same-page-fragments"`
+	RespectNofollow     bool     `json:"respect-nofollow"`
+	FollowIframes       bool     `json:"follow-iframes"`
+	ReportOutlinks      bool     `json:"report-outlinks"`
+	ReportSizes         bool     `json:"report-sizes"`
+	MinContentLength    int      `json:"min-content-length"`
+	ReportBroken        bool     `json:"report-broken"`
+	ReportCanonical     bool     `json:"report-canonical"`
+	Recheck             string   `json:"recheck"`
+	IgnoreRobots        bool     `json:"ignore-robots"`
+	Baseline            string   `json:"baseline"`
+	Snapshot            string   `json:"snapshot"`
+	SkipThin            bool     `json:"skip-thin"`
+	ReportNormalization bool     `json:"report-normalization"`
+	ReportSkipped       bool     `json:"report-skipped"`
+	CheckFragments      bool     `json:"check-fragments"`
+	ReportMixedContent  bool     `json:"report-mixed-content"`
+	CaptureTLS          bool     `json:"capture-tls"`
+	ParsePDF            bool     `json:"parse-pdf"`
+	ProbeWellKnown      bool     `json:"probe-wellknown"`
+	ProbePaths          []string `json:"probe-paths"`
+	UseURLCredentials   bool     `json:"use-url-credentials"`
+	CleanCache          int      `json:"clean-cache"`
+	MaxInFlight         int      `json:"max-inflight"`
+	MaxBandwidth        int64    `json:"max-bandwidth"`
+	MaxBytes            int64    `json:"max-bytes"`
+	BreakerThreshold    int      `json:"breaker-threshold"`
+	BreakerCooldown     Duration `json:"breaker-cooldown"`
+	MaxErrorsPerHost    int      `json:"max-errors-per-host"`
+	MaxDepth            int      `json:"max-depth"`
+	HostDepth           Depths   `json:"host-depth"`
+	ReportLeafLinks     bool     `json:"report-leaf-links"`
+	FrontierTTL         Duration `json:"frontier-ttl"`
+	Timeout             Duration `json:"timeout"`
+	BodyTimeout         Duration `json:"body-timeout"`
+	MaxIdle             Duration `json:"max-idle"`
+	MaxPages            int      `json:"max-pages"`
+	MaxRedirects        int      `json:"max-redirects"`
+	Delay               Duration `json:"delay"`
+	AdaptiveDelay       Duration `json:"adaptive-delay"`
+	AdaptiveMin         Duration `json:"adaptive-min"`
+	AdaptiveMax         Duration `json:"adaptive-max"`
+	DNSPrefetch         bool     `json:"dns-prefetch"`
+	DNSConcurrency      int      `json:"dns-concurrency"`
+	ESURL               string   `json:"es-url"`
+	ESIndex             string   `json:"es-index"`
+	ESBatch             int      `json:"es-batch"`
+	KafkaBrokers        []string `json:"kafka-brokers"`
+	KafkaTopic          string   `json:"kafka-topic"`
+	KafkaBatch          int      `json:"kafka-batch"`
+	WARC                string   `json:"warc"`
+	Output              string   `json:"output"`
+	Append              bool     `json:"append"`
+	Socket              string   `json:"socket"`
+	Format              string   `json:"format"`
+	Cookies             bool     `json:"cookies"`
+	Compression         bool     `json:"compression"`
+	RetryEmptyBody      bool     `json:"retry-empty-body"`
+	HostOverride        string   `json:"host-override"`
+	BasicUser           string   `json:"basic-user"`
+	BasicPass           string   `json:"basic-pass"`
+	Bearer              string   `json:"bearer"`
+	SNI                 string   `json:"sni"`
+	BindAddress         string   `json:"bind-address"`
+	Proxy               string   `json:"proxy"`
+	UserAgents          string   `json:"user-agents"`
+	UserAgent           string   `json:"user-agent"`
+	Headers             []string `json:"header"`
+	Strict              bool     `json:"strict"`
+	DryRun              bool     `json:"validate"`
+	LogLevel            string   `json:"log-level"`
+	OutputBuffer        int      `json:"output-buffer"`
+	OutputOverflow      string   `json:"output-overflow"`
+	OutputMode          string   `json:"output-mode"`
+	DedupeOutput        bool     `json:"dedupe-output"`
+	OnlyStatus          string   `json:"only-status"`
+	ReportPopular       int      `json:"report-popular"`
+	ReportAnchorText    int      `json:"report-anchor-text"`
+	ReportLinkTypes     bool     `json:"report-link-types"`
+	CheckpointEvery     int      `json:"checkpoint-every"`
+	Summary             bool     `json:"summary"`
+	Resume              string   `json:"resume"`
+	ResumeEvery         Duration `json:"resume-every"`
+	Seed                int64    `json:"seed"`
+	RunID               string   `json:"run-id"`
+	RetryJitter         Duration `json:"retry-jitter"`
+	BackoffBase         Duration `json:"backoff-base"`
+	BackoffMax          Duration `json:"backoff-max"`
+	MaxRetryAfter       Duration `json:"max-retry-after"`
+	HealthAddr          string   `json:"health-addr"`
+	HealthStall         Duration `json:"health-stall"`
+}
+
+// Default returns a pointer to a config.Config with the default options
+func Default() *Config {
+	return &Config{
+		Scope:           "host",
+		Workers:         5,
+		LinkRels:        []string{"next"},
+		IndexFiles:      []string{"index.html", "index.php", "default.aspx"},
+		StripParams:     crawler.DefaultStripParams,
+		UserAgent:       fetcher.DefaultUserAgent,
+		ProbePaths:      fetcher.DefaultProbePaths,
+		CleanCache:      crawler.DefaultCleanCacheSize,
+		OutputBuffer:    1000,
+		OutputOverflow:  relay.Block,
+		OutputMode:      "urls",
+		Format:          "csv",
+		LogLevel:        "info",
+		DNSConcurrency:  4,
+		ESIndex:         "linkcrawl",
+		ESBatch:         100,
+		KafkaBatch:      100,
+		Timeout:         Duration(5 * time.Second),
+		HealthStall:     Duration(time.Minute),
+		BreakerCooldown: Duration(30 * time.Second),
+		AdaptiveMax:     Duration(10 * time.Second),
+		BackoffMax:      Duration(30 * time.Second),
+		MaxRetryAfter:   Duration(5 * time.Minute),
+		ResumeEvery:     Duration(time.Minute),
+		MaxRedirects:    10,
+	}
+}
+
+// EnvPrefix is the prefix of the environment variables the options are read from
+const EnvPrefix = "CRAWL_"
+
+// EnvName returns the name of the environment variable for a flag
+func EnvName(flag string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(flag, "-", "_"))
+}
+
+// Load reads the options from a JSON config file on top of the defaults, a
+// key that does not match an option is reported as an error.
+func Load(path string) (*Config, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error opening config file: %v", err)
+	}
+	defer file.Close()
+
+	cfg := Default()
+	decoder := json.NewDecoder(file)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(cfg); err != nil {
+		return nil, fmt.Errorf("Error loading config file %s: %v", path, err)
+	}
+	return cfg, nil
+}
+
+// Parse reads the options from the command line arguments. When -config, or
+// CRAWL_CONFIG, is passed the file is loaded first. The environment variables
+// are applied on top of the file, or the defaults, and the flags that were set
+// on the command line override both. The options are validated once the flags
+// have been applied.
+func Parse(fs *flag.FlagSet, args []string) (*Config, error) {
+	cfg := Default()
+	cfg.Flags(fs)
+	path := fs.String("config", "", "JSON file to load the options from, flags override the values in it")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	if !set["config"] {
+		*path = os.Getenv(EnvName("config"))
+	}
+	if *path == "" {
+		if err := cfg.applyEnv(set); err != nil {
+			return nil, err
+		}
+		return cfg, cfg.Validate()
+	}
+
+	loaded, err := Load(*path)
+	if err != nil {
+		return nil, err
+	}
+	if err := loaded.applyEnv(set); err != nil {
+		return nil, err
+	}
+	overrides := flag.NewFlagSet(fs.Name(), flag.ContinueOnError)
+	loaded.Flags(overrides)
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "config" || err != nil {
+			return
+		}
+		err = overrides.Set(f.Name, f.Value.String())
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Error applying flags to the config file: %v", err)
+	}
+	return loaded, loaded.Validate()
+}
+
+// applyEnv sets the options from their environment variables, skipping the
+// flags that were set on the command line.
+func (c *Config) applyEnv(set map[string]bool) error {
+	fs := flag.NewFlagSet("env", flag.ContinueOnError)
+	c.Flags(fs)
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if set[f.Name] || err != nil {
+			return
+		}
+		if value, ok := os.LookupEnv(EnvName(f.Name)); ok {
+			if setErr := fs.Set(f.Name, value); setErr != nil {
+				err = fmt.Errorf("Error reading %s: %v", EnvName(f.Name), setErr)
+			}
+		}
+	})
+	return err
+}
+
+// Validate checks the options that only accept a fixed set of values and
+// returns the first problem found.
+func (c *Config) Validate() error {
+	if problems := c.problems(); len(problems) > 0 {
+		return problems[0]
+	}
+	return nil
+}
+
+// Check returns every problem with the options, along with the problems with
+// the seed URL that are otherwise only found once the crawl starts. Nothing
+// is fetched or opened so it can be run by -validate before a crawl.
+func (c *Config) Check() []error {
+	var problems []error
+	if c.Domain == "" {
+		problems = append(problems, fmt.Errorf("Missing domain, expected -domain https://domain.com or CRAWL_DOMAIN"))
+	} else if seed, err := url.Parse(c.Domain); err != nil {
+		problems = append(problems, fmt.Errorf("Invalid domain %s: %v", c.Domain, err))
+	} else if (seed.Scheme != "http" && seed.Scheme != "https") || seed.Host == "" {
+		problems = append(problems, fmt.Errorf("Invalid domain %s, expected an http or https URL such as https://domain.com", c.Domain))
+	}
+	return append(problems, c.problems()...)
+}
+
+// problems returns the problems with the options in the order they are
+// checked, the filters are compiled to check them.
+func (c *Config) problems() []error {
+	var problems []error
+	if c.Workers < 1 {
+		problems = append(problems, fmt.Errorf("Invalid workers %d, expected at least 1", c.Workers))
+	}
+	if c.Scope != "host" && c.Scope != "seed-path" {
+		problems = append(problems, fmt.Errorf("Invalid scope %s, expected host or seed-path", c.Scope))
+	}
+	if c.OutputOverflow != relay.Block && c.OutputOverflow != relay.Drop {
+		problems = append(problems, fmt.Errorf("Invalid output overflow %s, expected %s or %s", c.OutputOverflow, relay.Block, relay.Drop))
+	}
+	if c.OutputMode != "urls" && c.OutputMode != "hosts" && c.OutputMode != "paths" {
+		problems = append(problems, fmt.Errorf("Invalid output mode %s, expected urls, hosts or paths", c.OutputMode))
+	}
+	if c.Format != "" && c.Format != "csv" && c.Format != "json" {
+		problems = append(problems, fmt.Errorf("Invalid format %s, expected csv or json", c.Format))
+	}
+	if c.Socket != "" && c.Output != "" {
+		problems = append(problems, fmt.Errorf("Invalid socket %s, the records cannot be written to both -socket and -output", c.Socket))
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.LogLevel)); err != nil {
+		problems = append(problems, fmt.Errorf("Invalid log level %s, expected debug, info, warn or error", c.LogLevel))
+	}
+	if c.OnlyStatus != "" {
+		if _, err := relay.ParseStatusFilter(c.OnlyStatus); err != nil {
+			problems = append(problems, err)
+		}
+	}
+	for _, header := range c.Headers {
+		if _, _, err := splitHeader(header); err != nil {
+			problems = append(problems, err)
+		}
+	}
+	if c.BindAddress != "" && net.ParseIP(c.BindAddress) == nil {
+		problems = append(problems, fmt.Errorf("Invalid bind address %s, expected an IP address", c.BindAddress))
+	}
+	if c.Proxy != "" {
+		if _, err := fetcher.ParseProxy(c.Proxy); err != nil {
+			problems = append(problems, err)
+		}
+	}
+	if c.AdaptiveDelay > 0 && c.AdaptiveMax < c.AdaptiveMin {
+		problems = append(problems, fmt.Errorf("Invalid adaptive max %v, expected at least the adaptive min %v", time.Duration(c.AdaptiveMax), time.Duration(c.AdaptiveMin)))
+	}
+	counts := []struct {
+		name  string
+		value int64
+	}{
+		{"max-bytes", c.MaxBytes},
+		{"max-pages", int64(c.MaxPages)},
+		{"max-redirects", int64(c.MaxRedirects)},
+		{"min-content-length", int64(c.MinContentLength)},
+		{"max-inflight", int64(c.MaxInFlight)},
+		{"checkpoint-every", int64(c.CheckpointEvery)},
+	}
+	for _, count := range counts {
+		if count.value < 0 {
+			problems = append(problems, fmt.Errorf("Invalid %s %d, expected 0 or more", count.name, count.value))
+		}
+	}
+	return problems
+}
+
+// Flags registers a flag for each option on the flag set, the current
+// values of the Config are used as the flag defaults.
+func (c *Config) Flags(fs *flag.FlagSet) {
+	fs.StringVar(&c.Domain, "domain", c.Domain, "The domain to crawl")
+	fs.IntVar(&c.Workers, "workers", c.Workers, "Number of pages fetched concurrently")
+	fs.StringVar(&c.Scope, "scope", c.Scope, "Crawl the whole host, or only the seed URL's directory with seed-path")
+	fs.BoolVar(&c.Subdomains, "subdomains", c.Subdomains, "Crawl the subdomains of the seed's registered domain as part of the site")
+	fs.BoolVar(&c.SameDepth, "same-depth", c.SameDepth, "Only crawl the URLs whose path has as many segments as the seed URL's")
+	fs.Var((*listValue)(&c.CaptureHeaders), "capture-headers", "Comma separated list of response headers to record for each page")
+	fs.Var((*listValue)(&c.LinkRels), "link-rels", "Comma separated list of Link response header rels to crawl, such as next,prev,last")
+	fs.BoolVar(&c.CollapseIndex, "collapse-index", c.CollapseIndex, "Strip the index file names from the end of the paths so they collapse to the directory URL")
+	fs.Var((*listValue)(&c.StripParams), "strip-params", "Comma separated list of the query parameters removed from the links, a trailing * matches a prefix, empty keeps the queries as they are")
+	fs.Var((*listValue)(&c.IndexFiles), "index-files", "Comma separated list of the index file names stripped by -collapse-index")
+	fs.Var((*listValue)(&c.AllowedSchemes), "allowed-schemes", "Comma separated list of schemes other than http and https, such as ftp, whose links are recorded but not fetched")
+	fs.BoolVar(&c.PreferHTTPS, "prefer-https", c.PreferHTTPS, "Rewrite http links to https before they are de-duplicated")
+	fs.IntVar(&c.MaxInFlight, "max-inflight", c.MaxInFlight, "Maximum number of concurrent outbound requests, 0 is unlimited")
+	fs.Int64Var(&c.MaxBandwidth, "max-bandwidth", c.MaxBandwidth, "Maximum rate in bytes per second to download the pages at across all requests, 0 is unlimited")
+	fs.Int64Var(&c.MaxBytes, "max-bytes", c.MaxBytes, "Stop the crawl once this many bytes of response bodies have been downloaded, 0 is unlimited")
+	fs.IntVar(&c.BreakerThreshold, "breaker-threshold", c.BreakerThreshold, "Stop requesting a host after this many consecutive failures, 0 turns the circuit breaker off")
+	fs.Var(&c.BreakerCooldown, "breaker-cooldown", "How long requests to a host are stopped for before a trial request is made")
+	fs.IntVar(&c.MaxErrorsPerHost, "max-errors-per-host", c.MaxErrorsPerHost, "Abandon a host once this many of its requests have failed, 0 never abandons a host")
+	fs.IntVar(&c.MaxDepth, "max-depth", c.MaxDepth, "Maximum depth to crawl from the seed, 0 or negative is unlimited")
+	fs.Var(&c.HostDepth, "host-depth", "Comma separated host=depth list overriding -max-depth for those hosts, such as cdn.example.com=1")
+	fs.BoolVar(&c.ReportLeafLinks, "report-leaf-links", c.ReportLeafLinks, "Report the links found beyond -max-depth without crawling them")
+	fs.Var(&c.Timeout, "timeout", "Give up on a request that has not been read in full after this long, including its body, such as 10s, 0 has no limit")
+	fs.Var(&c.BodyTimeout, "body-timeout", "Give up reading the body of a response after this long, such as 30s, 0 has no limit")
+	fs.Var(&c.Delay, "delay", "The minimum interval between the requests to each host, such as 1s, a Crawl-delay in the robots.txt takes its place")
+	fs.Var(&c.AdaptiveDelay, "adaptive-delay", "Slow down the requests to a host while its average response time is above this, such as 500ms, and speed up while it is below")
+	fs.Var(&c.AdaptiveMin, "adaptive-min", "The shortest delay between the requests to a host with -adaptive-delay")
+	fs.Var(&c.AdaptiveMax, "adaptive-max", "The longest delay between the requests to a host with -adaptive-delay")
+	fs.IntVar(&c.MaxPages, "max-pages", c.MaxPages, "Stop the crawl once N pages have been fetched successfully even if links remain, 0 is unlimited")
+	fs.IntVar(&c.MaxRedirects, "max-redirects", c.MaxRedirects, "Follow at most N redirects for each request, a longer chain is reported as an error")
+	fs.Var(&c.MaxIdle, "max-idle", "End the crawl when no page has been fetched for this long, such as 2m, 0 waits for the crawl to finish")
+	fs.Var(&c.FrontierTTL, "frontier-ttl", "Drop links that have waited in the frontier for longer than this, such as 10m, 0 keeps them")
+	fs.BoolVar(&c.DNSPrefetch, "dns-prefetch", c.DNSPrefetch, "Resolve the hosts of newly discovered URLs before they are fetched")
+	fs.IntVar(&c.DNSConcurrency, "dns-concurrency", c.DNSConcurrency, "Maximum number of concurrent DNS prefetch lookups")
+	fs.BoolVar(&c.SamePageFragments, "same-page-fragments", c.SamePageFragments, "Treat fragment-only links as links to the page they are on and do not emit them")
+	fs.BoolVar(&c.RespectNofollow, "respect-nofollow", c.RespectNofollow, "Do not follow the anchors with rel=\"nofollow\"")
+	fs.StringVar(&c.ESURL, "es-url", c.ESURL, "Index the crawled pages in the Elasticsearch/OpenSearch cluster at this URL")
+	fs.StringVar(&c.ESIndex, "es-index", c.ESIndex, "The index to store the crawled pages in")
+	fs.IntVar(&c.ESBatch, "es-batch", c.ESBatch, "The number of pages sent in each bulk request")
+	fs.Var((*listValue)(&c.KafkaBrokers), "kafka-brokers", "Comma separated list of Kafka brokers to publish the crawled pages to, needs a build with -tags kafka")
+	fs.StringVar(&c.KafkaTopic, "kafka-topic", c.KafkaTopic, "The Kafka topic to publish the crawled pages to")
+	fs.IntVar(&c.KafkaBatch, "kafka-batch", c.KafkaBatch, "The number of pages published in each batch of messages")
+	fs.StringVar(&c.WARC, "warc", c.WARC, "Archive every fetched request and response to a WARC file at this path")
+	fs.StringVar(&c.Output, "output", c.Output, "Write the output records to a file at this path instead of stdout")
+	fs.BoolVar(&c.Append, "append", c.Append, "Append the output records to the -output file instead of truncating it")
+	fs.StringVar(&c.Format, "format", c.Format, "Write the output records as csv, or as json with one object per line")
+	fs.StringVar(&c.Socket, "socket", c.Socket, "Listen on a Unix socket at this path and stream the output records to its clients as NDJSON")
+	fs.BoolVar(&c.Cookies, "cookies", c.Cookies, "Store cookies set by the site and send them with later requests")
+	fs.BoolVar(&c.RetryEmptyBody, "retry-empty-body", c.RetryEmptyBody, "Retry the 200 responses whose body is empty once it has been decompressed")
+	fs.BoolVar(&c.Compression, "compression", c.Compression, "Ask for brotli, gzip or deflate compressed responses")
+	fs.StringVar(&c.BasicUser, "basic-user", c.BasicUser, "Authenticate the requests to the seed's host with basic auth as this user")
+	fs.StringVar(&c.BasicPass, "basic-pass", c.BasicPass, "The password sent with -basic-user, CRAWL_BASIC_PASS keeps it out of the process list")
+	fs.StringVar(&c.Bearer, "bearer", c.Bearer, "Authenticate the requests to the seed's host with this bearer token, CRAWL_BEARER keeps it out of the process list")
+	fs.StringVar(&c.HostOverride, "host-override", c.HostOverride, "Send this Host header with every request instead of the host in the URL")
+	fs.StringVar(&c.SNI, "sni", c.SNI, "Send this TLS server name and verify the certificate against it")
+	fs.StringVar(&c.BindAddress, "bind-address", c.BindAddress, "Make the outbound connections from this local IP address")
+	fs.StringVar(&c.Proxy, "proxy", c.Proxy, "Send the requests through this HTTP or SOCKS5 proxy, such as http://host:port or socks5://host:port, instead of HTTP_PROXY and HTTPS_PROXY")
+	fs.BoolVar(&c.UseURLCredentials, "use-url-credentials", c.UseURLCredentials, "Authenticate the requests to a host with the userinfo stripped from its links")
+	fs.BoolVar(&c.FollowIframes, "follow-iframes", c.FollowIframes, "Also report the links in in-scope iframe documents as links of the embedding page")
+	fs.BoolVar(&c.ReportOutlinks, "report-outlinks", c.ReportOutlinks, "Report the number of unique in-scope links found on each page")
+	fs.BoolVar(&c.ReportSizes, "report-sizes", c.ReportSizes, "Report the number of bytes read from the body of each page")
+	fs.BoolVar(&c.ReportBroken, "report-broken", c.ReportBroken, "Report each page with a 4xx or 5xx status as broken, and list them on stderr once the crawl completes")
+	fs.BoolVar(&c.ReportCanonical, "report-canonical", c.ReportCanonical, "Print the final URL each requested URL landed on after its redirects, with the status chain, on completion")
+	fs.BoolVar(&c.IgnoreRobots, "ignore-robots", c.IgnoreRobots, "Fetch the paths disallowed by the robots.txt of the seed's host")
+	fs.StringVar(&c.Recheck, "recheck", c.Recheck, "Re-check only the broken URLs reported in the output of an earlier crawl, without crawling")
+	fs.StringVar(&c.Baseline, "baseline", c.Baseline, "Compare the content hash of each page with the snapshot saved by an earlier crawl and report the changed, unchanged, new and removed pages")
+	fs.StringVar(&c.Snapshot, "snapshot", c.Snapshot, "Save the content hash of each page to this file on completion, to be the -baseline of the next crawl")
+	fs.IntVar(&c.MinContentLength, "min-content-length", c.MinContentLength, "Report a 200 page with fewer bytes read from its body as thin content")
+	fs.BoolVar(&c.SkipThin, "skip-thin", c.SkipThin, "Do not crawl the links on the pages reported by -min-content-length")
+	fs.BoolVar(&c.ReportNormalization, "report-normalization", c.ReportNormalization, "Print what each link found on a page was cleaned to, or why it was dropped, on completion")
+	fs.BoolVar(&c.ProbeWellKnown, "probe-wellknown", c.ProbeWellKnown, "Request the well-known files such as /favicon.ico and /.well-known/security.txt at the start of the crawl and record their status")
+	fs.Var((*listValue)(&c.ProbePaths), "probe-paths", "Comma separated list of paths requested by -probe-wellknown")
+	fs.BoolVar(&c.ParsePDF, "parse-pdf", c.ParsePDF, "Find the links in PDF documents rather than reporting them as an invalid content type")
+	fs.BoolVar(&c.CaptureTLS, "capture-tls", c.CaptureTLS, "Record the HTTP version, TLS version and cipher suite of each response")
+	fs.BoolVar(&c.ReportMixedContent, "report-mixed-content", c.ReportMixedContent, "Report the images, scripts and other subresources of the https pages that are loaded over http")
+	fs.BoolVar(&c.CheckFragments, "check-fragments", c.CheckFragments, "Report the in-scope links whose fragment is not an id or name on the target page on completion")
+	fs.BoolVar(&c.ReportSkipped, "report-skipped", c.ReportSkipped, "Report each link that is found but not crawled along with the reason")
+	fs.IntVar(&c.CleanCache, "clean-cache", c.CleanCache, "Number of cleaned links to cache, 0 turns the cache off")
+	fs.StringVar(&c.UserAgents, "user-agents", c.UserAgents, "File of User-Agent strings, one per line, rotated across the requests")
+	fs.StringVar(&c.UserAgent, "user-agent", c.UserAgent, "The User-Agent sent with every request when there is no -user-agents file, empty sends Go's default")
+	fs.Var((*headerValue)(&c.Headers), "header", "A name:value header added to every request, repeat the flag for each header")
+	fs.StringVar(&c.LogLevel, "log-level", c.LogLevel, "Level of the logs written to stderr, debug logs the scope decision for every link")
+	fs.BoolVar(&c.Strict, "strict", c.Strict, "Stop the crawl and exit with an error on the first error")
+	fs.BoolVar(&c.DryRun, "validate", c.DryRun, "Check the seed and the options, print any problems and exit without crawling")
+	fs.IntVar(&c.OutputBuffer, "output-buffer", c.OutputBuffer, "Number of output records buffered for a slow consumer")
+	fs.StringVar(&c.OutputOverflow, "output-overflow", c.OutputOverflow, "What to do when the output buffer is full, block or drop")
+	fs.StringVar(&c.OnlyStatus, "only-status", c.OnlyStatus, "Only output the page records with these status codes, such as 404,500-599 or 5xx")
+	fs.BoolVar(&c.DedupeOutput, "dedupe-output", c.DedupeOutput, "Emit the record for each link found on a page at most once, the emitted links are kept in memory")
+	fs.StringVar(&c.OutputMode, "output-mode", c.OutputMode, "Output every link found with urls, or only the unique hosts or paths on completion")
+	fs.IntVar(&c.ReportPopular, "report-popular", c.ReportPopular, "Print the N most linked to pages on completion")
+	fs.BoolVar(&c.ReportLinkTypes, "report-link-types", c.ReportLinkTypes, "Print the number of unique URLs found on each type of element, such as anchors and images, on completion")
+	fs.IntVar(&c.ReportAnchorText, "report-anchor-text", c.ReportAnchorText, "Print the N most common link texts on completion")
+	fs.StringVar(&c.Resume, "resume", c.Resume, "Resume the crawl saved in this file, skipping the pages it fetched, and save the state of the crawl to it")
+	fs.Var(&c.ResumeEvery, "resume-every", "How often the state is saved to the -resume file while the crawl runs, 0 only saves it on completion")
+	fs.BoolVar(&c.Summary, "summary", c.Summary, "Print a summary of the URLs discovered, pages fetched by status class, errors and elapsed time to stderr on completion")
+	fs.IntVar(&c.CheckpointEvery, "checkpoint-every", c.CheckpointEvery, "Emit a checkpoint record with the counters so far every N fetched pages, 0 turns the checkpoints off")
+	fs.StringVar(&c.RunID, "run-id", c.RunID, "Identifier of the crawl sent as the X-Crawl-Id header and included in the results, empty generates one")
+	fs.Int64Var(&c.Seed, "seed", c.Seed, "Seed for the randomized behaviour so a run can be repeated, 0 uses a time based seed")
+	fs.Var(&c.RetryJitter, "retry-jitter", "Add a random pause of up to this long to each retry, such as 500ms")
+	fs.Var(&c.BackoffBase, "backoff-base", "Back off exponentially between the retries, pausing for this long, such as 500ms, and doubling it for each retry")
+	fs.Var(&c.BackoffMax, "backoff-max", "The longest pause between the retries with -backoff-base, 0 is uncapped")
+	fs.Var(&c.MaxRetryAfter, "max-retry-after", "The longest wait asked for by a Retry-After header before a retry, a longer wait is cut to it and 0 is unlimited")
+	fs.StringVar(&c.HealthAddr, "health-addr", c.HealthAddr, "Serve a /healthz endpoint on this address, such as :8081")
+	fs.Var(&c.HealthStall, "health-stall", "Report the crawl as unhealthy when no page has been fetched for this long")
+}
+
+// secrets are the options whose values are masked when they are recorded
+var secrets = map[string]bool{"basic-pass": true, "bearer": true}
+
+// urlOptions are the options holding a URL whose password is removed when
+// they are recorded
+var urlOptions = map[string]bool{"domain": true, "es-url": true, "proxy": true}
+
+// Map returns the options keyed by their flag names, it is used to record
+// the configuration a crawl was run with. The password and token are masked
+// so they are not written to the output, as are the values of the headers
+// since they can carry credentials such as Authorization and Cookie, and the
+// passwords in the URLs.
+func (c *Config) Map() map[string]string {
+	fs := flag.NewFlagSet("config", flag.ContinueOnError)
+	c.Flags(fs)
+	options := map[string]string{}
+	fs.VisitAll(func(f *flag.Flag) {
+		options[f.Name] = f.Value.String()
+		switch {
+		case secrets[f.Name] && len(options[f.Name]) > 0:
+			options[f.Name] = "********"
+		case f.Name == "header":
+			options[f.Name] = maskHeaders(c.Headers)
+		case urlOptions[f.Name]:
+			options[f.Name] = redactURL(options[f.Name])
+		}
+	})
+	return options
+}
+
+// maskHeaders returns the name:value headers with their values masked, the
+// names are kept to show which headers were sent.
+func maskHeaders(headers []string) string {
+	masked := make([]string, 0, len(headers))
+	for _, header := range headers {
+		name, _, _ := strings.Cut(header, ":")
+		masked = append(masked, strings.TrimSpace(name)+": ********")
+	}
+	return strings.Join(masked, "\n")
+}
+
+// redactURL returns the URL without the password in its userinfo, the user
+// name is kept. A value that is not a URL is returned as it is.
+func redactURL(value string) string {
+	u, err := url.Parse(value)
+	if err != nil || u.User == nil {
+		return value
+	}
+	u.User = url.User(u.User.Username())
+	return u.String()
+}
+
+// NewRunID returns an identifier for a crawl from the time it started and a
+// random suffix, it is not taken from the seeded source so that two runs with
+// the same -seed are still told apart.
+func NewRunID() string {
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return time.Now().UTC().Format("20060102T150405") + "-" + hex.EncodeToString(suffix)
+}
+
+// Crawler returns a crawler.Crawler for the configured domain and scope
+func (c *Config) Crawler(output chan<- string, errors chan<- error, fetch chan<- *http.Response) *crawler.Crawler {
+	crawl := crawler.NewCrawler(c.Domain, output, errors, fetch)
+	if c.Scope == "seed-path" {
+		crawl.PathPrefix = crawler.SeedPathPrefix(crawl.Domain)
+	}
+	crawl.SameDepth = c.SameDepth
+	crawl.IncludeSubdomains = c.Subdomains
+	crawl.CaptureHeaders = c.CaptureHeaders
+	crawl.LinkRels = c.LinkRels
+	crawl.AllowedSchemes = c.AllowedSchemes
+	crawl.StripParams = c.StripParams
+	if c.CollapseIndex {
+		crawl.IndexFiles = c.IndexFiles
+	}
+	crawl.CountAnchorText = c.ReportAnchorText > 0
+	crawl.CountLinkTypes = c.ReportLinkTypes
+	crawl.PreferHTTPS = c.PreferHTTPS
+	crawl.SamePageFragments = c.SamePageFragments
+	crawl.RespectNofollow = c.RespectNofollow
+	crawl.FollowIframes = c.FollowIframes
+	crawl.ReportOutlinks = c.ReportOutlinks
+	crawl.ReportSizes = c.ReportSizes
+	crawl.RunID = c.RunID
+	crawl.ReportBroken = c.ReportBroken
+	crawl.ReportCanonical = c.ReportCanonical
+	crawl.MinContentLength = c.MinContentLength
+	crawl.SkipThin = c.SkipThin
+	crawl.ReportNormalization = c.ReportNormalization
+	crawl.CheckFragments = c.CheckFragments
+	crawl.ReportMixedContent = c.ReportMixedContent
+	crawl.CaptureTLS = c.CaptureTLS
+	crawl.ParsePDF = c.ParsePDF
+	crawl.BodyTimeout = time.Duration(c.BodyTimeout)
+	crawl.DedupeOutput = c.DedupeOutput
+	crawl.Logger = c.Logger()
+	crawl.OmitLinks = c.OutputMode != "urls"
+	crawl.ReportSkipped = c.ReportSkipped
+	if c.Baseline != "" || c.Snapshot != "" {
+		crawl.Hashes = data.NewSnapshot()
+	}
+	if c.UseURLCredentials {
+		crawl.Credentials = data.NewCredentials()
+	}
+	crawl.CleanCache = nil
+	if c.CleanCache > 0 {
+		crawl.CleanCache = crawler.NewURLCache(c.CleanCache)
+	}
+	return crawl
+}
+
+// Logger returns the logger that writes the logs at or above the log level
+// to stderr, away from the records written to stdout.
+func (c *Config) Logger() *slog.Logger {
+	var level slog.Level
+	level.UnmarshalText([]byte(c.LogLevel)) // Checked by Validate, info otherwise
+	return slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level}))
+}
+
+// Fronter returns a fronter.Fronter with the configured depth and age limits
+func (c *Config) Fronter(seen *data.Data, output chan<- string, done chan struct{}) *fronter.Fronter {
+	front := fronter.NewFronter(seen, output, done)
+	front.MaxDepth = c.MaxDepth
+	front.HostDepth = c.HostDepth
+	front.RecordLeaves = c.ReportLeafLinks
+	front.TTL = time.Duration(c.FrontierTTL)
+	front.MaxIdle = time.Duration(c.MaxIdle)
+	front.MaxPages = c.MaxPages
+	front.ReportSkipped = c.ReportSkipped
+	return front
+}
+
+// Fetcher returns a fetcher.Fetcher with the configured request options, an
+// error is returned if the User-Agent file cannot be loaded.
+func (c *Config) Fetcher(output chan<- string, errors chan<- error, fetch chan *http.Response, done chan struct{}) (*fetcher.Fetcher, error) {
+	var agents []string
+	if c.UserAgents != "" {
+		loaded, err := fetcher.LoadUserAgents(c.UserAgents)
+		if err != nil {
+			return nil, err
+		}
+		agents = loaded
+	}
+
+	f := fetcher.NewFetcher(c.Workers, 3, time.Duration(c.Timeout), output, errors, fetch, done)
+	f.UserAgent = c.UserAgent
+	for _, header := range c.Headers {
+		name, value, err := splitHeader(header)
+		if err != nil {
+			return nil, err
+		}
+		if f.Headers == nil {
+			f.Headers = http.Header{}
+		}
+		f.Headers.Add(name, value)
+	}
+	f.MaxInFlight = c.MaxInFlight
+	f.MaxBandwidth = c.MaxBandwidth
+	f.MaxBytes = c.MaxBytes
+	f.BreakerThreshold = c.BreakerThreshold
+	f.BreakerCooldown = time.Duration(c.BreakerCooldown)
+	f.RetryJitter = time.Duration(c.RetryJitter)
+	f.BackoffBase = time.Duration(c.BackoffBase)
+	f.BackoffMax = time.Duration(c.BackoffMax)
+	f.MaxRetryAfter = time.Duration(c.MaxRetryAfter)
+	f.MaxErrorsPerHost = c.MaxErrorsPerHost
+	f.ReportSkipped = c.ReportSkipped
+	f.UserAgents = agents
+	if c.DNSPrefetch {
+		f.Resolver = fetcher.NewResolver(c.DNSConcurrency)
+	}
+	if c.Cookies {
+		f.EnableCookies()
+	}
+	f.HostOverride = c.HostOverride
+	f.BasicUser = c.BasicUser
+	f.BasicPass = c.BasicPass
+	f.Bearer = c.Bearer
+	f.MaxRedirects = c.MaxRedirects
+	if seed, err := url.Parse(c.Domain); err == nil {
+		f.AuthHost = seed.Host
+	}
+	f.RunID = c.RunID
+	f.Compression = c.Compression
+	f.RetryEmptyBody = c.RetryEmptyBody
+	f.CrawlDelay = time.Duration(c.Delay)
+	f.AdaptiveTarget = time.Duration(c.AdaptiveDelay)
+	f.AdaptiveMin = time.Duration(c.AdaptiveMin)
+	f.AdaptiveMax = time.Duration(c.AdaptiveMax)
+	f.Decode = crawler.DecodeBody
+	if c.SNI != "" {
+		f.SetServerName(c.SNI)
+	}
+	if c.BindAddress != "" {
+		if err := f.SetLocalAddr(c.BindAddress); err != nil {
+			return nil, err
+		}
+	}
+	if c.Proxy != "" {
+		if err := f.SetProxy(c.Proxy); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// Relay returns the relay.Relay that buffers the output records, filtering
+// the page records by their status when -only-status is set.
+func (c *Config) Relay() (*relay.Relay, error) {
+	r, err := relay.New(c.OutputBuffer, c.OutputOverflow)
+	if err != nil || c.OnlyStatus == "" {
+		return r, err
+	}
+	filter, err := relay.ParseStatusFilter(c.OnlyStatus)
+	if err != nil {
+		return nil, err
+	}
+	r.Filter = filter.Allow
+	return r, nil
+}
+
+// Sink returns the OpenSearch sink when a cluster URL is configured, nil
+// otherwise.
+func (c *Config) Sink() *sink.OpenSearch {
+	if c.ESURL == "" {
+		return nil
+	}
+	return sink.NewOpenSearch(c.ESURL, c.ESIndex, c.ESBatch)
+}
+
+// Kafka returns the Kafka sink when brokers are configured, nil otherwise.
+// An error is returned if there is no topic or the producer is not built in.
+func (c *Config) Kafka() (*sink.Kafka, error) {
+	if len(c.KafkaBrokers) == 0 {
+		return nil, nil
+	}
+	if c.KafkaTopic == "" {
+		return nil, fmt.Errorf("Error, -kafka-topic is required with -kafka-brokers")
+	}
+	producer, err := sink.NewKafkaProducer(c.KafkaBrokers)
+	if err != nil {
+		return nil, err
+	}
+	return sink.NewKafka(producer, c.KafkaTopic, c.KafkaBatch), nil
+}
+
+// Archive returns the WARC sink when an archive path is configured, nil
+// otherwise. An error is returned if the file cannot be created.
+func (c *Config) Archive() (*sink.WARC, error) {
+	if c.WARC == "" {
+		return nil, nil
+	}
+	return sink.NewWARC(c.WARC)
+}
+
+// Writer opens the file the output records are written to when an output
+// path is configured, nil otherwise. With Append the existing records are
+// kept so an interrupted crawl can be resumed into the same file.
+func (c *Config) Writer() (*os.File, error) {
+	if c.Output == "" {
+		return nil, nil
+	}
+	mode := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if c.Append {
+		mode = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	}
+	file, err := os.OpenFile(c.Output, mode, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("Error opening the output file %s: %v", c.Output, err)
+	}
+	return file, nil
+}
+
+// LoadBaseline loads the content hashes of the earlier crawl to compare the
+// crawl with when a baseline is configured, nil otherwise.
+func (c *Config) LoadBaseline() (*data.Snapshot, error) {
+	if c.Baseline == "" {
+		return nil, nil
+	}
+	return data.LoadSnapshot(c.Baseline)
+}
+
+// Rechecks loads the broken URLs to re-check from the report of an earlier
+// crawl when one is configured, nil otherwise.
+func (c *Config) Rechecks() ([]string, error) {
+	if c.Recheck == "" {
+		return nil, nil
+	}
+	return fetcher.LoadBroken(c.Recheck)
+}
+
+// Listen creates the Unix socket the output records are streamed to when a
+// socket path is configured, nil otherwise.
+func (c *Config) Listen() (*sink.Socket, error) {
+	if c.Socket == "" {
+		return nil, nil
+	}
+	return sink.Listen(c.Socket)
+}
+
+// Health returns the health.Checker for the crawl when a health address is
+// configured, nil otherwise. progress is the count of the pages fetched.
+func (c *Config) Health(progress func() int) *health.Checker {
+	if c.HealthAddr == "" {
+		return nil
+	}
+	return health.NewChecker(progress, time.Duration(c.HealthStall))
+}
+
+// listValue is a comma separated list flag, setting it replaces the list
+type listValue []string
+
+func (l *listValue) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(*l, ",")
+}
+
+func (l *listValue) Set(value string) error {
+	*l = nil
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); len(item) > 0 {
+			*l = append(*l, item)
+		}
+	}
+	return nil
+}
+
+// headerValue is a name:value header flag, each use adds a header to the list.
+// The value may hold several headers on separate lines, as the list does when
+// it is printed, since a header value can contain a comma.
+type headerValue []string
+
+func (h *headerValue) String() string {
+	if h == nil {
+		return ""
+	}
+	return strings.Join(*h, "\n")
+}
+
+func (h *headerValue) Set(value string) error {
+	for _, header := range strings.Split(value, "\n") {
+		if header = strings.TrimSpace(header); len(header) == 0 {
+			continue
+		}
+		if _, _, err := splitHeader(header); err != nil {
+			return err
+		}
+		*h = append(*h, header)
+	}
+	return nil
+}
+
+// splitHeader splits a name:value header, the whitespace around the name and
+// the value is trimmed and the value may be empty.
+func splitHeader(header string) (string, string, error) {
+	name, value, found := strings.Cut(header, ":")
+	name = strings.TrimSpace(name)
+	if !found || len(name) == 0 || strings.ContainsAny(name, " \t") {
+		return "", "", fmt.Errorf("Invalid header %s, expected name:value such as X-Api-Key:secret", header)
+	}
+	return name, strings.TrimSpace(value), nil
+}
+
+// Depths is a flag of host=depth pairs separated by commas, it is an object
+// of hosts and depths in the config file. The hosts are lower cased.
+type Depths map[string]int
+
+func (h *Depths) String() string {
+	if h == nil || len(*h) == 0 {
+		return ""
+	}
+	pairs := make([]string, 0, len(*h))
+	for host, depth := range *h {
+		pairs = append(pairs, fmt.Sprintf("%s=%d", host, depth))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (h *Depths) Set(value string) error {
+	depths := map[string]int{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); len(item) == 0 {
+			continue
+		}
+		host, raw, found := strings.Cut(item, "=")
+		host = strings.ToLower(strings.TrimSpace(host))
+		depth, err := strconv.Atoi(strings.TrimSpace(raw))
+		if !found || len(host) == 0 || err != nil || depth < 0 {
+			return fmt.Errorf("Invalid host depth %s, expected host=depth such as example.com=3", item)
+		}
+		depths[host] = depth
+	}
+	*h = depths
+	return nil
+}
+
+func (h *Depths) UnmarshalJSON(b []byte) error {
+	var depths map[string]int
+	if err := json.Unmarshal(b, &depths); err != nil {
+		return fmt.Errorf("Host depths must be an object such as {\"example.com\": 3}: %v", err)
+	}
+	*h = map[string]int{}
+	for host, depth := range depths {
+		(*h)[strings.ToLower(host)] = depth
+	}
+	return nil
+}
+
+// Duration is a time.Duration flag that is written as a string such as "30s"
+// in the config file.
+type Duration time.Duration
+
+func (d *Duration) String() string {
+	if d == nil {
+		return "0s"
+	}
+	return time.Duration(*d).String()
+}
+
+func (d *Duration) Set(value string) error {
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var value string
+	if err := json.Unmarshal(b, &value); err != nil {
+		return fmt.Errorf("Durations must be a string such as \"30s\": %v", err)
+	}
+	return d.Set(value)
+}
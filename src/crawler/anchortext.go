@@ -0,0 +1,99 @@
+package crawler
+
+// Count how often each anchor text, i.e. "Read more" or "Home", is used for
+// the links across the site so that the most common can be reported once the
+// crawl completes.
+
+import (
+	"sort"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// AnchorText is the number of links on the crawled pages with the Text
+type AnchorText struct {
+	Text  string
+	Count int
+}
+
+// anchorText returns the text of an element with the whitespace collapsed
+// and in lower case so the same text is grouped however it is formatted.
+func anchorText(n *html.Node) string {
+	var text strings.Builder
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			text.WriteString(n.Data)
+			text.WriteString(" ")
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(n)
+	return strings.ToLower(strings.Join(strings.Fields(text.String()), " "))
+}
+
+// recordAnchorTexts counts the texts of the anchors with an href on a parsed
+// page when CountAnchorText is set, anchors without text such as image links
+// are not counted.
+func (c *Crawler) recordAnchorTexts(doc *html.Node) {
+	if !c.CountAnchorText {
+		return
+	}
+	counts := map[string]int{}
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.DataAtom == atom.A {
+			for _, attr := range n.Attr {
+				if attr.Key == "href" {
+					if text := anchorText(n); len(text) > 0 {
+						counts[text]++
+					}
+					break
+				}
+			}
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(doc)
+
+	// The page is counted before the lock is taken so the pages parsed by
+	// the other workers are only held up while the totals are added
+	c.anchorMu.Lock()
+	defer c.anchorMu.Unlock()
+	if c.anchorTexts == nil {
+		c.anchorTexts = map[string]int{}
+	}
+	for text, count := range counts {
+		c.anchorTexts[text] += count
+	}
+}
+
+// AnchorTexts returns the n most common anchor texts ordered by their count
+// with ties broken by the text so the ranking is deterministic. A value of n
+// that is zero or negative returns every text.
+func (c *Crawler) AnchorTexts(n int) []AnchorText {
+	c.anchorMu.Lock()
+	ranked := make([]AnchorText, 0, len(c.anchorTexts))
+	for text, count := range c.anchorTexts {
+		ranked = append(ranked, AnchorText{Text: text, Count: count})
+	}
+	c.anchorMu.Unlock()
+
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].Count != ranked[j].Count {
+			return ranked[i].Count > ranked[j].Count
+		}
+		return ranked[i].Text < ranked[j].Text
+	})
+
+	if n > 0 && n < len(ranked) {
+		ranked = ranked[:n]
+	}
+	return ranked
+}
@@ -0,0 +1,83 @@
+package crawler
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+// Count the anchor texts of the same page parsed by several goroutines and
+// test the texts are grouped regardless of their case and whitespace, that
+// links without text are not counted and the top N are ranked by count.
+func Test_AnchorTexts(t *testing.T) {
+	page := `<html><body>
+		<a href="/">Home</a>
+		<a href="/blog/1">Read more</a>
+		<a href="/blog/2">READ
+			more</a>
+		<a href="/blog/3"><span>Read</span> <em>More</em></a>
+		<a href="/about">  home </a>
+		<a href="/contact">Contact</a>
+		<a href="/logo"><img src="/logo.png"></a>
+		<a name="anchor">Not a link</a>
+	</body></html>`
+
+	c := NewCrawler(seedDomain, nil, nil, nil)
+	c.CountAnchorText = true
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			doc, err := html.Parse(strings.NewReader(page))
+			if err != nil {
+				t.Errorf("Failed to parse the page: %v", err)
+				return
+			}
+			c.recordAnchorTexts(doc)
+		}()
+	}
+	wg.Wait()
+
+	expected := []AnchorText{{Text: "read more", Count: 15}, {Text: "home", Count: 10}, {Text: "contact", Count: 5}}
+	if texts := c.AnchorTexts(0); fmt.Sprint(texts) != fmt.Sprint(expected) {
+		t.Errorf("The anchor texts were %v, expected %v", texts, expected)
+	}
+	if texts := c.AnchorTexts(2); fmt.Sprint(texts) != fmt.Sprint(expected[:2]) {
+		t.Errorf("The top 2 anchor texts were %v, expected %v", texts, expected[:2])
+	}
+}
+
+// Parse a page with entities in its title, text and anchor texts and test
+// they are decoded once by the parser, so an escaped entity such as &amp;amp;
+// is kept as the text &amp; rather than decoded twice.
+func Test_DecodedEntities(t *testing.T) {
+	doc, err := html.Parse(strings.NewReader(`<html><head><title>Tom &amp; Jerry&#39;s &quot;Page&quot;</title></head><body>
+		<p>Caf&eacute; &lt;menu&gt; &amp;amp;</p>
+		<a href="/faq">Q&amp;A</a>
+		<a href="/quote">It&#x27;s &nbsp;here</a>
+	</body></html>`))
+	if err != nil {
+		t.Fatalf("Failed to parse the page: %v", err)
+	}
+
+	title, text := pageText(doc)
+	if title != `Tom & Jerry's "Page"` {
+		t.Errorf("The title is [%s], expected the entities to be decoded", title)
+	}
+	if !strings.Contains(text, "Café <menu> &amp;") {
+		t.Errorf("The text is [%s], expected the entities to be decoded once", text)
+	}
+
+	c := NewCrawler(seedDomain, nil, nil, nil)
+	c.CountAnchorText = true
+	c.recordAnchorTexts(doc)
+	expected := []AnchorText{{Text: "it's here", Count: 1}, {Text: "q&a", Count: 1}}
+	if texts := c.AnchorTexts(0); fmt.Sprint(texts) != fmt.Sprint(expected) {
+		t.Errorf("The anchor texts were %v, expected %v", texts, expected)
+	}
+}
@@ -0,0 +1,81 @@
+package crawler
+
+// The <base href> of a document changes the URL its relative links are
+// resolved against, i.e. page.html on a page with a base of
+// https://domain.com/app/ is https://domain.com/app/page.html.
+
+import (
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// baseHref returns the href of the first base element in a document that has
+// one, the later base elements are ignored as they are by browsers.
+func baseHref(doc *html.Node) (href string, found bool) {
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if found {
+			return
+		}
+		if n.Type == html.ElementNode && n.DataAtom == atom.Base {
+			for _, a := range n.Attr {
+				if a.Key == "href" {
+					href, found = strings.TrimSpace(a.Val), true
+					return
+				}
+			}
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(doc)
+	return href, found
+}
+
+// documentBase returns the base URL of a document resolved against the page
+// it is on, as the href may itself be relative, or nil when the document has
+// no base element or its href cannot be parsed.
+func (c *Crawler) documentBase(page *url.URL, doc *html.Node) *url.URL {
+	href, found := baseHref(doc)
+	if !found {
+		return nil
+	}
+	ref, err := url.Parse(href)
+	if err != nil {
+		return nil
+	}
+	if page == nil {
+		page = c.Domain
+	}
+	return page.ResolveReference(ref)
+}
+
+// linkBase returns the URL the links of a document are resolved against, its
+// <base href> when it has one and otherwise the page itself. A schemeless
+// link such as README.md is then relative to the page as it is in a browser,
+// rather than read as a host by cleanUrl.
+func (c *Crawler) linkBase(page *url.URL, doc *html.Node) *url.URL {
+	if base := c.documentBase(page, doc); base != nil {
+		return base
+	}
+	return page
+}
+
+// resolveBase resolves a relative link against the base of its document, the
+// links with a scheme and the fragments of the page are returned as they are
+// to be cleaned as normal, as are all of the links without a base.
+func resolveBase(base *url.URL, rawUrl string) string {
+	rawUrl = strings.TrimSpace(rawUrl)
+	if base == nil || len(rawUrl) == 0 || strings.HasPrefix(rawUrl, "#") {
+		return rawUrl
+	}
+	ref, err := url.Parse(rawUrl)
+	if err != nil || len(ref.Scheme) > 0 {
+		return rawUrl
+	}
+	return base.ResolveReference(ref).String()
+}
@@ -0,0 +1,62 @@
+package crawler
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// Find the links on pages with an absolute base, a relative base, more than
+// one base and no base, and test that the relative links are resolved against
+// the first base while the absolute links, the fragments and the pages without
+// a base are resolved against the page.
+func Test_BaseHref(t *testing.T) {
+	testCases := []struct {
+		name     string
+		head     string
+		expected []string
+	}{
+		{
+			"absolute base",
+			`<base href="https://example.com/app/">`,
+			[]string{"https://example.com/app/page.html", "https://example.com/app/sub/index.html", "https://example.com/top", "https://example.com/app/?q=1", "https://example.com/up", "https://example.com/other", "https://example.com"},
+		},
+		{
+			"relative base",
+			`<base href="../app/">`,
+			[]string{"https://example.com/app/page.html", "https://example.com/app/sub/index.html", "https://example.com/top", "https://example.com/app/?q=1", "https://example.com/up", "https://example.com/other", "https://example.com"},
+		},
+		{
+			"first base wins",
+			`<base target="_blank"><base href="/first/"><base href="/second/">`,
+			[]string{"https://example.com/first/page.html", "https://example.com/first/sub/index.html", "https://example.com/top", "https://example.com/first/?q=1", "https://example.com/up", "https://example.com/other", "https://example.com"},
+		},
+		{
+			"no base",
+			``,
+			[]string{"https://example.com/docs/page.html", "https://example.com/docs/sub/index.html", "https://example.com/top", "https://example.com/docs/page.html?q=1", "https://example.com/up", "https://example.com/other", "https://example.com"},
+		},
+	}
+
+	for _, test := range testCases {
+		body := `<html><head>` + test.head + `</head><body>
+		<a href="page.html">Page</a>
+		<a href="sub/index.html">Sub</a>
+		<a href="/top">Top</a>
+		<a href="?q=1">Query</a>
+		<a href="../up">Up</a>
+		<a href="https://example.com/other">Other</a>
+		<a href="#fragment">Fragment</a>
+		</body></html>`
+
+		c := NewCrawler(seedDomain, nil, nil, nil)
+		page, _ := url.Parse(seedDomain + "/docs/page.html")
+		links, err := c.startFindLinks(page, []byte(body))
+		if err != nil {
+			t.Fatalf("Failed to find the links with the %s: %v", test.name, err)
+		}
+		if strings.Join(links, " ") != strings.Join(test.expected, " ") {
+			t.Errorf("The links with the %s are %v, expected %v", test.name, links, test.expected)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package crawler
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+// largePage builds an html page with a mix of content and the kinds of
+// links found on a large site, relative, absolute, fragments, queries and
+// out of scope links, nested within the page layout.
+func largePage(links int) []byte {
+	var b bytes.Buffer
+	b.WriteString("<html><head><title>Large page</title><script>var x = 1;</script></head><body>\n")
+	for i := 0; i < links; i++ {
+		if i%50 == 0 {
+			b.WriteString("<div class=\"section\"><ul>\n")
+		}
+		switch i % 6 {
+		case 0:
+			fmt.Fprintf(&b, "<li><a href=\"/section/%d/page\">Page %d</a></li>\n", i/50, i)
+		case 1:
+			fmt.Fprintf(&b, "<li><a href=\"https://example.com/blog/%d?ref=nav\">Post %d</a></li>\n", i, i)
+		case 2:
+			fmt.Fprintf(&b, "<li><a href=\"#item-%d\">Item %d</a></li>\n", i, i)
+		case 3:
+			fmt.Fprintf(&b, "<li><a href=\" https://other.com/%d \">External %d</a></li>\n", i, i)
+		case 4:
+			fmt.Fprintf(&b, "<li><p>Some text about <span>item %d</span> with a <a href=\"/tags/%d\">tag</a></p></li>\n", i, i%20)
+		case 5:
+			fmt.Fprintf(&b, "<li><img src=\"/img/%d.png\"><a href=\"//example.com/about\">About</a></li>\n", i)
+		}
+		if i%50 == 49 {
+			b.WriteString("</ul></div>\n")
+		}
+	}
+	b.WriteString("</body></html>")
+	return b.Bytes()
+}
+
+// Benchmark extracting the links from an already parsed large page
+func BenchmarkFindLinks(b *testing.B) {
+	doc, err := html.Parse(bytes.NewReader(largePage(5000)))
+	if err != nil {
+		b.Fatalf("Failed to parse the fixture: %v", err)
+	}
+	c := NewCrawler(seedDomain, nil, nil, nil)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.findLinks(nil, doc)
+	}
+}
+
+// Benchmark cleaning every href found on a large page without the cache
+func BenchmarkCleanUrl(b *testing.B) {
+	benchmarkCleanUrl(b, nil)
+}
+
+// Benchmark cleaning every href found on a large page with the cache, as
+// the same page is cleaned on each iteration the hrefs are found in it.
+func BenchmarkCleanUrlCached(b *testing.B) {
+	benchmarkCleanUrl(b, NewURLCache(DefaultCleanCacheSize))
+}
+
+func benchmarkCleanUrl(b *testing.B, cache *URLCache) {
+	doc, err := html.Parse(bytes.NewReader(largePage(5000)))
+	if err != nil {
+		b.Fatalf("Failed to parse the fixture: %v", err)
+	}
+	c := NewCrawler(seedDomain, nil, nil, nil)
+	c.CleanCache = cache
+	page, _ := url.Parse(seedDomain + "/section/1/page")
+	hrefs := c.findLinks(nil, doc)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, href := range hrefs {
+			c.cleanUrl(page, href.href)
+		}
+	}
+}
+
+// Benchmark parsing a large page and returning its cleaned links
+func BenchmarkStartFindLinks(b *testing.B) {
+	body := largePage(5000)
+	c := NewCrawler(seedDomain, nil, nil, nil)
+	page, _ := url.Parse(seedDomain)
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(body)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := c.startFindLinks(page, body); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// Benchmark processing a large page response, the output records are
+// drained in the background.
+func BenchmarkProcessResponse(b *testing.B) {
+	body := largePage(5000)
+	output := make(chan string, 1000)
+	go func() {
+		for range output {
+		}
+	}()
+	defer close(output)
+	c := NewCrawler(seedDomain, output, nil, nil)
+	req, _ := http.NewRequest(http.MethodGet, seedDomain, nil)
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(body)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		resp := &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": {"text/html"}},
+			Body:       io.NopCloser(bytes.NewReader(body)),
+			Request:    req,
+		}
+		if _, err := c.ProcessResponse(resp); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// Test that the benchmark fixture produces the expected number of links so
+// the benchmarks measure a representative page.
+func Test_largePage(t *testing.T) {
+	c := NewCrawler(seedDomain, nil, nil, nil)
+	page, _ := url.Parse(seedDomain)
+	links, err := c.startFindLinks(page, largePage(600))
+	if err != nil {
+		t.Fatalf("Failed to find the links in the fixture: %v", err)
+	}
+	if len(links) != 600 {
+		t.Errorf("Expected 600 links in the fixture, got %d", len(links))
+	}
+	for _, link := range links {
+		if len(link) > 0 && !strings.HasPrefix(link, "https://example.com") {
+			t.Errorf("Unexpected link in the fixture: %s", link)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package crawler
+
+// A bounded cache of cleaned URLs, on link heavy sites the same hrefs are
+// found on most pages so the results of cleanUrl are kept to save parsing
+// and normalizing them again.
+
+import (
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// cacheKey identifies a raw href along with the parts of the page it was
+// found on that the cleaned result can depend on.
+type cacheKey struct {
+	scheme string
+	host   string
+	path   string
+	query  string
+	raw    string
+}
+
+// URLCache is a concurrency safe map of raw hrefs to their cleaned URLs, it
+// is bounded by the size it is created with and is emptied when it is full.
+type URLCache struct {
+	mu      sync.Mutex
+	size    int
+	entries map[cacheKey]cleanResult
+}
+
+// NewURLCache returns a pointer to a crawler.URLCache holding up to size
+// entries.
+func NewURLCache(size int) *URLCache {
+	if size < 1 {
+		size = 1
+	}
+	return &URLCache{
+		size:    size,
+		entries: make(map[cacheKey]cleanResult, size),
+	}
+}
+
+// Len returns the number of entries in the cache
+func (u *URLCache) Len() int {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return len(u.entries)
+}
+
+func (u *URLCache) get(key cacheKey) (cleanResult, bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	result, ok := u.entries[key]
+	return result, ok
+}
+
+// add stores the result of cleaning a URL, when the cache is full it is
+// cleared rather than tracking the least recently used entries.
+func (u *URLCache) add(key cacheKey, result cleanResult) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if len(u.entries) >= u.size {
+		clear(u.entries)
+	}
+	u.entries[key] = result
+}
+
+// newCacheKey builds the key for a trimmed href found on page, only the parts
+// of the page that the href is resolved against are included so hrefs that
+// are repeated across pages share an entry. Absolute links do not depend on
+// the page, links starting with / depend on its scheme and host and any
+// other link, such as a fragment, depends on the whole page.
+func newCacheKey(page *url.URL, rawUrl string) cacheKey {
+	key := cacheKey{raw: rawUrl}
+	if strings.HasPrefix(rawUrl, "http://") || strings.HasPrefix(rawUrl, "https://") {
+		return key
+	}
+	key.scheme, key.host = page.Scheme, page.Host
+	if strings.HasPrefix(rawUrl, "/") {
+		return key
+	}
+	key.path, key.query = page.Path, page.RawQuery
+	return key
+}
@@ -0,0 +1,67 @@
+package crawler
+
+// Map each requested URL to the URL it finally landed on after following its
+// redirects, with the status of every hop, for auditing a URL migration once
+// the crawl completes.
+
+import (
+	"net/http"
+	"sort"
+)
+
+// Canonical is the final URL a requested URL landed on and the status codes
+// of the chain of responses, the last is the status of the final URL.
+type Canonical struct {
+	Requested string
+	Final     string
+	Statuses  []int
+}
+
+// RedirectChain returns the responses of the redirect chain that ended in a
+// response, in the order they were received, by following the responses
+// that caused each redirect back to the first request. The first response
+// is for the URL that was originally requested and the last is resp.
+func RedirectChain(resp *http.Response) []*http.Response {
+	chain := []*http.Response{resp}
+	for r := resp; r.Request.Response != nil && r.Request.Response.Request != nil; r = r.Request.Response {
+		chain = append([]*http.Response{r.Request.Response}, chain...)
+	}
+	return chain
+}
+
+// recordCanonical records the final URL of a response against the URL that
+// was requested when ReportCanonical is set, a URL that was not redirected
+// is its own final URL.
+func (c *Crawler) recordCanonical(resp *http.Response) {
+	if !c.ReportCanonical {
+		return
+	}
+	chain := RedirectChain(resp)
+	requested := chain[0].Request.URL.String()
+	statuses := make([]int, len(chain))
+	for i, hop := range chain {
+		statuses[i] = hop.StatusCode
+	}
+	c.canonicalMu.Lock()
+	defer c.canonicalMu.Unlock()
+	if c.canonicals == nil {
+		c.canonicals = map[string]Canonical{}
+	}
+	c.canonicals[requested] = Canonical{Requested: requested, Final: resp.Request.URL.String(), Statuses: statuses}
+}
+
+// Canonicals returns the final URL of every requested URL that has been
+// processed ordered by the requested URL.
+func (c *Crawler) Canonicals() []Canonical {
+	c.canonicalMu.Lock()
+	canonicals := make([]Canonical, 0, len(c.canonicals))
+	for _, canonical := range c.canonicals {
+		canonicals = append(canonicals, canonical)
+	}
+	c.canonicalMu.Unlock()
+
+	sort.Slice(canonicals, func(i, j int) bool {
+		return canonicals[i].Requested < canonicals[j].Requested
+	})
+	return canonicals
+}
@@ -0,0 +1,49 @@
+package crawler
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Serve a page behind a chain of two redirects, a page behind one and a page
+// that is not redirected, and test that each requested URL is mapped to the
+// page it landed on with the status of each hop.
+func Test_Canonicals(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/old":
+			http.Redirect(w, r, "/moved", http.StatusMovedPermanently)
+		case "/moved":
+			http.Redirect(w, r, "/new", http.StatusFound)
+		case "/short":
+			http.Redirect(w, r, "/new?from=short", http.StatusTemporaryRedirect)
+		default:
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<html><a href="/">Home</a></html>`)
+		}
+	}))
+	defer ts.Close()
+
+	c := NewCrawler(ts.URL, make(chan string, 100), nil, nil)
+	c.ReportCanonical = true
+	for _, path := range []string{"/short", "/old", "/new"} {
+		res, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatalf("Failed to get %s from the httptest server", path)
+		}
+		if _, err := c.ProcessResponse(res); err != nil {
+			t.Fatalf("Failed to process %s: %v", path, err)
+		}
+	}
+
+	expected := []Canonical{
+		{Requested: ts.URL + "/new", Final: ts.URL + "/new", Statuses: []int{200}},
+		{Requested: ts.URL + "/old", Final: ts.URL + "/new", Statuses: []int{301, 302, 200}},
+		{Requested: ts.URL + "/short", Final: ts.URL + "/new?from=short", Statuses: []int{307, 200}},
+	}
+	if canonicals := c.Canonicals(); fmt.Sprint(canonicals) != fmt.Sprint(expected) {
+		t.Errorf("The canonical mapping was %v, expected %v", canonicals, expected)
+	}
+}
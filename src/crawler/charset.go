@@ -0,0 +1,75 @@
+package crawler
+
+// Transcode the html pages that are not UTF-8 before they are parsed, so the
+// links with characters outside ASCII are read as they were written rather
+// than as the bytes of another charset.
+
+import (
+	"bytes"
+	"mime"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"golang.org/x/net/html/charset"
+)
+
+// decodeCharset transcodes an html body to UTF-8 by the charset of its
+// Content-Type header, or the charset of a meta tag in the first 1024 bytes
+// of the page when the header has none. A body without a charset, or with
+// one that is unknown, is returned as it is and parsed as UTF-8.
+func decodeCharset(contentType string, body []byte) []byte {
+	var label string
+	if _, params, err := mime.ParseMediaType(contentType); err == nil {
+		label = params["charset"]
+	}
+	if len(label) == 0 {
+		label = metaCharset(body)
+	}
+	encoding, name := charset.Lookup(label)
+	if encoding == nil || name == "utf-8" {
+		return body
+	}
+	decoded, err := encoding.NewDecoder().Bytes(body)
+	if err != nil {
+		return body
+	}
+	return decoded
+}
+
+// metaCharset returns the charset of a <meta charset> tag or of a meta
+// http-equiv Content-Type in the start of a page, it is empty when there is
+// neither.
+func metaCharset(body []byte) string {
+	if len(body) > 1024 {
+		body = body[:1024]
+	}
+	z := html.NewTokenizer(bytes.NewReader(body))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return ""
+		case html.StartTagToken, html.SelfClosingTagToken:
+			token := z.Token()
+			if token.DataAtom != atom.Meta {
+				continue
+			}
+			var httpEquiv, content string
+			for _, attr := range token.Attr {
+				switch strings.ToLower(attr.Key) {
+				case "charset":
+					return strings.TrimSpace(attr.Val)
+				case "http-equiv":
+					httpEquiv = attr.Val
+				case "content":
+					content = attr.Val
+				}
+			}
+			if strings.EqualFold(httpEquiv, "content-type") {
+				if _, params, err := mime.ParseMediaType(content); err == nil && len(params["charset"]) > 0 {
+					return params["charset"]
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package crawler
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Spawn a test server that sends a page with an accented path in a link as
+// Latin-1 and Shift_JIS bytes, declared by the Content-Type header or by a
+// meta tag, and test that the link is the same as on the UTF-8 page. A page
+// without a charset or with an unknown one is parsed as UTF-8.
+func Test_Charset(t *testing.T) {
+	testCases := []struct {
+		contentType string
+		body        string
+		expected    string
+	}{
+		{"text/html; charset=utf-8", `<a href="/café">café</a>`, "/café"},
+		{"text/html; charset=ISO-8859-1", "<a href=\"/caf\xe9\">caf\xe9</a>", "/café"},
+		{"text/html", "<head><meta charset=\"latin1\"></head><a href=\"/caf\xe9\">caf\xe9</a>", "/café"},
+		{"text/html", "<head><meta http-equiv=\"Content-Type\" content=\"text/html; charset=windows-1252\"></head><a href=\"/caf\xe9\">caf\xe9</a>", "/café"},
+		{"text/html; charset=Shift_JIS", "<a href=\"/\x93\xfa\x96\x7b\">\x93\xfa\x96\x7b</a>", "/日本"},
+		{"text/html; charset=ISO-8859-1", "<head><meta charset=\"shift_jis\"></head><a href=\"/caf\xe9\">caf\xe9</a>", "/café"},
+		{"text/html", `<a href="/café">café</a>`, "/café"},
+		{"text/html; charset=x-unknown", `<a href="/café">café</a>`, "/café"},
+	}
+	var current int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", testCases[current].contentType)
+		fmt.Fprint(w, testCases[current].body)
+	}))
+	defer ts.Close()
+
+	output := make(chan string, 10)
+	errors := make(chan error, 10)
+	c := NewCrawler(ts.URL, output, errors, nil)
+	for i, tc := range testCases {
+		current = i
+		res, err := http.Get(ts.URL)
+		if err != nil {
+			t.Fatal("Failed to get html from httptest server")
+		}
+		links, err := c.ProcessResponse(res)
+		if err != nil || len(links) != 1 || links[0] != ts.URL+tc.expected {
+			t.Errorf("Expected the link %s in the page sent as [%s], got %v: %v", tc.expected, tc.contentType, links, err)
+		}
+		<-output
+	}
+}
@@ -7,15 +7,29 @@ package crawler
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
+	"linkcrawl/data"
+	"log/slog"
+	"net"
 	"net/http"
 	"net/url"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"golang.org/x/net/publicsuffix"
 )
 
+// DefaultCleanCacheSize is the number of cleaned links NewCrawler caches
+const DefaultCleanCacheSize = 10000
+
 // The Crawler struct contains the seed domain to start crawling and the
 // channels used for error and standard output reporting.
 type Crawler struct {
@@ -23,6 +37,203 @@ type Crawler struct {
 	Out    chan<- string
 	Err    chan<- error
 	Fetch  chan<- *http.Response
+
+	// Results receives the links found on the pages as a Result, they are
+	// sent on Out in their CSV form when it is nil.
+	Results chan<- Result
+
+	// CaptureHeaders lists the response headers whose values are recorded
+	// in the output for every page that is processed.
+	CaptureHeaders []string
+
+	// LinkRels are the rel values of the Link response header whose targets
+	// are crawled, such as the next page of a paginated listing.
+	LinkRels []string
+
+	// PathPrefix restricts the crawl to the URLs whose path is within it, an
+	// empty prefix allows every path on the host.
+	PathPrefix string
+
+	// SameDepth restricts the crawl to the URLs whose path has as many
+	// segments as the seed's, i.e. the siblings of /docs/intro such as
+	// /docs/guide but not /docs or /docs/guide/install.
+	SameDepth bool
+
+	// PreferHTTPS rewrites http links to https before they are de-duplicated
+	PreferHTTPS bool
+
+	// Sink receives the result for each html page that is processed
+	Sink Sink
+
+	// Archive receives each response and its body, the body is then read
+	// for every response rather than only the html pages.
+	Archive Archive
+
+	// SamePageFragments resolves fragment-only links to the page they were
+	// found on rather than the seed domain, they are then dropped as links to
+	// the same resource.
+	SamePageFragments bool
+
+	// RespectNofollow does not follow the anchors whose rel has the nofollow
+	// token, such as rel="nofollow ugc", they are dropped from the links of
+	// the page with the nofollow reason.
+	RespectNofollow bool
+
+	// FollowIframes treats the links found in the documents of in-scope
+	// iframes as links of the page that embeds them as well. The iframe is
+	// fetched like any other link and its links are reported for the page
+	// that was found to embed it once it has been processed.
+	FollowIframes bool
+	frameMu       sync.Mutex
+	frames        map[string]string
+
+	// ReportOutlinks emits the number of unique in-scope links found on each
+	// page as an outlinks record.
+	ReportOutlinks bool
+
+	// CleanCache holds the results of cleaning the links, it is created with
+	// the default size by NewCrawler and caching is turned off when it is nil.
+	// The options that change how links are cleaned must be set before the
+	// crawl starts.
+	CleanCache *URLCache
+
+	// IndexFiles are the file names, such as index.html, that are stripped
+	// from the end of the paths so they collapse to their directory URL,
+	// nothing is stripped when it is empty.
+	IndexFiles []string
+
+	// StripParams are the query parameters removed from the links, such as
+	// utm_source, an entry ending with * removes every parameter with that
+	// prefix. When it is set the remaining parameters are sorted by name so
+	// the same query in another order is the same link.
+	StripParams []string
+
+	// ScopeFunc reports whether a cleaned candidate URL is in scope of the
+	// seed, SameHost when it is nil, or SameSite with IncludeSubdomains. The
+	// candidates that are out of scope are skipped with the out-of-scope
+	// reason, the PathPrefix and SameDepth checks are made after it.
+	ScopeFunc func(candidate, seed *url.URL) bool
+
+	// IncludeSubdomains crawls the subdomains of the seed's registered domain
+	// as part of the site, i.e. docs.example.com and blog.example.com when
+	// seeded with www.example.com.
+	IncludeSubdomains bool
+
+	// AllowedSchemes are the schemes other than http and https, such as ftp,
+	// whose links are recorded with a scheme record but never fetched. Links
+	// with any other scheme and a //, such as ssh://host, are dropped.
+	AllowedSchemes []string
+	otherSchemes   map[string]bool
+
+	// ReportSkipped emits a skipped record with the reason for each link that
+	// is found but is out of scope.
+	ReportSkipped bool
+
+	// OmitLinks stops a record being emitted for each link found on a page,
+	// the links are still returned so that they are crawled.
+	OmitLinks bool
+
+	// ReportSizes emits the number of bytes read from the body of each page
+	// as a size record.
+	ReportSizes bool
+	bytesRead   atomic.Int64
+
+	// RunID identifies the crawl in the Page sent to the Sink
+	RunID string
+
+	// ReportBroken emits a broken record for each response with a 4xx or 5xx
+	// status, the records can be re-checked with fetcher.Recheck.
+	ReportBroken bool
+
+	// Hashes records the SHA-256 hash of the decoded body of each 200 page
+	// so the crawl can be compared with a baseline, nil turns it off.
+	Hashes *data.Snapshot
+
+	// MinContentLength flags a 200 page with fewer bytes read from its body
+	// as thin content, such as a placeholder or a stub, with a thin record.
+	// With SkipThin the links on a thin page are not crawled.
+	MinContentLength int
+	SkipThin         bool
+
+	// Logger receives the debug logs of the scope decisions, nothing is
+	// logged when it is nil.
+	Logger *slog.Logger
+
+	// Credentials records the userinfo stripped from the links by host so
+	// that it can be used to authenticate the requests, it is discarded
+	// when nil.
+	Credentials *data.Credentials
+
+	// ReportNormalization records what each raw href found on a page was
+	// cleaned to, or why it was dropped, for the Normalizations report.
+	ReportNormalization bool
+	normMu              sync.Mutex
+	normalizations      map[string][]Normalization
+	normSeen            map[string]map[string]bool
+
+	// CheckFragments records the anchors of each page and the links with a
+	// fragment so that the fragments missing from their target page can be
+	// reported by MissingFragments.
+	CheckFragments bool
+	fragMu         sync.Mutex
+	fragmentLinks  []FragmentLink
+	fragmentSeen   map[FragmentLink]bool
+	anchors        map[string]map[string]bool
+
+	// BodyTimeout is how long the body of a response can take to be read once
+	// its headers have been received, separately from the fetcher's request
+	// timeout, 0 has no limit. A slow body is reported with the bytes read.
+	BodyTimeout time.Duration
+
+	// ParsePDF finds the links in the application/pdf responses, which are
+	// otherwise reported as an invalid content type.
+	ParsePDF bool
+
+	// Now returns the time the unavailable_after dates are compared against,
+	// it is the fetcher's clock in the crawl and time.Now otherwise.
+	Now func() time.Time
+
+	// CaptureTLS emits a tls record with the HTTP version, TLS version and
+	// cipher suite of each response, they are also set on the Page.
+	CaptureTLS bool
+
+	// ReportMixedContent emits a mixed-content record for each image, script
+	// or other subresource of an https page that is loaded over http.
+	ReportMixedContent bool
+
+	// CountLinkTypes records the URLs found on the parsed pages by the type
+	// of element they are on for the LinkTypes report.
+	CountLinkTypes bool
+	typeMu         sync.Mutex
+	linkTypes      map[string]map[string]bool
+
+	// ReportCanonical records the final URL each requested URL landed on
+	// after its redirects for the Canonicals report.
+	ReportCanonical bool
+	canonicalMu     sync.Mutex
+	canonicals      map[string]Canonical
+
+	// CountAnchorText counts the texts of the links on the parsed pages for
+	// the AnchorTexts report.
+	CountAnchorText bool
+	anchorMu        sync.Mutex
+	anchorTexts     map[string]int
+
+	// schemes records the schemes each URL has been seen with, keyed by the
+	// URL without its scheme, so mixed http/https links can be reported.
+	schemeMu sync.Mutex
+	schemes  map[string]map[string]bool
+
+	// skipped records the links that have been reported as skipped
+	skipMu  sync.Mutex
+	skipped map[string]bool
+
+	// DedupeOutput emits the record for each link found on a page at most
+	// once, even if the page is processed more than once. The edges that
+	// have been emitted are kept for the whole crawl.
+	DedupeOutput bool
+	edgeMu       sync.Mutex
+	edges        map[edge]bool
 }
 
 // NewCrawler, returns a pointer to a crawler.Crawler object, it is initialised
@@ -38,39 +249,182 @@ func NewCrawler(domain string, output chan<- string, errors chan<- error, fetch
 	}
 
 	return &Crawler{
-		Domain: url,
-		Out:    output,
-		Err:    errors,
-		Fetch:  fetch,
+		Domain:     url,
+		Out:        output,
+		Err:        errors,
+		Fetch:      fetch,
+		LinkRels:   []string{"next"},
+		CleanCache: NewURLCache(DefaultCleanCacheSize),
+		Now:        time.Now,
+	}
+}
+
+// Seed returns the seed URL to start the crawl from, any userinfo is
+// stripped from it in the same way as from the links that are found.
+func (c *Crawler) Seed() string {
+	seed := *c.Domain
+	if seed.User != nil {
+		if c.Credentials != nil {
+			c.Credentials.Add(seed.Host, seed.User)
+		}
+		seed.User = nil
 	}
+	return seed.String()
+}
+
+// watchBody closes a response body once the BodyTimeout has passed so that
+// a body that is trickled by the server does not hold up the worker. The
+// expired function reports whether the body was closed for the timeout, and
+// release stops the timer once the body has been read.
+func (c *Crawler) watchBody(body io.Closer) (expired func() bool, release func()) {
+	if c.BodyTimeout <= 0 {
+		return func() bool { return false }, func() {}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), c.BodyTimeout)
+	stop := context.AfterFunc(ctx, func() {
+		body.Close()
+	})
+	expired = func() bool {
+		return ctx.Err() == context.DeadlineExceeded
+	}
+	release = func() {
+		stop()
+		cancel()
+	}
+	return expired, release
+}
+
+// bodyTimeoutError is the error for a body that was not read within the
+// BodyTimeout, with the number of bytes that had been read.
+func (c *Crawler) bodyTimeoutError(resp *http.Response, read int) error {
+	return fmt.Errorf("%d,%s,Error reading response body: timed out after %v with %d bytes read", resp.StatusCode, resp.Request.URL, c.BodyTimeout, read)
 }
 
 // The private cleanUrl - method will take a rawUrl that has been scraped
-// from an HTML page from the anchor nodes href attribute and run the following
-// steps on it.
+// from an HTML page from the anchor nodes href attribute, along with the URL
+// of the page it was found on, and run the following steps on it.
 //   - Strip any leading or trailing spaces, this can confuse the net/url Parser
+//   - Detect if the link has a scheme other than http or https i.e. ftp://,
+//     if it does then it is skipped, and recorded when it is an AllowedScheme.
 //   - Detect if the link is a fragment i.e. #something, if it is then return the
-//     seed domain.
-//   - Detect if the link supplied is a relative path, if it is then rebuild the
-//     url from the seed domain i.e. /home/blog -> https://domain.com/home/blog
+//     seed domain, or the page URL when SamePageFragments is set.
+//   - Detect if the link is scheme-relative i.e. //host/path, if it is then
+//     prepend the scheme of the seed domain.
+//   - Detect if the link supplied is a relative path, if it is then resolve it
+//     against the page i.e. ../blog on https://domain.com/home/about/ ->
+//     https://domain.com/home/blog
 //   - Use the net/url url.Parse method to load the url into a url.URL object
-//   - Check and ensure the domain in the URL is the same as the one supplied in
-//     the seed.
+//   - Strip any userinfo, i.e. user:pass@, so that credentials are not
+//     reported, keeping it in the Credentials when they are set.
+//   - Check and ensure the URL is in the ScopeFunc of the seed, by default the
+//     domain in the URL must be the same as the seed's, and that the path is
+//     within the PathPrefix when it is set and as deep as the seed's with
+//     SameDepth.
 //   - Ensure the protocol scheme is set on the URL, if not then use "https"
+//   - Strip any of the IndexFiles from the end of the path
+//   - Strip the StripParams from the query and sort the remaining parameters
 //
 // Once all the checks have been complete, the url is reconstructed to ensure
 // there are no trailing `/` and to add any query string back onto it.
-func (c *Crawler) cleanUrl(rawUrl string) (string, error) {
+// The results are kept in the CleanCache when it is set.
+func (c *Crawler) cleanUrl(page *url.URL, rawUrl string) (string, error) {
+	result, err := c.clean(page, rawUrl)
+	return result.url, err
+}
+
+// cleanResult is the outcome of cleaning a link, a link that is out of scope
+// has an empty url along with the absolute link that was skipped and why.
+type cleanResult struct {
+	url     string
+	skipped *url.URL
+	reason  string
+}
+
+// clean runs cleanUrl keeping the reason a link is out of scope, each
+// decision is logged at debug level.
+func (c *Crawler) clean(page *url.URL, rawUrl string) (result cleanResult, err error) {
 	rawUrl = strings.TrimSpace(rawUrl)
+	if page == nil {
+		page = c.Domain
+	}
+	defer func() {
+		c.logScope(rawUrl, result, err)
+	}()
+	if c.CleanCache == nil {
+		return c.normalizeUrl(page, rawUrl)
+	}
+
+	key := newCacheKey(page, rawUrl)
+	if cached, ok := c.CleanCache.get(key); ok {
+		return cached, nil
+	}
+	result, err = c.normalizeUrl(page, rawUrl)
+	if err == nil {
+		c.CleanCache.add(key, result)
+	}
+	return result, err
+}
 
-	// If a fragment then return the host for the supplied domain
+// logScope logs the scope decision made for a raw link along with its host
+// and the seed host, the decision is in-scope, invalid or the reason the link
+// was skipped. Nothing is logged unless the Logger is enabled for debug.
+func (c *Crawler) logScope(rawUrl string, result cleanResult, err error) {
+	if c.Logger == nil || !c.Logger.Enabled(context.Background(), slog.LevelDebug) {
+		return
+	}
+	decision, host := "in-scope", ""
+	switch {
+	case err != nil:
+		decision = "invalid"
+	case len(result.reason) > 0:
+		decision, host = result.reason, result.skipped.Hostname()
+	default:
+		if u, err := url.Parse(result.url); err == nil {
+			host = u.Hostname()
+		}
+	}
+	attrs := []any{"raw", rawUrl, "host", host, "seed", c.Domain.Hostname(), "decision", decision}
+	if err != nil {
+		attrs = append(attrs, "error", err.Error())
+	} else if len(result.url) > 0 {
+		attrs = append(attrs, "url", result.url)
+	}
+	c.Logger.Debug("scope decision", attrs...)
+}
+
+// normalizeUrl runs the cleanUrl steps on a trimmed rawUrl
+func (c *Crawler) normalizeUrl(page *url.URL, rawUrl string) (cleanResult, error) {
+
+	// Links with another scheme are not prefixed with https, they are kept
+	// as they are when the scheme is allowed and dropped otherwise
+	if scheme := c.linkScheme(rawUrl); len(scheme) > 0 && scheme != "http" && scheme != "https" {
+		return c.otherScheme(rawUrl, scheme)
+	}
+
+	// If a fragment then return the host for the supplied domain, or the page
+	// it was found on
 	if strings.HasPrefix(rawUrl, "#") {
-		rawUrl = c.Domain.Scheme + "://" + c.Domain.Hostname()
+		if c.SamePageFragments {
+			rawUrl = page.Scheme + "://" + page.Host + page.EscapedPath()
+			if len(page.RawQuery) > 0 {
+				rawUrl += "?" + page.RawQuery
+			}
+		} else {
+			rawUrl = c.Domain.Scheme + "://" + c.Domain.Host
+		}
 	}
 
-	// If the URL is relative, prepend the scheme and domain
-	if strings.HasPrefix(rawUrl, "/") {
-		rawUrl = c.Domain.Scheme + "://" + c.Domain.Hostname() + rawUrl
+	// If the URL is scheme-relative, prepend only the scheme of the seed
+	if strings.HasPrefix(rawUrl, "//") {
+		rawUrl = c.Domain.Scheme + ":" + rawUrl
+	}
+
+	// If the URL is relative resolve it against the page it was found on,
+	// i.e. ../about on /docs/guide/ is /docs/about
+	if isRelative(rawUrl) {
+		if ref, err := url.Parse(rawUrl); err == nil {
+			rawUrl = page.ResolveReference(ref).String()
+		}
 	}
 
 	if strings.Contains(rawUrl, "127.0.0.1") && !strings.HasPrefix(rawUrl, "http") {
@@ -84,7 +438,15 @@ func (c *Crawler) cleanUrl(rawUrl string) (string, error) {
 	// Parse the URL
 	u, err := url.Parse(rawUrl)
 	if err != nil {
-		return "", fmt.Errorf("Error parsing URL: %v", err)
+		return cleanResult{}, fmt.Errorf("Error parsing URL: %v", err)
+	}
+
+	// Remove the userinfo before the checks so it is never in the output
+	if u.User != nil {
+		if c.Credentials != nil {
+			c.Credentials.Add(u.Host, u.User)
+		}
+		u.User = nil
 	}
 
 	// Set the protocol scheme to https if its not set
@@ -96,23 +458,277 @@ func (c *Crawler) cleanUrl(rawUrl string) (string, error) {
 		}
 	}
 
-	// Check the requests hostname is in the same domain as the seed
-	if u.Hostname() != c.Domain.Hostname() {
-		return "", nil
+	// Canonicalise plain http links to https when requested
+	if c.PreferHTTPS && u.Scheme == "http" {
+		u.Scheme = "https"
 	}
 
-	query := ""
-	if len(u.RawQuery) > 0 {
-		query = "?" + u.RawQuery
+	// Check the URL is in scope of the seed, by default it must be on the
+	// same host
+	scope := c.ScopeFunc
+	if scope == nil && c.IncludeSubdomains {
+		scope = SameSite
+	}
+	if scope == nil {
+		scope = SameHost
+	}
+	if !scope(u, c.Domain) {
+		return cleanResult{skipped: u, reason: "out-of-scope"}, nil
+	}
+
+	// Check the path is within the path prefix when one is set
+	if !c.inPathPrefix(u.Path) {
+		return cleanResult{skipped: u, reason: "outside-path"}, nil
+	}
+
+	// Check the path is as deep as the seed's when SameDepth is set
+	if c.SameDepth && pathDepth(u.Path) != pathDepth(c.Domain.Path) {
+		return cleanResult{skipped: u, reason: "other-depth"}, nil
 	}
 
 	path := ""
 	if len(u.Path) > 0 && u.Path != "/" {
-		path = u.Path
+		path = c.collapseIndex(u.Path)
+	}
+	u.RawQuery = c.canonicalQuery(u.RawQuery)
+
+	// Most links are already normalized once the scheme and domain have been
+	// prepended, they are returned as they are to save rebuilding them
+	if isNormalized(rawUrl, u.Scheme, u.Host, path, u.RawQuery) {
+		return cleanResult{url: rawUrl}, nil
 	}
 
 	// Reconstruct the URL to ensure it's normalized, i.e. no fragments, no relative paths etc
-	return u.Scheme + "://" + u.Host + path + query, nil
+	if len(u.RawQuery) > 0 {
+		return cleanResult{url: u.Scheme + "://" + u.Host + path + "?" + u.RawQuery}, nil
+	}
+	return cleanResult{url: u.Scheme + "://" + u.Host + path}, nil
+}
+
+// linkScheme returns the lowercased scheme of a raw link, or an empty string
+// when it has none. Without the // the scheme is only taken from the
+// AllowedSchemes, so a host and port such as example.com:8080 or userinfo
+// such as user:pass@example.com is not taken for a scheme.
+func (c *Crawler) linkScheme(rawUrl string) string {
+	scheme, rest, found := strings.Cut(rawUrl, ":")
+	if !found || len(scheme) == 0 {
+		return ""
+	}
+	for i, r := range scheme {
+		letter := (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z')
+		if !letter && (i == 0 || !(r >= '0' && r <= '9' || r == '+' || r == '-' || r == '.')) {
+			return ""
+		}
+	}
+	if strings.HasPrefix(rest, "//") {
+		return strings.ToLower(scheme)
+	}
+	for _, allowed := range c.AllowedSchemes {
+		if strings.EqualFold(allowed, scheme) {
+			return strings.ToLower(scheme)
+		}
+	}
+	return ""
+}
+
+// otherScheme skips a link with a scheme other than http or https, the
+// reason is other-scheme when it is one of the AllowedSchemes and scheme
+// otherwise. The userinfo and fragment are removed from an allowed link
+// as they are from the crawled links.
+func (c *Crawler) otherScheme(rawUrl, scheme string) (cleanResult, error) {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return cleanResult{}, fmt.Errorf("Error parsing URL: %v", err)
+	}
+	for _, allowed := range c.AllowedSchemes {
+		if strings.EqualFold(allowed, scheme) {
+			u.User = nil
+			u.Fragment, u.RawFragment = "", ""
+			return cleanResult{skipped: u, reason: "other-scheme"}, nil
+		}
+	}
+	return cleanResult{skipped: u, reason: "scheme"}, nil
+}
+
+// recordScheme emits a scheme record the first time a link with one of the
+// AllowedSchemes is found, it is not crawled.
+func (c *Crawler) recordScheme(result cleanResult) {
+	if result.reason != "other-scheme" {
+		return
+	}
+	link := result.skipped.String()
+	c.skipMu.Lock()
+	if c.otherSchemes == nil {
+		c.otherSchemes = map[string]bool{}
+	}
+	recorded := c.otherSchemes[link]
+	c.otherSchemes[link] = true
+	c.skipMu.Unlock()
+
+	if !recorded {
+		c.Out <- fmt.Sprintf("scheme,%s,%s", result.skipped.Scheme, link)
+	}
+}
+
+// SameHost is the default ScopeFunc, a candidate is in scope when it has the
+// same hostname as the seed.
+func SameHost(candidate, seed *url.URL) bool {
+	return candidate.Hostname() == seed.Hostname()
+}
+
+// SameSite is the ScopeFunc with IncludeSubdomains, a candidate is in scope
+// when its hostname is the registered domain of the seed, such as example.com
+// for www.example.com, or a subdomain of it. A seed without a registered
+// domain, such as an IP address or localhost, is only matched exactly.
+func SameSite(candidate, seed *url.URL) bool {
+	host := strings.ToLower(candidate.Hostname())
+	site, err := publicsuffix.EffectiveTLDPlusOne(strings.ToLower(seed.Hostname()))
+	if err != nil {
+		return host == strings.ToLower(seed.Hostname())
+	}
+	return host == site || strings.HasSuffix(host, "."+site)
+}
+
+// isRelative reports whether a schemeless link is relative to the page it is
+// on, such as /blog, ../about, ./intro, page.html or ?page=2. A link that
+// starts with a host, such as example.com/blog, user@example.com or
+// 127.0.0.1:8080, is not relative. The host is told apart from a file name by
+// its suffix being a known top-level domain, so a bare file name with one,
+// such as guide.md, is read as a host. It is only used for the links passed
+// to cleanUrl as they are, the links of an html document are resolved
+// against the page first by linkBase.
+func isRelative(rawUrl string) bool {
+	if strings.HasPrefix(rawUrl, "//") {
+		return false
+	}
+	if strings.HasPrefix(rawUrl, "/") || strings.HasPrefix(rawUrl, ".") || strings.HasPrefix(rawUrl, "?") {
+		return true
+	}
+	first := rawUrl
+	if end := strings.IndexAny(first, "/?#"); end >= 0 {
+		first = first[:end]
+	}
+	if strings.ContainsAny(first, "@:") || net.ParseIP(first) != nil || strings.EqualFold(first, "localhost") {
+		return false
+	}
+	if !strings.Contains(first, ".") {
+		return true
+	}
+	suffix, icann := publicsuffix.PublicSuffix(strings.ToLower(first))
+	return !icann && !strings.Contains(suffix, ".")
+}
+
+// isNormalized reports whether rawUrl is already made up of the scheme, host,
+// path and query of a normalized URL, without building the normalized URL.
+func isNormalized(rawUrl, scheme, host, path, query string) bool {
+	for _, part := range []string{scheme, "://", host, path} {
+		if !strings.HasPrefix(rawUrl, part) {
+			return false
+		}
+		rawUrl = rawUrl[len(part):]
+	}
+	if len(query) == 0 {
+		return len(rawUrl) == 0
+	}
+	return len(rawUrl) == len(query)+1 && rawUrl[0] == '?' && rawUrl[1:] == query
+}
+
+// skip reports a link that was found but is not crawled when ReportSkipped
+// is set, each link is only reported the first time it is skipped.
+func (c *Crawler) skip(skipped *url.URL, reason string) {
+	if !c.ReportSkipped {
+		return
+	}
+	link := skipped.String()
+	c.skipMu.Lock()
+	if c.skipped == nil {
+		c.skipped = map[string]bool{}
+	}
+	reported := c.skipped[link]
+	c.skipped[link] = true
+	c.skipMu.Unlock()
+
+	if !reported {
+		c.Out <- fmt.Sprintf("skipped,%s,%s", link, reason)
+	}
+}
+
+// edge is a link found on a source page
+type edge struct {
+	source string
+	link   string
+}
+
+// emitEdge reports whether the record for a link found on a page should be
+// emitted, which is always unless DedupeOutput is set and it has already
+// been emitted.
+func (c *Crawler) emitEdge(source, link string) bool {
+	if !c.DedupeOutput {
+		return true
+	}
+	key := edge{source: source, link: link}
+	c.edgeMu.Lock()
+	defer c.edgeMu.Unlock()
+	if c.edges == nil {
+		c.edges = map[edge]bool{}
+	}
+	if c.edges[key] {
+		return false
+	}
+	c.edges[key] = true
+	return true
+}
+
+// inPathPrefix reports whether a path is within the PathPrefix, the prefix
+// directory itself is included with or without its trailing slash.
+func (c *Crawler) inPathPrefix(path string) bool {
+	if len(c.PathPrefix) == 0 {
+		return true
+	}
+	return strings.HasPrefix(path, c.PathPrefix) || path == strings.TrimSuffix(c.PathPrefix, "/")
+}
+
+// pathDepth returns the number of segments in a path, a trailing slash and
+// empty segments are not counted so /docs/guide/ and /docs/guide are both 2.
+func pathDepth(path string) int {
+	depth := 0
+	for _, segment := range strings.Split(path, "/") {
+		if len(segment) > 0 {
+			depth++
+		}
+	}
+	return depth
+}
+
+// collapseIndex strips one of the IndexFiles from the end of a path so it
+// is the URL of its directory, i.e. /docs/index.html -> /docs/ and the
+// index of the host is collapsed to the host.
+func (c *Crawler) collapseIndex(path string) string {
+	i := strings.LastIndex(path, "/") + 1
+	dir, file := path[:i], path[i:]
+	for _, index := range c.IndexFiles {
+		if strings.EqualFold(file, index) {
+			if dir == "/" {
+				return ""
+			}
+			return dir
+		}
+	}
+	return path
+}
+
+// SeedPathPrefix returns the directory of the seed URL's path to use as the
+// PathPrefix, i.e. https://domain.com/docs/intro -> /docs/
+// A seed at the root of the host returns an empty prefix.
+func SeedPathPrefix(seed *url.URL) string {
+	path := seed.Path
+	if i := strings.LastIndex(path, "/"); i >= 0 {
+		path = path[:i+1]
+	}
+	if path == "/" {
+		return ""
+	}
+	return path
 }
 
 // fitleredLinks takes a list of URLs that may contain duplicates or empty
@@ -135,6 +751,39 @@ func filteredLinks(links []string) []string {
 	return uniqueLinks
 }
 
+// checkScheme records the scheme used by a cleaned link and emits a warning
+// the first time the same URL has been seen with both http and https.
+func (c *Crawler) checkScheme(link string) {
+	// The link has already been cleaned so its scheme is followed by ://
+	scheme, _, found := strings.Cut(link, "://")
+	if !found || (scheme != "http" && scheme != "https") {
+		return
+	}
+	key := link[len(scheme)+1:]
+
+	c.schemeMu.Lock()
+	if c.schemes == nil {
+		c.schemes = map[string]map[string]bool{}
+	}
+	if _, exists := c.schemes[key]; !exists {
+		c.schemes[key] = map[string]bool{}
+	}
+	seen := c.schemes[key]
+	mixed := !seen[scheme] && len(seen) == 1
+	seen[scheme] = true
+	c.schemeMu.Unlock()
+
+	if mixed {
+		c.Out <- fmt.Sprintf("warning,mixed-scheme,http:%s,https:%s", key, key)
+	}
+}
+
+// BytesRead returns the total number of bytes read from the bodies of the
+// pages that have been processed.
+func (c *Crawler) BytesRead() int64 {
+	return c.bytesRead.Load()
+}
+
 // The ProcessResponse method accepts the response from an http.Get request
 // The body is extracted from the response and processed to
 // locate all of the links in the html body.
@@ -145,67 +794,394 @@ func (c *Crawler) ProcessResponse(resp *http.Response) ([]string, error) {
 	url := resp.Request.URL.String()
 	var found []string
 
+	// Record the requested response headers before the content type is
+	// checked so they are captured for every page
+	for _, name := range c.CaptureHeaders {
+		if value := resp.Header.Get(name); len(value) > 0 {
+			c.Out <- fmt.Sprintf("header,%s,%s,%s", url, http.CanonicalHeaderKey(name), value)
+		}
+	}
+	if c.CaptureTLS {
+		proto, version, cipher := connection(resp)
+		c.Out <- fmt.Sprintf("tls,%s,%s,%s,%s", url, proto, version, cipher)
+	}
+	c.recordCanonical(resp)
+	if c.ReportBroken && resp.StatusCode >= http.StatusBadRequest {
+		c.Out <- fmt.Sprintf("broken,%d,%s", resp.StatusCode, url)
+	}
+
+	// The body is buffered before the content type is checked when archiving
+	// so that every response is archived as it was received, the html is
+	// then decoded and parsed from the copy
+	expired, release := c.watchBody(resp.Body)
+	defer release()
+	var raw io.Reader = resp.Body
+	if c.Archive != nil {
+		archived, err := io.ReadAll(resp.Body)
+		if err != nil && expired() {
+			return found, c.bodyTimeoutError(resp, len(archived))
+		}
+		if err != nil {
+			return found, fmt.Errorf("%d,Error reading response body: %v", resp.StatusCode, err)
+		}
+		if err := c.Archive.Archive(resp, archived); err != nil {
+			c.Err <- fmt.Errorf("%d,%s,Error archiving response: %v", resp.StatusCode, url, err)
+		}
+		raw = bytes.NewReader(archived)
+	}
+
 	contentType := resp.Header.Get("Content-Type")
-	if !strings.Contains(contentType, "text/html") && !strings.Contains(contentType, "text/plain") {
+	pdf := c.ParsePDF && strings.Contains(contentType, "application/pdf")
+	if !pdf && !strings.Contains(contentType, "text/html") && !strings.Contains(contentType, "text/plain") {
 		return found, fmt.Errorf("%d,%s,Invalid Content Type: %s", resp.StatusCode, url, contentType)
 	}
-	body, err := io.ReadAll(resp.Body)
+
+	// Decompress the body by its Content-Encoding, a corrupt body is reported
+	// as a decoding error rather than a read error
+	encoding := resp.Header.Get("Content-Encoding")
+	decoded, err := DecodeBody(encoding, raw)
+	if err != nil {
+		return found, fmt.Errorf("%d,%s,Error decoding %s response body: %v", resp.StatusCode, url, encoding, err)
+	}
+	body, err := io.ReadAll(decoded)
+	if err != nil && expired() {
+		return found, c.bodyTimeoutError(resp, len(body))
+	}
+	if err != nil && len(encoding) > 0 {
+		return found, fmt.Errorf("%d,%s,Error decoding %s response body: %v", resp.StatusCode, url, encoding, err)
+	}
 	if err != nil {
 		return found, fmt.Errorf("%d,Error reading response body: %v", resp.StatusCode, err)
 	}
 
-	// Parse through the body and return all the links that have been found
-	links, err := c.startFindLinks(body)
+	// The size is the bytes read, the Content-Length header may be missing
+	c.bytesRead.Add(int64(len(body)))
+	if c.ReportSizes {
+		c.Out <- fmt.Sprintf("size,%s,%d", url, len(body))
+	}
+	if c.Hashes != nil && resp.StatusCode == http.StatusOK {
+		sum := sha256.Sum256(body)
+		c.Hashes.Record(url, hex.EncodeToString(sum[:]))
+	}
+	if c.MinContentLength > 0 && resp.StatusCode == http.StatusOK && len(body) < c.MinContentLength {
+		c.Out <- fmt.Sprintf("thin,%s,%d", url, len(body))
+		if c.SkipThin {
+			return found, nil
+		}
+	}
+
+	// The X-Robots-Tag header can ask for the page not to be indexed or for
+	// its links not to be followed, it applies to a PDF as well as a page
+	noindex, nofollow := robotsTag(resp.Header.Values("X-Robots-Tag"))
+	if noindex {
+		c.Out <- fmt.Sprintf("noindex,%s", url)
+	}
+
+	// The links of a PDF are its URI actions, it has no other page details
+	if pdf {
+		unique := filteredLinks(c.pdfLinks(resp.Request.URL, body))
+		if c.ReportOutlinks {
+			c.Out <- fmt.Sprintf("outlinks,%s,%d", url, len(unique))
+		}
+		if nofollow {
+			c.Out <- fmt.Sprintf("nofollow,%s", url)
+			return found, nil
+		}
+		return c.emitLinks(resp, unique), nil
+	}
+
+	// Parse through the body and return all the links that have been found,
+	// a page in another charset is transcoded to UTF-8 first
+	doc, err := html.Parse(bytes.NewReader(decodeCharset(contentType, body)))
 	if err != nil {
-		return found, fmt.Errorf("%d,Error finding links: %v", resp.StatusCode, err)
+		return found, fmt.Errorf("%d,Error finding links: Error parsing HTML: %v", resp.StatusCode, err)
 	}
+	c.recordAnchors(url, doc)
+	c.recordAnchorTexts(doc)
+	c.reportMixedContent(resp.Request.URL, doc)
 
-	// Send all the unique links found to the output
-	for _, link := range filteredLinks(links) {
-		foundUrl, _ := c.cleanUrl(link)
-		found = append(found, foundUrl)
-		c.Out <- fmt.Sprintf("%d,%s,%s", resp.StatusCode, url, link)
+	// A page whose robots meta unavailable_after date has passed is expired,
+	// it is reported and the links on it are not crawled. A date that cannot
+	// be parsed is reported as a warning and the page is crawled as normal.
+	if value, ok := unavailableAfter(doc); ok {
+		expires, valid := parseUnavailableAfter(value)
+		if !valid {
+			c.Out <- fmt.Sprintf("warning,unavailable-after,%s,%s", url, value)
+		} else if expires.Before(c.Now()) {
+			c.Out <- fmt.Sprintf("expired,%s,%s", url, expires.UTC().Format(time.RFC3339))
+			return found, nil
+		}
+	}
+
+	links := c.docLinks(resp.Request.URL, doc)
+	links = append(links, c.headerLinks(resp)...)
+	if c.FollowIframes {
+		c.recordIframes(resp.Request.URL, doc)
+	}
+
+	// The links are already cleaned so the unique links are the in-scope
+	// links found on the page
+	unique := filteredLinks(links)
+
+	// Send the page to the sink, a failure is reported but does not stop
+	// the links on the page from being crawled
+	if c.Sink != nil {
+		title, text := pageText(doc)
+		page := Page{URL: url, StatusCode: resp.StatusCode, Title: title, Text: text, OutlinkCount: len(unique), ContentLength: len(body), NoIndex: noindex, RunID: c.RunID}
+		if c.CaptureTLS {
+			page.Proto, page.TLSVersion, page.TLSCipher = connection(resp)
+		}
+		if err := c.Sink.Send(page); err != nil {
+			c.Err <- fmt.Errorf("%d,%s,Error sending page to sink: %v", resp.StatusCode, url, err)
+		}
+	}
+	if c.ReportOutlinks {
+		c.Out <- fmt.Sprintf("outlinks,%s,%d", url, len(unique))
+	}
+	if nofollow {
+		c.Out <- fmt.Sprintf("nofollow,%s", url)
+		return found, nil
 	}
 
 	// Return the found URLs to enqueue for future processing
-	return found, nil
+	return c.emitLinks(resp, unique), nil
+}
+
+// emitLinks sends the unique links found on a page to the output and returns
+// them to be enqueued.
+func (c *Crawler) emitLinks(resp *http.Response, unique []string) []string {
+	url := resp.Request.URL.String()
+	embedding := c.embeddedIn(url)
+	var found []string
+	for _, link := range unique {
+		found = append(found, link)
+		c.checkScheme(link)
+		if !c.OmitLinks && c.emitEdge(url, link) {
+			c.emitResult(Result{SourceURL: url, FoundURL: link, StatusCode: resp.StatusCode, Timestamp: c.Now().UTC()})
+		}
+		if len(embedding) > 0 && !c.OmitLinks && c.emitEdge(embedding, link) {
+			c.emitResult(Result{SourceURL: embedding, FoundURL: link, StatusCode: resp.StatusCode, Timestamp: c.Now().UTC()})
+		}
+	}
+	return found
 }
 
-// startFindLinks takes the html body inside a []byte slice and get an
-// html.Node using html.Parse
+// emitResult sends a link found on a page to Results, or to Out in its CSV
+// form when there is no Results channel.
+func (c *Crawler) emitResult(result Result) {
+	if c.Results != nil {
+		c.Results <- result
+		return
+	}
+	c.Out <- result.String()
+}
+
+// startFindLinks takes the URL of the page and its html body inside a []byte
+// slice and get an html.Node using html.Parse
 // - recurse through all the elements in the html.Node
-// - for each link that is discovered, clean the URLs
+// - for each link that is discovered, clean the URLs relative to the page
+// - resolve the relative links against the first <base href> when there is one
+// - drop fragment-only links to the page itself when SamePageFragments is set
 // - return a []string with all the URLs discovered
-func (c *Crawler) startFindLinks(body []byte) ([]string, error) {
-	var links []string
+func (c *Crawler) startFindLinks(page *url.URL, body []byte) ([]string, error) {
 	doc, err := html.Parse(bytes.NewReader(body))
 	if err != nil {
 		return []string{}, fmt.Errorf("Error parsing HTML: %v", err)
 	}
-	for _, a := range c.findLinks(nil, doc) {
-		url, err := c.cleanUrl(a)
+	return c.docLinks(page, doc), nil
+}
+
+// docLinks returns the cleaned links found in an html document that has
+// already been parsed.
+func (c *Crawler) docLinks(page *url.URL, doc *html.Node) []string {
+	var links []string
+	self := ""
+	if c.SamePageFragments && page != nil {
+		self, _ = c.cleanUrl(page, page.String())
+	}
+
+	// The relative links are resolved against the <base href> when there is
+	// one and the page otherwise, the raw link is still the one that is
+	// reported
+	base := c.linkBase(page, doc)
+	types := map[string][]string{}
+	for _, link := range c.findLinks(nil, doc) {
+		a := link.href
+		if link.subresource {
+			if ref, err := url.Parse(a); err == nil && base != nil {
+				resolved := base.ResolveReference(ref)
+				resolved.Fragment, resolved.RawFragment = "", ""
+				types[link.kind] = append(types[link.kind], resolved.String())
+			}
+			continue
+		}
+		result, err := c.clean(page, resolveBase(base, a))
 		if err != nil {
 			// TODO: Do not ignore failed URL cleaning
+			c.normalized(page, Normalization{Raw: a, Reason: "invalid"})
 			continue
 		}
+		if len(result.reason) > 0 {
+			c.skip(result.skipped, result.reason)
+			c.recordScheme(result)
+		}
+		url := result.url
+		if link.nofollow && len(url) > 0 {
+			c.normalized(page, Normalization{Raw: a, Reason: "nofollow"})
+			continue
+		}
+		c.fragmentLink(page, a, url)
+		if len(self) > 0 && url == self && strings.HasPrefix(strings.TrimSpace(a), "#") {
+			c.normalized(page, Normalization{Raw: a, Reason: "same-page-fragment"})
+			continue
+		}
+		c.normalized(page, Normalization{Raw: a, Cleaned: url, Reason: result.reason})
 		links = append(links, url)
+		if len(url) > 0 {
+			types[link.kind] = append(types[link.kind], url)
+		}
+	}
+	c.recordLinkTypes(types)
+	return links
+}
+
+// headerLinks returns the cleaned targets of the Link response header that
+// have one of the LinkRels, the targets are resolved against the URL of the
+// request as they may be relative.
+func (c *Crawler) headerLinks(resp *http.Response) []string {
+	var links []string
+	for _, target := range linkHeaderTargets(resp.Header.Values("Link"), c.LinkRels) {
+		ref, err := url.Parse(target)
+		if err != nil {
+			continue
+		}
+		result, err := c.clean(resp.Request.URL, resp.Request.URL.ResolveReference(ref).String())
+		if err != nil {
+			continue
+		}
+		if len(result.reason) > 0 {
+			c.skip(result.skipped, result.reason)
+			c.recordScheme(result)
+		}
+		links = append(links, result.url)
+	}
+	return links
+}
+
+// linkHeaderTargets parses Link header values such as
+// <https://domain.com/?page=2>; rel="next", </?page=9>; rel="last"
+// returning the targets of the links with one of the rels.
+func linkHeaderTargets(values []string, rels []string) []string {
+	var targets []string
+	if len(rels) == 0 {
+		return targets
+	}
+	for _, value := range values {
+		for {
+			start := strings.Index(value, "<")
+			end := strings.Index(value, ">")
+			if start < 0 || end < start {
+				break
+			}
+			target := value[start+1 : end]
+			value = value[end+1:]
+
+			// The parameters of a link run until the start of the next one
+			params := value
+			if next := strings.Index(value, "<"); next >= 0 {
+				params = value[:next]
+			}
+			if linkHasRel(params, rels) {
+				targets = append(targets, strings.TrimSpace(target))
+			}
+		}
 	}
-	return links, nil
+	return targets
+}
+
+// linkHasRel checks if the parameters of a link include one of the rels, a
+// rel parameter can hold several space separated values.
+func linkHasRel(params string, rels []string) bool {
+	for _, param := range strings.Split(params, ";") {
+		key, value, found := strings.Cut(strings.TrimSpace(param), "=")
+		if !found || !strings.EqualFold(strings.TrimSpace(key), "rel") {
+			continue
+		}
+		value = strings.Trim(strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(value), ",")), `"`)
+		for _, rel := range strings.Fields(value) {
+			for _, wanted := range rels {
+				if strings.EqualFold(rel, wanted) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// recordIframes records the page that embeds each in-scope iframe in an html
+// document, the iframe src is found with the other links so it is fetched by
+// the workers like them. The first page found to embed an iframe is kept.
+func (c *Crawler) recordIframes(page *url.URL, doc *html.Node) {
+	base := c.linkBase(page, doc)
+	for _, src := range filteredLinks(findIframes(nil, doc)) {
+		frameUrl, err := c.cleanUrl(page, resolveBase(base, src))
+		if err != nil || len(frameUrl) == 0 || frameUrl == page.String() {
+			continue
+		}
+		c.frameMu.Lock()
+		if c.frames == nil {
+			c.frames = map[string]string{}
+		}
+		if _, ok := c.frames[frameUrl]; !ok {
+			c.frames[frameUrl] = page.String()
+		}
+		c.frameMu.Unlock()
+	}
+}
+
+// embeddedIn returns the page that embeds the iframe document at a URL, it
+// is empty for a URL that is not an iframe recorded by recordIframes.
+func (c *Crawler) embeddedIn(url string) string {
+	c.frameMu.Lock()
+	defer c.frameMu.Unlock()
+	return c.frames[url]
 }
 
 // findLinks extracts all the anchor elements in an html node, extracts the
-// href attribute and updates the slice passed in with the links on it, it
-// the html node is looped over and if there are more children in the node, it
+// href attribute and updates the slice passed in with the links on it, the
+// src attribute of iframe elements is extracted in the same way, as is the
+// href of the alternate language and AMP versions of the page. Each link is
+// tagged with the type of its element, and the subresources are added when
+// CountLinkTypes is set so the types are counted from the same walk. The html
+// node is looped over and if there are more children in the node, it
 // recurses calling itself until all the nodes have been seen and had their
 // links extracted.
 // It returns a slice with all the links that were found in the seed html node
-func (c *Crawler) findLinks(links []string, n *html.Node) []string {
-	if n.Type == html.ElementNode && n.Data == "a" {
+func (c *Crawler) findLinks(links []pageLink, n *html.Node) []pageLink {
+	if n.Type == html.ElementNode && n.DataAtom == atom.A {
 		for _, a := range n.Attr {
 			if a.Key != "href" {
 				continue
 			}
-			links = append(links, a.Val)
+			links = append(links, pageLink{href: a.Val, kind: "anchors", nofollow: c.RespectNofollow && anchorNofollow(n)})
+		}
+	}
+	if n.Type == html.ElementNode && n.DataAtom == atom.Iframe {
+		for _, src := range findIframes(nil, n) {
+			links = append(links, pageLink{href: src, kind: "iframes"})
+		}
+	}
+	if n.Type == html.ElementNode && n.DataAtom == atom.Link {
+		if href, ok := alternateHref(n); ok {
+			links = append(links, pageLink{href: href, kind: "alternates"})
+		}
+	}
+	// The subresources are only found to count them by their type as they
+	// are not crawled, an iframe is counted as the link above
+	if c.CountLinkTypes && n.Type == html.ElementNode && n.DataAtom != atom.Iframe {
+		if src, ok := subresource(n); ok {
+			links = append(links, pageLink{href: src, kind: subresourceType(n), subresource: true})
 		}
 	}
 	for child := n.FirstChild; child != nil; child = child.NextSibling {
@@ -213,3 +1189,83 @@ func (c *Crawler) findLinks(links []string, n *html.Node) []string {
 	}
 	return links
 }
+
+// pageLink is a link found on a page with the type of the element it is on,
+// a subresource is only counted by its type and is not crawled.
+type pageLink struct {
+	href        string
+	kind        string
+	subresource bool
+	nofollow    bool
+}
+
+// anchorNofollow reports whether the rel of an anchor has the nofollow token,
+// the rel is split on whitespace so that only a whole token matches and
+// rel="nofollowme" is followed.
+func anchorNofollow(n *html.Node) bool {
+	for _, a := range n.Attr {
+		if a.Key == "rel" && hasRel(strings.Fields(strings.ToLower(a.Val)), "nofollow") {
+			return true
+		}
+	}
+	return false
+}
+
+// alternateHref returns the href of a link element for another version of
+// the page, rel="alternate" such as the hreflang translations or
+// rel="amphtml". Alternates with a type that is not html, such as RSS feeds,
+// and alternate stylesheets are not pages to crawl so they are left out.
+func alternateHref(n *html.Node) (string, bool) {
+	var href, rel, kind string
+	for _, a := range n.Attr {
+		switch strings.ToLower(a.Key) {
+		case "href":
+			href = a.Val
+		case "rel":
+			rel = strings.ToLower(a.Val)
+		case "type":
+			kind = strings.ToLower(strings.TrimSpace(a.Val))
+		}
+	}
+	if len(strings.TrimSpace(href)) == 0 {
+		return "", false
+	}
+	rels := strings.Fields(rel)
+	if hasRel(rels, "amphtml") {
+		return href, true
+	}
+	if !hasRel(rels, "alternate") || hasRel(rels, "stylesheet") {
+		return "", false
+	}
+	if len(kind) > 0 && !strings.HasPrefix(kind, "text/html") && !strings.HasPrefix(kind, "application/xhtml") {
+		return "", false
+	}
+	return href, true
+}
+
+// hasRel checks if one of the space separated rel values is the wanted rel
+func hasRel(rels []string, wanted string) bool {
+	for _, rel := range rels {
+		if rel == wanted {
+			return true
+		}
+	}
+	return false
+}
+
+// findIframes extracts the src attribute of every iframe element in an html
+// node and its children.
+func findIframes(srcs []string, n *html.Node) []string {
+	if n.Type == html.ElementNode && n.DataAtom == atom.Iframe {
+		for _, a := range n.Attr {
+			if a.Key == "src" && len(strings.TrimSpace(a.Val)) > 0 {
+				srcs = append(srcs, a.Val)
+			}
+		}
+		return srcs
+	}
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		srcs = findIframes(srcs, child)
+	}
+	return srcs
+}
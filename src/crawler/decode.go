@@ -0,0 +1,53 @@
+package crawler
+
+// Decode the compressed response bodies. The transport only decodes gzip
+// when it has asked for it itself, once the fetcher advertises the encodings
+// it accepts the bodies arrive compressed and are decoded here by their
+// Content-Encoding.
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// DecodeBody returns a reader of the decoded body for the Content-Encoding,
+// an unsupported encoding is an error. It is also used by the fetcher to
+// check whether a body is empty once it has been decoded.
+func DecodeBody(encoding string, body io.Reader) (io.Reader, error) {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "", "identity":
+		return body, nil
+	case "gzip", "x-gzip":
+		return gzip.NewReader(body)
+	case "deflate":
+		return deflateReader(body)
+	case "br":
+		return brotli.NewReader(body), nil
+	}
+	return nil, fmt.Errorf("unsupported content encoding %s", encoding)
+}
+
+// deflateReader decodes a deflate body, which should be zlib wrapped but is
+// sent as raw deflate data by some servers, the zlib header is checked for.
+// An empty body, such as that of a 204 or a HEAD request, decodes to nothing.
+func deflateReader(body io.Reader) (io.Reader, error) {
+	buffered := bufio.NewReader(body)
+	header, err := buffered.Peek(2)
+	if err == io.EOF && len(header) == 0 {
+		return buffered, nil
+	}
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if len(header) == 2 && header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+		return zlib.NewReader(buffered)
+	}
+	return flate.NewReader(buffered), nil
+}
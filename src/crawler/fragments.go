@@ -0,0 +1,105 @@
+package crawler
+
+// Check that the fragments of the in-scope links, i.e. /page#section, point
+// to an element on the target page. The id and name anchors of each parsed
+// page are recorded along with the links that have a fragment, once the
+// crawl completes the links are cross referenced with the anchors of their
+// targets.
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// FragmentLink is a link found on Page to the Fragment of the Target page
+type FragmentLink struct {
+	Page     string
+	Target   string
+	Fragment string
+}
+
+// fragmentLink records a link with a fragment when CheckFragments is set, a
+// fragment-only link targets the page it is found on. The empty fragment and
+// #top always scroll to the top of a page so they are not checked.
+func (c *Crawler) fragmentLink(page *url.URL, raw, target string) {
+	if !c.CheckFragments || page == nil {
+		return
+	}
+	_, fragment, found := strings.Cut(strings.TrimSpace(raw), "#")
+	if !found || len(fragment) == 0 || strings.EqualFold(fragment, "top") {
+		return
+	}
+	if unescaped, err := url.PathUnescape(fragment); err == nil {
+		fragment = unescaped
+	}
+	if strings.HasPrefix(strings.TrimSpace(raw), "#") {
+		target, _ = c.cleanUrl(page, page.String())
+	}
+	if len(target) == 0 {
+		return
+	}
+
+	link := FragmentLink{Page: page.String(), Target: target, Fragment: fragment}
+	c.fragMu.Lock()
+	defer c.fragMu.Unlock()
+	if c.fragmentSeen == nil {
+		c.fragmentSeen = map[FragmentLink]bool{}
+	}
+	if !c.fragmentSeen[link] {
+		c.fragmentSeen[link] = true
+		c.fragmentLinks = append(c.fragmentLinks, link)
+	}
+}
+
+// recordAnchors records the fragments a parsed page can be linked to, the id
+// of any element and the name of an anchor element.
+func (c *Crawler) recordAnchors(page string, doc *html.Node) {
+	if !c.CheckFragments {
+		return
+	}
+	ids := map[string]bool{}
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			for _, attr := range n.Attr {
+				if attr.Key == "id" || (attr.Key == "name" && n.DataAtom == atom.A) {
+					ids[attr.Val] = true
+				}
+			}
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(doc)
+
+	c.fragMu.Lock()
+	defer c.fragMu.Unlock()
+	if c.anchors == nil {
+		c.anchors = map[string]map[string]bool{}
+	}
+	c.anchors[page] = ids
+}
+
+// MissingFragments returns the links whose fragment is not an anchor on the
+// target page, sorted by the page they were found on. Links to pages that
+// were not parsed, such as pages that failed or were out of scope, can not
+// be checked and are left out.
+func (c *Crawler) MissingFragments() []FragmentLink {
+	c.fragMu.Lock()
+	defer c.fragMu.Unlock()
+	var missing []FragmentLink
+	for _, link := range c.fragmentLinks {
+		if ids, parsed := c.anchors[link.Target]; parsed && !ids[link.Fragment] {
+			missing = append(missing, link)
+		}
+	}
+	sort.SliceStable(missing, func(i, j int) bool {
+		return missing[i].Page < missing[j].Page
+	})
+	return missing
+}
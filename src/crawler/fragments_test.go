@@ -0,0 +1,60 @@
+package crawler
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Serve two pages that link to each other's fragments, one valid and one
+// dangling from each page, and test that only the dangling fragments are
+// reported once both pages have been processed.
+func Test_MissingFragments(t *testing.T) {
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/guide":
+			fmt.Fprintf(w, `<html><body>
+			<h2 id="install">Install</h2>
+			<a href="#install">Install</a>
+			<a href="#usage">Usage</a>
+			<a href="#top">Top</a>
+			<a href="%[1]s/faq#why">Why</a>
+			<a href="%[1]s/faq#how">How</a>
+			<a href="%[1]s/missing#anything">Not crawled</a>
+			</body></html>`, ts.URL)
+		case "/faq":
+			fmt.Fprintf(w, `<html><body>
+			<a name="why"></a><p>Why</p>
+			<a href="%[1]s/guide#install">Install</a>
+			<a href="%[1]s/guide#setup">Setup</a>
+			</body></html>`, ts.URL)
+		}
+	}))
+	defer ts.Close()
+
+	output := make(chan string, 20)
+	c := NewCrawler(ts.URL, output, nil, nil)
+	c.CheckFragments = true
+
+	for _, path := range []string{"/guide", "/faq"} {
+		res, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatalf("Failed to get %s from the httptest server", path)
+		}
+		if _, err := c.ProcessResponse(res); err != nil {
+			t.Fatalf("Failed to process %s: %v", path, err)
+		}
+	}
+
+	expected := []FragmentLink{
+		{Page: ts.URL + "/faq", Target: ts.URL + "/guide", Fragment: "setup"},
+		{Page: ts.URL + "/guide", Target: ts.URL + "/guide", Fragment: "usage"},
+		{Page: ts.URL + "/guide", Target: ts.URL + "/faq", Fragment: "how"},
+	}
+	missing := c.MissingFragments()
+	if fmt.Sprint(missing) != fmt.Sprint(expected) {
+		t.Errorf("The missing fragments were %v, expected %v", missing, expected)
+	}
+}
@@ -0,0 +1,80 @@
+package crawler
+
+// Count the unique URLs discovered on the crawled pages by the type of the
+// element they were found on, i.e. anchors, images and scripts, for a
+// breakdown of the links of a site once the crawl completes.
+
+import (
+	"sort"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// LinkType is the number of unique URLs found on the elements of a Type
+type LinkType struct {
+	Type  string
+	Count int
+}
+
+// subresourceType returns the type of a subresource element, the anchors,
+// alternates and iframes are typed where they are found as they are crawled.
+func subresourceType(n *html.Node) string {
+	switch n.DataAtom {
+	case atom.Img:
+		return "images"
+	case atom.Script:
+		return "scripts"
+	case atom.Link:
+		for _, a := range n.Attr {
+			if strings.ToLower(a.Key) == "rel" && hasRel(strings.Fields(strings.ToLower(a.Val)), "stylesheet") {
+				return "stylesheets"
+			}
+		}
+		return "resources"
+	}
+	return "media"
+}
+
+// recordLinkTypes records the URLs found on a page by their type when
+// CountLinkTypes is set. The crawled links are the cleaned URLs that are
+// reported for the page and the subresources are resolved against it, each
+// is counted once for each type however many pages it is found on.
+func (c *Crawler) recordLinkTypes(found map[string][]string) {
+	if !c.CountLinkTypes || len(found) == 0 {
+		return
+	}
+	c.typeMu.Lock()
+	defer c.typeMu.Unlock()
+	if c.linkTypes == nil {
+		c.linkTypes = map[string]map[string]bool{}
+	}
+	for kind, links := range found {
+		if c.linkTypes[kind] == nil {
+			c.linkTypes[kind] = map[string]bool{}
+		}
+		for _, link := range links {
+			c.linkTypes[kind][link] = true
+		}
+	}
+}
+
+// LinkTypes returns the number of unique URLs found for each type of link
+// ordered by the count, with ties broken by the type.
+func (c *Crawler) LinkTypes() []LinkType {
+	c.typeMu.Lock()
+	types := make([]LinkType, 0, len(c.linkTypes))
+	for kind, links := range c.linkTypes {
+		types = append(types, LinkType{Type: kind, Count: len(links)})
+	}
+	c.typeMu.Unlock()
+
+	sort.Slice(types, func(i, j int) bool {
+		if types[i].Count != types[j].Count {
+			return types[i].Count > types[j].Count
+		}
+		return types[i].Type < types[j].Type
+	})
+	return types
+}
@@ -0,0 +1,60 @@
+package crawler
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Serve two pages that share some of their links and subresources and test
+// that the unique URLs are counted for each type of element across both, the
+// crawled links are counted as they are reported so the out of scope anchor
+// and the fragment are not counted apart from the data records.
+func Test_LinkTypes(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `<html><head>
+		<link rel="stylesheet" href="/site.css">
+		<link rel="icon" href="/favicon.ico">
+		<link rel="alternate" hreflang="fr" href="/fr%[1]s">
+		<script src="/app.js"></script>
+		<script>var inline = true;</script>
+		</head><body>
+		<a href="/">Home</a>
+		<a href="%[1]s#top">This page</a>
+		<a href="https://other.com/">Other</a>
+		<a name="anchor">Not a link</a>
+		<img src="/logo.png"><img src="/photo%[1]s.jpg">
+		<iframe src="/embed"></iframe>
+		<video src="/intro.mp4"></video>
+		</body></html>`, r.URL.Path)
+	}))
+	defer ts.Close()
+
+	c := NewCrawler(ts.URL, make(chan string, 100), nil, nil)
+	c.CountLinkTypes = true
+	for _, path := range []string{"/one", "/two"} {
+		res, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatalf("Failed to get %s from the httptest server", path)
+		}
+		if _, err := c.ProcessResponse(res); err != nil {
+			t.Fatalf("Failed to process %s: %v", path, err)
+		}
+	}
+
+	// Home and both pages are the anchors, the other host is out of scope
+	expected := []LinkType{
+		{Type: "anchors", Count: 3},
+		{Type: "images", Count: 3},
+		{Type: "alternates", Count: 2},
+		{Type: "iframes", Count: 1},
+		{Type: "media", Count: 1},
+		{Type: "resources", Count: 1},
+		{Type: "scripts", Count: 1},
+		{Type: "stylesheets", Count: 1},
+	}
+	if types := c.LinkTypes(); fmt.Sprint(types) != fmt.Sprint(expected) {
+		t.Errorf("The link types were %v, expected %v", types, expected)
+	}
+}
@@ -0,0 +1,106 @@
+package crawler
+
+// The robots directives that are read from the page and its response, the
+// unavailable_after meta directive marks a page as expired once its date has
+// passed and the X-Robots-Tag header can mark it as noindex or nofollow.
+
+import (
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// unavailableAfterLayouts are the date formats accepted for the
+// unavailable_after directive, RFC 822, RFC 850 and ISO 8601 variants.
+var unavailableAfterLayouts = []string{
+	time.RFC1123,
+	time.RFC1123Z,
+	time.RFC850,
+	time.RFC822,
+	time.RFC822Z,
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02",
+	"2 Jan 2006 15:04:05 MST",
+	"02 Jan 2006 15:04:05 MST",
+}
+
+// unavailableAfter returns the value of the unavailable_after directive in
+// the robots meta tags of a document, found is false when there is none.
+func unavailableAfter(doc *html.Node) (value string, found bool) {
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if found {
+			return
+		}
+		if n.Type == html.ElementNode && n.DataAtom == atom.Meta {
+			var name, content string
+			for _, attr := range n.Attr {
+				switch strings.ToLower(attr.Key) {
+				case "name":
+					name = attr.Val
+				case "content":
+					content = attr.Val
+				}
+			}
+			if strings.EqualFold(name, "robots") {
+				lower := strings.ToLower(content)
+				if i := strings.Index(lower, "unavailable_after:"); i >= 0 {
+					value = strings.TrimSpace(content[i+len("unavailable_after:"):])
+					found = true
+					return
+				}
+			}
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(doc)
+	return value, found
+}
+
+// parseUnavailableAfter parses the date of an unavailable_after directive.
+// Other directives can follow the date after a comma, and some of the date
+// formats contain commas, so the longest prefix that parses is used.
+func parseUnavailableAfter(value string) (time.Time, bool) {
+	for end := len(value); end > 0; end = strings.LastIndex(value[:end], ",") {
+		candidate := strings.TrimSpace(value[:end])
+		for _, layout := range unavailableAfterLayouts {
+			if t, err := time.Parse(layout, candidate); err == nil {
+				return t, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// robotsTag returns the noindex and nofollow directives of the X-Robots-Tag
+// header values, none sets both. The directives following a user agent,
+// i.e. googlebot: noindex, nofollow, are for other crawlers and are ignored.
+func robotsTag(values []string) (noindex, nofollow bool) {
+	for _, value := range values {
+		applies := true
+		for _, directive := range strings.Split(value, ",") {
+			directive = strings.ToLower(strings.TrimSpace(directive))
+			if name, rest, ok := strings.Cut(directive, ":"); ok && name != "unavailable_after" {
+				applies = strings.TrimSpace(name) == "*"
+				directive = strings.TrimSpace(rest)
+			}
+			if !applies {
+				continue
+			}
+			switch directive {
+			case "noindex":
+				noindex = true
+			case "nofollow":
+				nofollow = true
+			case "none":
+				noindex, nofollow = true, true
+			}
+		}
+	}
+	return noindex, nofollow
+}
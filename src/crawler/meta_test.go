@@ -0,0 +1,58 @@
+package crawler
+
+import (
+	"testing"
+	"time"
+)
+
+// Test the date formats accepted by the unavailable_after directive,
+// including those with commas and those followed by other directives.
+func Test_parseUnavailableAfter(t *testing.T) {
+	expected := time.Date(2010, time.June, 25, 15, 0, 0, 0, time.UTC)
+	testCases := map[string]bool{
+		"2010-06-25T15:00:00Z":                   true,
+		"Fri, 25 Jun 2010 15:00:00 UTC":          true,
+		"Friday, 25-Jun-10 15:00:00 UTC":         true,
+		"25 Jun 2010 15:00:00 UTC":               true,
+		"2010-06-25T15:00:00Z, noarchive":        true,
+		"Fri, 25 Jun 2010 15:00:00 UTC, noindex": true,
+		"next tuesday":                           false,
+		"":                                       false,
+	}
+
+	for value, valid := range testCases {
+		parsed, ok := parseUnavailableAfter(value)
+		if ok != valid {
+			t.Errorf("Parsing [%s] returned %v, expected %v", value, ok, valid)
+			continue
+		}
+		if ok && !parsed.Equal(expected) {
+			t.Errorf("Parsing [%s] returned %v, expected %v", value, parsed, expected)
+		}
+	}
+}
+
+// Test the X-Robots-Tag directives that apply to every crawler and that the
+// directives scoped to another user agent are ignored.
+func Test_robotsTag(t *testing.T) {
+	testCases := []struct {
+		values            []string
+		noindex, nofollow bool
+	}{
+		{[]string{"noindex, nofollow"}, true, true},
+		{[]string{"NoIndex"}, true, false},
+		{[]string{"noarchive", "nofollow"}, false, true},
+		{[]string{"none"}, true, true},
+		{[]string{"googlebot: noindex, nofollow"}, false, false},
+		{[]string{"otherbot: noindex, *: nofollow"}, false, true},
+		{[]string{"unavailable_after: 2001-01-01, noindex"}, true, false},
+		{nil, false, false},
+	}
+
+	for _, tc := range testCases {
+		noindex, nofollow := robotsTag(tc.values)
+		if noindex != tc.noindex || nofollow != tc.nofollow {
+			t.Errorf("The directives %v returned noindex %v and nofollow %v, expected %v and %v", tc.values, noindex, nofollow, tc.noindex, tc.nofollow)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package crawler
+
+// Find the mixed content of the https pages, i.e. the images, scripts,
+// stylesheets and other subresources that are loaded over plain http, which
+// browsers block or warn about.
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// MixedContent is a Subresource loaded over http by an https Page, Element
+// is the name of the element that references it, i.e. script
+type MixedContent struct {
+	Page        string
+	Element     string
+	Subresource string
+}
+
+// subresourceRels are the rels of the link elements that the browser loads
+// along with the page, the other rels such as canonical are links.
+var subresourceRels = []string{"stylesheet", "icon", "preload", "modulepreload", "manifest"}
+
+// subresource returns the attribute of an element that the browser loads
+// along with the page, or false when the element is not a subresource.
+func subresource(n *html.Node) (string, bool) {
+	var key string
+	switch n.DataAtom {
+	case atom.Img, atom.Script, atom.Iframe, atom.Audio, atom.Video, atom.Source, atom.Embed, atom.Track:
+		key = "src"
+	case atom.Object:
+		key = "data"
+	case atom.Link:
+		key = "href"
+		var rel string
+		for _, a := range n.Attr {
+			if strings.ToLower(a.Key) == "rel" {
+				rel = strings.ToLower(a.Val)
+			}
+		}
+		found := false
+		for _, wanted := range subresourceRels {
+			if hasRel(strings.Fields(rel), wanted) {
+				found = true
+			}
+		}
+		if !found {
+			return "", false
+		}
+	default:
+		return "", false
+	}
+	for _, a := range n.Attr {
+		if strings.ToLower(a.Key) == key && len(strings.TrimSpace(a.Val)) > 0 {
+			return strings.TrimSpace(a.Val), true
+		}
+	}
+	return "", false
+}
+
+// findMixedContent returns the subresources of an https page that resolve
+// to http URLs, each is returned once for the page in the order found.
+func findMixedContent(page *url.URL, doc *html.Node) []MixedContent {
+	var mixed []MixedContent
+	if page.Scheme != "https" {
+		return mixed
+	}
+	seen := map[string]bool{}
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			if src, ok := subresource(n); ok {
+				if ref, err := url.Parse(src); err == nil {
+					resolved := page.ResolveReference(ref)
+					if resolved.Scheme == "http" && !seen[resolved.String()] {
+						seen[resolved.String()] = true
+						mixed = append(mixed, MixedContent{Page: page.String(), Element: n.Data, Subresource: resolved.String()})
+					}
+				}
+			}
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(doc)
+	return mixed
+}
+
+// reportMixedContent emits a mixed-content record for each http subresource
+// of an https page when ReportMixedContent is set.
+func (c *Crawler) reportMixedContent(page *url.URL, doc *html.Node) {
+	if !c.ReportMixedContent {
+		return
+	}
+	for _, m := range findMixedContent(page, doc) {
+		c.Out <- fmt.Sprintf("mixed-content,%s,%s,%s", m.Page, m.Element, m.Subresource)
+	}
+}
@@ -0,0 +1,57 @@
+package crawler
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Serve an https page that references http and https subresources and test
+// that only the http script, image and stylesheet are reported as mixed
+// content, while links to http pages are not.
+func Test_MixedContent(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><head>
+		<script src="http://cdn.example.com/app.js"></script>
+		<script src="https://cdn.example.com/safe.js"></script>
+		<link rel="stylesheet" href="http://cdn.example.com/site.css">
+		<link rel="canonical" href="http://example.com/">
+		</head><body>
+		<img src="http://images.example.com/logo.png">
+		<img src="HTTP://images.example.com/logo.png">
+		<img src="/relative.png">
+		<a href="http://example.com/page">Page</a>
+		</body></html>`)
+	}))
+	defer ts.Close()
+
+	output := make(chan string, 20)
+	c := NewCrawler(ts.URL, output, nil, nil)
+	c.ReportMixedContent = true
+
+	res, err := ts.Client().Get(ts.URL)
+	if err != nil {
+		t.Fatalf("Failed to get the page from the httptest server: %v", err)
+	}
+	if _, err := c.ProcessResponse(res); err != nil {
+		t.Fatalf("Failed to process the page: %v", err)
+	}
+	close(output)
+
+	var records []string
+	for record := range output {
+		if strings.HasPrefix(record, "mixed-content,") {
+			records = append(records, record)
+		}
+	}
+	expected := []string{
+		"mixed-content," + ts.URL + ",script,http://cdn.example.com/app.js",
+		"mixed-content," + ts.URL + ",link,http://cdn.example.com/site.css",
+		"mixed-content," + ts.URL + ",img,http://images.example.com/logo.png",
+	}
+	if fmt.Sprint(records) != fmt.Sprint(expected) {
+		t.Errorf("The mixed content records were %v, expected %v", records, expected)
+	}
+}
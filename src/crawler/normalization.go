@@ -0,0 +1,62 @@
+package crawler
+
+// Collect what each raw href found on a page was cleaned to, or why it was
+// dropped, so that the normalization of the links on a site can be checked.
+
+import (
+	"net/url"
+	"sort"
+)
+
+// Normalization is the result of cleaning a raw href, Cleaned is empty when
+// the link was dropped for the Reason.
+type Normalization struct {
+	Raw     string
+	Cleaned string
+	Reason  string
+}
+
+// PageNormalizations holds the normalizations of the links found on a page
+// in the order they were found.
+type PageNormalizations struct {
+	Page  string
+	Links []Normalization
+}
+
+// normalized records the result of cleaning a raw href found on page when
+// ReportNormalization is set, each raw href is recorded once for a page.
+func (c *Crawler) normalized(page *url.URL, n Normalization) {
+	if !c.ReportNormalization || page == nil {
+		return
+	}
+	key := page.String()
+	c.normMu.Lock()
+	defer c.normMu.Unlock()
+	if c.normalizations == nil {
+		c.normalizations = map[string][]Normalization{}
+		c.normSeen = map[string]map[string]bool{}
+	}
+	if c.normSeen[key] == nil {
+		c.normSeen[key] = map[string]bool{}
+	}
+	if c.normSeen[key][n.Raw] {
+		return
+	}
+	c.normSeen[key][n.Raw] = true
+	c.normalizations[key] = append(c.normalizations[key], n)
+}
+
+// Normalizations returns the normalizations that have been recorded grouped
+// by the page the links were found on, sorted by the page URL.
+func (c *Crawler) Normalizations() []PageNormalizations {
+	c.normMu.Lock()
+	defer c.normMu.Unlock()
+	pages := make([]PageNormalizations, 0, len(c.normalizations))
+	for page, links := range c.normalizations {
+		pages = append(pages, PageNormalizations{Page: page, Links: links})
+	}
+	sort.Slice(pages, func(i, j int) bool {
+		return pages[i].Page < pages[j].Page
+	})
+	return pages
+}
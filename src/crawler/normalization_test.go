@@ -0,0 +1,50 @@
+package crawler
+
+import (
+	"fmt"
+	"net/url"
+	"testing"
+)
+
+// Find the links on a fixture page and test that each raw href is reported
+// once with what it was cleaned to, or the reason it was dropped.
+func Test_Normalizations(t *testing.T) {
+	output := make(chan string, 10)
+	c := NewCrawler(seedDomain, output, nil, nil)
+	c.SamePageFragments = true
+	c.ReportNormalization = true
+
+	page, _ := url.Parse("https://example.com/docs")
+	body := `<html>
+		<a href=" /about/ ">about</a>
+		<a href="https://example.com/blog?page=2">blog</a>
+		<a href="#top">top</a>
+		<a href="https://google.com/">google</a>
+		<a href="https://example.com/%zz">invalid</a>
+		<a href=" /about/ ">about again</a>
+	</html>`
+	if _, err := c.startFindLinks(page, []byte(body)); err != nil {
+		t.Fatalf("Failed to find the links: %v", err)
+	}
+
+	pages := c.Normalizations()
+	if len(pages) != 1 || pages[0].Page != "https://example.com/docs" {
+		t.Fatalf("Expected the normalizations of one page, got %v", pages)
+	}
+	expected := []Normalization{
+		{Raw: " /about/ ", Cleaned: "https://example.com/about/"},
+		{Raw: "https://example.com/blog?page=2", Cleaned: "https://example.com/blog?page=2"},
+		{Raw: "#top", Reason: "same-page-fragment"},
+		{Raw: "https://google.com/", Reason: "out-of-scope"},
+		{Raw: "https://example.com/%zz", Reason: "invalid"},
+	}
+	if fmt.Sprint(pages[0].Links) != fmt.Sprint(expected) {
+		t.Errorf("The normalizations were %v, expected %v", pages[0].Links, expected)
+	}
+
+	c.ReportNormalization = false
+	c.startFindLinks(page, []byte(`<a href="/other">other</a>`))
+	if len(c.Normalizations()[0].Links) != len(expected) {
+		t.Error("Expected nothing to be recorded without ReportNormalization")
+	}
+}
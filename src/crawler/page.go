@@ -0,0 +1,87 @@
+package crawler
+
+// The per-page result that is produced for each page that is processed,
+// along with the Sink interface that results can be sent to for storage or
+// indexing outside of the streamed output, and the Archive interface that
+// receives the raw responses.
+
+import (
+	"crypto/tls"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Page holds the result of processing a single crawled page,
+// OutlinkCount is the number of unique in-scope links found on the page,
+// ContentLength is the number of bytes read from its body and NoIndex is set
+// when the response asks for the page not to be indexed. Proto, TLSVersion
+// and TLSCipher are the negotiated connection details, they are only set
+// with CaptureTLS. RunID is the identifier of the crawl that fetched it.
+type Page struct {
+	URL           string `json:"url"`
+	StatusCode    int    `json:"status"`
+	Title         string `json:"title"`
+	Text          string `json:"text"`
+	OutlinkCount  int    `json:"outlink_count"`
+	ContentLength int    `json:"content_length"`
+	NoIndex       bool   `json:"noindex"`
+	Proto         string `json:"proto,omitempty"`
+	TLSVersion    string `json:"tls_version,omitempty"`
+	TLSCipher     string `json:"tls_cipher,omitempty"`
+	RunID         string `json:"run_id,omitempty"`
+}
+
+// connection returns the HTTP version a response was received with along
+// with the TLS version and cipher suite negotiated for it, which are empty
+// when the response was not received over TLS.
+func connection(resp *http.Response) (proto, version, cipher string) {
+	if resp.TLS == nil {
+		return resp.Proto, "", ""
+	}
+	return resp.Proto, tls.VersionName(resp.TLS.Version), tls.CipherSuiteName(resp.TLS.CipherSuite)
+}
+
+// Sink receives a Page for every html page the crawler processes
+type Sink interface {
+	Send(page Page) error
+}
+
+// Archive receives every response the crawler processes along with its body,
+// before the content type is checked and the body is parsed.
+type Archive interface {
+	Archive(resp *http.Response, body []byte) error
+}
+
+// pageText walks an html node returning the text of the first title element
+// and the visible text of the document, scripts and styles are skipped and
+// runs of whitespace are collapsed to a single space.
+func pageText(doc *html.Node) (string, string) {
+	var title string
+	var text []string
+
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			switch n.Data {
+			case "script", "style", "noscript":
+				return
+			case "title":
+				if len(title) == 0 && n.FirstChild != nil {
+					title = strings.Join(strings.Fields(n.FirstChild.Data), " ")
+				}
+				return
+			}
+		}
+		if n.Type == html.TextNode {
+			text = append(text, strings.Fields(n.Data)...)
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(doc)
+
+	return title, strings.Join(text, " ")
+}
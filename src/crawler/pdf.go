@@ -0,0 +1,201 @@
+package crawler
+
+// Find the links in PDF documents. A link in a PDF is a URI action, i.e.
+// << /S /URI /URI (https://domain.com/) >>, usually on a link annotation,
+// which can be in the body of the file or in a compressed object stream.
+// Only the URI strings are read so no PDF library is needed.
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/hex"
+	"io"
+	"net/url"
+)
+
+// maxPDFStream is the most bytes inflated from a single stream of a PDF
+const maxPDFStream = 16 << 20
+
+// pdfURIs returns the targets of the URI actions in a PDF document in the
+// order they are found. The FlateDecode streams, such as the object streams
+// the annotations can be compressed into, are inflated and searched too.
+func pdfURIs(body []byte) []string {
+	uris := findURIs(body)
+	for rest := body; ; {
+		start := bytes.Index(rest, []byte("stream"))
+		if start < 0 {
+			break
+		}
+		rest = rest[start+len("stream"):]
+		// The stream keyword is followed by an end of line, endstream and
+		// the keyword inside other words are not streams
+		if len(rest) > 0 && rest[0] == '\r' {
+			rest = rest[1:]
+		}
+		if len(rest) == 0 || rest[0] != '\n' {
+			continue
+		}
+		rest = rest[1:]
+		end := bytes.Index(rest, []byte("endstream"))
+		if end < 0 {
+			break
+		}
+		if inflated, ok := inflate(rest[:end]); ok {
+			uris = append(uris, findURIs(inflated)...)
+		}
+		rest = rest[end+len("endstream"):]
+	}
+	return uris
+}
+
+// inflate decompresses the data of a FlateDecode stream, it returns false
+// for other streams such as images which do not inflate.
+func inflate(data []byte) ([]byte, bool) {
+	r, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, false
+	}
+	defer r.Close()
+	inflated, err := io.ReadAll(io.LimitReader(r, maxPDFStream))
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return nil, false
+	}
+	return inflated, true
+}
+
+// findURIs returns the strings that follow the /URI keys in PDF data, the
+// /URI name that is the type of the action is followed by a name rather
+// than a string and is skipped.
+func findURIs(data []byte) []string {
+	var uris []string
+	key := []byte("/URI")
+	for {
+		i := bytes.Index(data, key)
+		if i < 0 {
+			return uris
+		}
+		data = bytes.TrimLeft(data[i+len(key):], " \t\r\n\f\x00")
+		if len(data) == 0 {
+			return uris
+		}
+		var uri string
+		switch data[0] {
+		case '(':
+			uri, data = literalString(data[1:])
+		case '<':
+			uri, data = hexString(data[1:])
+		default:
+			continue
+		}
+		if len(uri) > 0 {
+			uris = append(uris, uri)
+		}
+	}
+}
+
+// literalString decodes a PDF literal string up to its closing parenthesis,
+// the parentheses inside it are balanced or escaped. It returns the string
+// and the data after it.
+func literalString(data []byte) (string, []byte) {
+	var s []byte
+	depth := 0
+	for i := 0; i < len(data); i++ {
+		switch c := data[i]; c {
+		case '(':
+			depth++
+			s = append(s, c)
+		case ')':
+			if depth == 0 {
+				return string(s), data[i+1:]
+			}
+			depth--
+			s = append(s, c)
+		case '\\':
+			i++
+			if i == len(data) {
+				break
+			}
+			switch e := data[i]; e {
+			case 'n':
+				s = append(s, '\n')
+			case 'r':
+				s = append(s, '\r')
+			case 't':
+				s = append(s, '\t')
+			case 'b':
+				s = append(s, '\b')
+			case 'f':
+				s = append(s, '\f')
+			case '\r':
+				// A line continuation
+				if i+1 < len(data) && data[i+1] == '\n' {
+					i++
+				}
+			case '\n':
+			default:
+				if e >= '0' && e <= '7' {
+					// An octal character code of up to 3 digits
+					code := 0
+					for n := 0; n < 3 && i < len(data) && data[i] >= '0' && data[i] <= '7'; n++ {
+						code = code*8 + int(data[i]-'0')
+						i++
+					}
+					i--
+					s = append(s, byte(code))
+				} else {
+					s = append(s, e)
+				}
+			}
+		default:
+			s = append(s, c)
+		}
+	}
+	return string(s), nil
+}
+
+// hexString decodes a PDF hex string up to its closing >, whitespace is
+// ignored and a missing last digit is 0. It returns the string and the data
+// after it.
+func hexString(data []byte) (string, []byte) {
+	end := bytes.IndexByte(data, '>')
+	if end < 0 {
+		return "", nil
+	}
+	var digits []byte
+	for _, c := range data[:end] {
+		if c != ' ' && c != '\t' && c != '\r' && c != '\n' && c != '\f' {
+			digits = append(digits, c)
+		}
+	}
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+	decoded := make([]byte, hex.DecodedLen(len(digits)))
+	if _, err := hex.Decode(decoded, digits); err != nil {
+		return "", data[end+1:]
+	}
+	return string(decoded), data[end+1:]
+}
+
+// pdfLinks returns the cleaned links of the URI actions in a PDF document,
+// they are resolved against the URL of the document as they may be
+// relative.
+func (c *Crawler) pdfLinks(page *url.URL, body []byte) []string {
+	var links []string
+	for _, uri := range pdfURIs(body) {
+		ref, err := url.Parse(uri)
+		if err != nil {
+			continue
+		}
+		result, err := c.clean(page, page.ResolveReference(ref).String())
+		if err != nil {
+			continue
+		}
+		if len(result.reason) > 0 {
+			c.skip(result.skipped, result.reason)
+			c.recordScheme(result)
+		}
+		links = append(links, result.url)
+	}
+	return links
+}
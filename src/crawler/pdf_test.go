@@ -0,0 +1,117 @@
+package crawler
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// pdfFixture returns a small PDF whose first page has link annotations with a
+// literal and a hex string URI, and whose second annotation is compressed in
+// an object stream.
+func pdfFixture(base string) []byte {
+	var stream bytes.Buffer
+	w := zlib.NewWriter(&stream)
+	fmt.Fprintf(w, `7 0 << /Type /Annot /Subtype /Link /A << /S /URI /URI (%s/compressed) >> >>`, base)
+	w.Close()
+
+	var pdf bytes.Buffer
+	pdf.WriteString("%PDF-1.5\n")
+	pdf.WriteString("1 0 obj << /Type /Catalog /Pages 2 0 R >> endobj\n")
+	pdf.WriteString("2 0 obj << /Type /Pages /Kids [3 0 R] /Count 1 >> endobj\n")
+	pdf.WriteString("3 0 obj << /Type /Page /Parent 2 0 R /Annots [4 0 R 5 0 R 7 0 R] >> endobj\n")
+	fmt.Fprintf(&pdf, "4 0 obj << /Type /Annot /Subtype /Link /A << /S /URI /URI (%s/docs/guide\\(v2\\)) >> >> endobj\n", base)
+	fmt.Fprintf(&pdf, "5 0 obj << /Type /Annot /Subtype /Link /A << /S /URI /URI <%x> >> >> endobj\n", "https://other.com/page")
+	fmt.Fprintf(&pdf, "6 0 obj << /Type /ObjStm /N 1 /First 4 /Filter /FlateDecode /Length %d >>\nstream\n", stream.Len())
+	pdf.Write(stream.Bytes())
+	pdf.WriteString("\nendstream\nendobj\n%%EOF\n")
+	return pdf.Bytes()
+}
+
+// Serve a PDF with link annotations and test that the in-scope links are
+// found in it, including the compressed one and those with escapes, while
+// without ParsePDF it is an invalid content type.
+func Test_ParsePDF(t *testing.T) {
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Write(pdfFixture(ts.URL))
+	}))
+	defer ts.Close()
+
+	for _, parse := range []bool{true, false} {
+		output := make(chan string, 10)
+		c := NewCrawler(ts.URL, output, nil, nil)
+		c.ParsePDF = parse
+		c.ReportSkipped = true
+
+		res, err := http.Get(ts.URL + "/manual.pdf")
+		if err != nil {
+			t.Fatalf("Failed to get the PDF from the httptest server: %v", err)
+		}
+		links, err := c.ProcessResponse(res)
+		close(output)
+		if !parse {
+			if err == nil || !strings.Contains(err.Error(), "Invalid Content Type: application/pdf") {
+				t.Errorf("Expected an invalid content type without ParsePDF, got %v", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Failed to process the PDF: %v", err)
+		}
+
+		expected := []string{ts.URL + "/docs/guide(v2)", ts.URL + "/compressed"}
+		if fmt.Sprint(links) != fmt.Sprint(expected) {
+			t.Errorf("The links found in the PDF were %v, expected %v", links, expected)
+		}
+		var records []string
+		for record := range output {
+			records = append(records, record)
+		}
+		if len(records) != 3 || records[0] != "skipped,https://other.com/page,out-of-scope" || records[1] != "200,"+ts.URL+"/manual.pdf,"+expected[0] {
+			t.Errorf("Unexpected records for the PDF: %v", records)
+		}
+	}
+}
+
+// Serve a PDF with the X-Robots-Tag header set to noindex, nofollow and test
+// that it is reported and its links are not followed, as for a page.
+func Test_ParsePDFNofollow(t *testing.T) {
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Header().Set("X-Robots-Tag", "noindex, nofollow")
+		w.Write(pdfFixture(ts.URL))
+	}))
+	defer ts.Close()
+
+	output := make(chan string, 10)
+	c := NewCrawler(ts.URL, output, nil, nil)
+	c.ParsePDF = true
+
+	res, err := http.Get(ts.URL + "/manual.pdf")
+	if err != nil {
+		t.Fatalf("Failed to get the PDF from the httptest server: %v", err)
+	}
+	links, err := c.ProcessResponse(res)
+	close(output)
+	if err != nil {
+		t.Fatalf("Failed to process the PDF: %v", err)
+	}
+	if len(links) != 0 {
+		t.Errorf("The links of a nofollow PDF should not be followed, got %v", links)
+	}
+	var records []string
+	for record := range output {
+		records = append(records, record)
+	}
+	expected := []string{"noindex," + ts.URL + "/manual.pdf", "nofollow," + ts.URL + "/manual.pdf"}
+	if fmt.Sprint(records) != fmt.Sprint(expected) {
+		t.Errorf("Unexpected records for the nofollow PDF: %v, expected %v", records, expected)
+	}
+}
@@ -0,0 +1,68 @@
+package crawler
+
+// Strip the tracking parameters from the query of the links and sort the
+// rest, so the same page linked with different campaign parameters, or with
+// its parameters in another order, is only crawled once.
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// DefaultStripParams are the common tracking parameters, an entry ending
+// with * strips every parameter with that prefix.
+var DefaultStripParams = []string{"utm_*", "fbclid", "gclid", "gclsrc", "dclid", "msclkid", "mc_cid", "mc_eid"}
+
+// stripped reports whether a query parameter is one of the StripParams, the
+// names are matched without case.
+func (c *Crawler) stripped(name string) bool {
+	name = strings.ToLower(name)
+	for _, param := range c.StripParams {
+		param = strings.ToLower(param)
+		if prefix, ok := strings.CutSuffix(param, "*"); ok {
+			if strings.HasPrefix(name, prefix) {
+				return true
+			}
+		} else if name == param {
+			return true
+		}
+	}
+	return false
+}
+
+// canonicalQuery removes the StripParams from a raw query and sorts the
+// remaining parameters by name, the values of a repeated name keep their
+// order and the encoding of each parameter is kept as it is. The query is
+// returned unchanged when there are no StripParams.
+func (c *Crawler) canonicalQuery(rawQuery string) string {
+	if len(c.StripParams) == 0 || len(rawQuery) == 0 {
+		return rawQuery
+	}
+	type param struct {
+		name string
+		raw  string
+	}
+	var params []param
+	for _, raw := range strings.Split(rawQuery, "&") {
+		if len(raw) == 0 {
+			continue
+		}
+		name, _, _ := strings.Cut(raw, "=")
+		if decoded, err := url.QueryUnescape(name); err == nil {
+			name = decoded
+		}
+		if c.stripped(name) {
+			continue
+		}
+		params = append(params, param{name: name, raw: raw})
+	}
+	sort.SliceStable(params, func(i, j int) bool {
+		return params[i].name < params[j].name
+	})
+	kept := make([]string, len(params))
+	for i, p := range params {
+		kept[i] = p.raw
+	}
+	return strings.Join(kept, "&")
+}
@@ -0,0 +1,25 @@
+package crawler
+
+// The record of a link found on a crawled page, it is sent on the Results
+// channel so that the output can be written in a structured format, or on
+// the Out channel in the CSV form of the data records.
+
+import (
+	"fmt"
+	"time"
+)
+
+// Result is a link found on a page, StatusCode is the status of the page it
+// was found on and Timestamp is when it was found.
+type Result struct {
+	SourceURL  string    `json:"source_url"`
+	FoundURL   string    `json:"found_url"`
+	StatusCode int       `json:"status"`
+	Timestamp  time.Time `json:"timestamp"`
+}
+
+// String returns the CSV form of the Result in the column order of the data
+// records, <status>,<source>,<found>.
+func (r Result) String() string {
+	return fmt.Sprintf("%d,%s,%s", r.StatusCode, r.SourceURL, r.FoundURL)
+}
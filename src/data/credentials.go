@@ -0,0 +1,38 @@
+package data
+
+// Credentials are shared between the crawler, which finds them in the
+// userinfo of the links, and the fetcher, which sends them with the requests
+// to the host they were found for.
+
+import (
+	"net/url"
+	"sync"
+)
+
+// Credentials holds the userinfo found for each host, the first userinfo
+// found for a host is kept.
+type Credentials struct {
+	mu    sync.Mutex
+	hosts map[string]*url.Userinfo
+}
+
+// NewCredentials returns a pointer to an empty data.Credentials structure
+func NewCredentials() *Credentials {
+	return &Credentials{hosts: map[string]*url.Userinfo{}}
+}
+
+// Add records the userinfo for a host unless the host already has some
+func (c *Credentials) Add(host string, user *url.Userinfo) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.hosts[host]; !ok {
+		c.hosts[host] = user
+	}
+}
+
+// Get returns the userinfo for a host, nil when none has been found
+func (c *Credentials) Get(host string) *url.Userinfo {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hosts[host]
+}
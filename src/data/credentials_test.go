@@ -0,0 +1,22 @@
+package data
+
+import (
+	"net/url"
+	"testing"
+)
+
+// Test that the first userinfo found for a host is the one that is kept
+func Test_Credentials(t *testing.T) {
+	creds := NewCredentials()
+	if creds.Get("example.com") != nil {
+		t.Error("Expected no credentials for a host that has not been added")
+	}
+
+	creds.Add("example.com", url.UserPassword("user", "pass"))
+	creds.Add("example.com", url.UserPassword("other", "secret"))
+
+	user := creds.Get("example.com")
+	if user == nil || user.String() != "user:pass" {
+		t.Errorf("Expected the credentials user:pass, got %v", user)
+	}
+}
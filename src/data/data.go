@@ -4,21 +4,100 @@ package data
 // structure available to other packages.
 // It returns an empty data.Data structure
 
-import "sync"
+import (
+	"net/url"
+	"sort"
+	"sync"
+)
 
 // Data structure to hold the map of all the links that have been found and
 // their value indicates if the link has or has not been scraped yet.
+// Depth holds the depth from the seed that each link was enqueued at and
+// Discovered holds the links found beyond the depth limit that are reported
+// but not scraped. Status holds the final status code of each link that has
+// been fetched.
 // The Mutex allows the structure to be locked so that only one process can
 // read or write to the structure at any given moment.
 type Data struct {
-	Mu    *sync.Mutex
-	Links map[string]bool
+	Mu         *sync.Mutex
+	Links      map[string]bool
+	Depth      map[string]int
+	Discovered map[string]bool
+	Status     map[string]int
+}
+
+// BrokenLink is a fetched link that returned a 4xx or 5xx status
+type BrokenLink struct {
+	URL    string
+	Status int
 }
 
 // NewData function returns a pointer to an empty data.Data structure
 func NewData() *Data {
 	return &Data{
-		Mu:    &sync.Mutex{},
-		Links: map[string]bool{},
+		Mu:         &sync.Mutex{},
+		Links:      map[string]bool{},
+		Depth:      map[string]int{},
+		Discovered: map[string]bool{},
+		Status:     map[string]int{},
+	}
+}
+
+// Broken returns the links whose final status was 400 or above ordered by
+// the URL.
+func (d *Data) Broken() []BrokenLink {
+	d.Mu.Lock()
+	var broken []BrokenLink
+	for link, status := range d.Status {
+		if status >= 400 {
+			broken = append(broken, BrokenLink{URL: link, Status: status})
+		}
+	}
+	d.Mu.Unlock()
+
+	sort.Slice(broken, func(i, j int) bool {
+		return broken[i].URL < broken[j].URL
+	})
+	return broken
+}
+
+// Hosts returns the sorted unique hosts of the links, including the port
+// when there is one.
+func (d *Data) Hosts() []string {
+	return d.unique(func(u *url.URL) string {
+		return u.Host
+	})
+}
+
+// Paths returns the sorted unique paths of the links, the query is left out
+// and an empty path is the root path /.
+func (d *Data) Paths() []string {
+	return d.unique(func(u *url.URL) string {
+		if len(u.Path) == 0 {
+			return "/"
+		}
+		return u.Path
+	})
+}
+
+// unique returns the sorted set of the parts of the links picked by part,
+// links that cannot be parsed are skipped.
+func (d *Data) unique(part func(u *url.URL) string) []string {
+	d.Mu.Lock()
+	defer d.Mu.Unlock()
+	set := map[string]bool{}
+	for link := range d.Links {
+		u, err := url.Parse(link)
+		if err != nil {
+			continue
+		}
+		set[part(u)] = true
+	}
+
+	parts := make([]string, 0, len(set))
+	for p := range set {
+		parts = append(parts, p)
 	}
+	sort.Strings(parts)
+	return parts
 }
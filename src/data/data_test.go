@@ -1,6 +1,8 @@
 package data
 
 import (
+	"reflect"
+	"strings"
 	"sync"
 	"testing"
 )
@@ -17,6 +19,10 @@ func Test_NewData(t *testing.T) {
 	if d.Links == nil {
 		t.Error("The Links map is not initialised in the data.Data structure")
 	}
+
+	if d.Depth == nil || d.Discovered == nil || d.Status == nil {
+		t.Error("The Depth, Discovered and Status maps are not initialised in the data.Data structure")
+	}
 }
 
 // Test concurrent access to the data.Data structure
@@ -48,3 +54,49 @@ func Test_Concurrency(t *testing.T) {
 		t.Error("The url " + url + " is expected to be in the data.Data.Links structure, but it isn't")
 	}
 }
+
+// Load a fixture set of links and test the unique hosts and paths
+func Test_HostsPaths(t *testing.T) {
+	d := NewData()
+	for _, link := range []string{
+		"https://example.com",
+		"https://example.com/blog?page=2",
+		"https://example.com/blog",
+		"http://example.com/about",
+		"https://example.com:8443/blog",
+	} {
+		d.Links[link] = true
+	}
+
+	hosts := strings.Join(d.Hosts(), ",")
+	if hosts != "example.com,example.com:8443" {
+		t.Errorf("The hosts are [%s], expected [example.com,example.com:8443]", hosts)
+	}
+	paths := strings.Join(d.Paths(), ",")
+	if paths != "/,/about,/blog" {
+		t.Errorf("The paths are [%s], expected [/,/about,/blog]", paths)
+	}
+}
+
+// Test that Broken lists the links with a 4xx or 5xx status ordered by URL
+func Test_Broken(t *testing.T) {
+	d := NewData()
+	d.Status["https://example.com/"] = 200
+	d.Status["https://example.com/gone"] = 410
+	d.Status["https://example.com/moved"] = 301
+	d.Status["https://example.com/error"] = 500
+	d.Status["https://example.com/missing"] = 404
+
+	expected := []BrokenLink{
+		{URL: "https://example.com/error", Status: 500},
+		{URL: "https://example.com/gone", Status: 410},
+		{URL: "https://example.com/missing", Status: 404},
+	}
+	if broken := d.Broken(); !reflect.DeepEqual(broken, expected) {
+		t.Errorf("Expected the broken links %v, got %v", expected, broken)
+	}
+
+	if broken := NewData().Broken(); len(broken) != 0 {
+		t.Errorf("Expected no broken links without any statuses, got %v", broken)
+	}
+}
@@ -0,0 +1,79 @@
+package data
+
+// The Graph structure records the source -> found edges that are discovered
+// while crawling so that the link structure of the site can be analysed once
+// the crawl has completed.
+
+import (
+	"sort"
+	"sync"
+)
+
+// Graph holds the set of pages that link to each URL, keyed by the target
+// URL. Storing the sources as a set ensures an edge is only counted once even
+// if the same page is processed more than once.
+type Graph struct {
+	Mu    *sync.Mutex
+	Edges map[string]map[string]bool
+}
+
+// Popularity pairs a URL with the number of distinct pages linking to it.
+type Popularity struct {
+	URL   string
+	Count int
+}
+
+// NewGraph function returns a pointer to an empty data.Graph structure
+func NewGraph() *Graph {
+	return &Graph{
+		Mu:    &sync.Mutex{},
+		Edges: map[string]map[string]bool{},
+	}
+}
+
+// AddEdges records an edge from the source page to each of the targets,
+// links from a page to itself are ignored.
+func (g *Graph) AddEdges(source string, targets []string) {
+	g.Mu.Lock()
+	defer g.Mu.Unlock()
+	for _, target := range targets {
+		if len(target) == 0 || target == source {
+			continue
+		}
+		if _, exists := g.Edges[target]; !exists {
+			g.Edges[target] = map[string]bool{}
+		}
+		g.Edges[target][source] = true
+	}
+}
+
+// InDegree returns the number of distinct pages that link to the url
+func (g *Graph) InDegree(url string) int {
+	g.Mu.Lock()
+	defer g.Mu.Unlock()
+	return len(g.Edges[url])
+}
+
+// Popular returns the n most linked to URLs, ordered by their in-degree
+// with ties broken by the URL so the ranking is deterministic. A value of n
+// that is zero or negative returns every URL.
+func (g *Graph) Popular(n int) []Popularity {
+	g.Mu.Lock()
+	ranked := make([]Popularity, 0, len(g.Edges))
+	for url, sources := range g.Edges {
+		ranked = append(ranked, Popularity{URL: url, Count: len(sources)})
+	}
+	g.Mu.Unlock()
+
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].Count != ranked[j].Count {
+			return ranked[i].Count > ranked[j].Count
+		}
+		return ranked[i].URL < ranked[j].URL
+	})
+
+	if n > 0 && n < len(ranked) {
+		ranked = ranked[:n]
+	}
+	return ranked
+}
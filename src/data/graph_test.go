@@ -0,0 +1,56 @@
+package data
+
+import "testing"
+
+// Build a small link graph and test the in-degree counts and the ranking
+// returned by the Popular method.
+func Test_Popular(t *testing.T) {
+	g := NewGraph()
+
+	g.AddEdges("https://example.com", []string{
+		"https://example.com/about",
+		"https://example.com/blog",
+		"https://example.com/contact",
+	})
+	g.AddEdges("https://example.com/about", []string{
+		"https://example.com",
+		"https://example.com/blog",
+		"https://example.com/contact",
+	})
+	g.AddEdges("https://example.com/blog", []string{
+		"https://example.com/blog", // Self links are not counted
+		"https://example.com/contact",
+	})
+	// Processing the same page twice should not inflate the counts
+	g.AddEdges("https://example.com/blog", []string{
+		"https://example.com/contact",
+	})
+
+	expected := map[string]int{
+		"https://example.com":         1,
+		"https://example.com/about":   1,
+		"https://example.com/blog":    2,
+		"https://example.com/contact": 3,
+	}
+	for url, count := range expected {
+		if g.InDegree(url) != count {
+			t.Errorf("The in-degree of %s is %d, expected %d", url, g.InDegree(url), count)
+		}
+	}
+
+	popular := g.Popular(3)
+	if len(popular) != 3 {
+		t.Fatalf("Expected 3 popular pages, got %d", len(popular))
+	}
+
+	ranking := []Popularity{
+		{URL: "https://example.com/contact", Count: 3},
+		{URL: "https://example.com/blog", Count: 2},
+		{URL: "https://example.com", Count: 1}, // Tie with /about sorted by URL
+	}
+	for i, p := range ranking {
+		if popular[i] != p {
+			t.Errorf("Popular page %d is %v, expected %v", i, popular[i], p)
+		}
+	}
+}
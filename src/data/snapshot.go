@@ -0,0 +1,115 @@
+package data
+
+// A Snapshot holds the hash of the content of every page of a crawl, it is
+// saved so that the next crawl can be compared with it to find the pages
+// that have changed since.
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+)
+
+// The classifications of a page when a crawl is compared with a baseline
+const (
+	Changed   = "changed"
+	Unchanged = "unchanged"
+	New       = "new"
+	Removed   = "removed"
+)
+
+// Snapshot holds the content hash of each page keyed by its URL
+type Snapshot struct {
+	mu     sync.Mutex
+	hashes map[string]string
+}
+
+// Change is the classification of a page against the baseline
+type Change struct {
+	URL    string
+	Status string
+}
+
+// NewSnapshot returns a pointer to an empty data.Snapshot structure
+func NewSnapshot() *Snapshot {
+	return &Snapshot{hashes: map[string]string{}}
+}
+
+// LoadSnapshot reads a snapshot saved by an earlier crawl, the file is a JSON
+// object of the hash of each URL.
+func LoadSnapshot(path string) (*Snapshot, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error opening baseline file: %v", err)
+	}
+	s := NewSnapshot()
+	if err := json.Unmarshal(raw, &s.hashes); err != nil {
+		return nil, fmt.Errorf("Error parsing baseline file %s: %v", path, err)
+	}
+	if s.hashes == nil {
+		s.hashes = map[string]string{}
+	}
+	return s, nil
+}
+
+// Record sets the content hash of a page, a page that is processed more than
+// once keeps the last hash.
+func (s *Snapshot) Record(url, hash string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hashes[url] = hash
+}
+
+// Save writes the snapshot to a file so it can be the baseline of the next
+// crawl, the URLs are sorted so that two snapshots can be diffed.
+func (s *Snapshot) Save(path string) error {
+	s.mu.Lock()
+	encoded, err := json.MarshalIndent(s.hashes, "", "  ")
+	s.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("Error encoding snapshot: %v", err)
+	}
+	if err := os.WriteFile(path, append(encoded, '\n'), 0644); err != nil {
+		return fmt.Errorf("Error writing snapshot file: %v", err)
+	}
+	return nil
+}
+
+// Compare classifies every page of the snapshot and the baseline, a page in
+// both is changed when its hash differs, a page only in the snapshot is new
+// and one only in the baseline was removed or not reached. The changes are
+// ordered by URL.
+func (s *Snapshot) Compare(baseline *Snapshot) []Change {
+	baseline.mu.Lock()
+	previous := make(map[string]string, len(baseline.hashes))
+	for url, hash := range baseline.hashes {
+		previous[url] = hash
+	}
+	baseline.mu.Unlock()
+
+	s.mu.Lock()
+	changes := make([]Change, 0, len(s.hashes))
+	for url, hash := range s.hashes {
+		before, ok := previous[url]
+		switch {
+		case !ok:
+			changes = append(changes, Change{URL: url, Status: New})
+		case before != hash:
+			changes = append(changes, Change{URL: url, Status: Changed})
+		default:
+			changes = append(changes, Change{URL: url, Status: Unchanged})
+		}
+		delete(previous, url)
+	}
+	s.mu.Unlock()
+	for url := range previous {
+		changes = append(changes, Change{URL: url, Status: Removed})
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].URL < changes[j].URL
+	})
+	return changes
+}
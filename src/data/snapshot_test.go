@@ -0,0 +1,52 @@
+package data
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Save the snapshot of a crawl and load it as the baseline of the next one,
+// which has a changed page, a new page and a page that is no longer found.
+// Test that every page is classified against the baseline.
+func Test_SnapshotCompare(t *testing.T) {
+	previous := NewSnapshot()
+	previous.Record("https://a.com", "home")
+	previous.Record("https://a.com/about", "about")
+	previous.Record("https://a.com/news", "news")
+	previous.Record("https://a.com/old", "old")
+
+	path := filepath.Join(t.TempDir(), "hashes.json")
+	if err := previous.Save(path); err != nil {
+		t.Fatalf("Failed to save the snapshot: %v", err)
+	}
+	baseline, err := LoadSnapshot(path)
+	if err != nil {
+		t.Fatalf("Failed to load the baseline: %v", err)
+	}
+
+	current := NewSnapshot()
+	current.Record("https://a.com", "home")
+	current.Record("https://a.com/about", "about")
+	current.Record("https://a.com/news", "news, updated")
+	current.Record("https://a.com/new", "new")
+
+	expected := []Change{
+		{URL: "https://a.com", Status: Unchanged},
+		{URL: "https://a.com/about", Status: Unchanged},
+		{URL: "https://a.com/new", Status: New},
+		{URL: "https://a.com/news", Status: Changed},
+		{URL: "https://a.com/old", Status: Removed},
+	}
+	if changes := current.Compare(baseline); fmt.Sprint(changes) != fmt.Sprint(expected) {
+		t.Errorf("The pages were classified as %v, expected %v", changes, expected)
+	}
+
+	if err := os.WriteFile(path, []byte("not json"), 0644); err != nil {
+		t.Fatalf("Failed to write the baseline: %v", err)
+	}
+	if _, err := LoadSnapshot(path); err == nil {
+		t.Error("Expected an error loading a baseline that is not JSON")
+	}
+}
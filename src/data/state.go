@@ -0,0 +1,78 @@
+package data
+
+// Save the links of a crawl to a file and load them again so an interrupted
+// crawl can be resumed, the links that were fetched are skipped and the rest
+// are crawled again.
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// savedLink is the state of a link in the file, a link with a status has
+// been fetched and one without is still waiting to be.
+type savedLink struct {
+	Depth  int `json:"depth"`
+	Status int `json:"status,omitempty"`
+}
+
+// Save writes the links that have been found to the file as a JSON object of
+// the depth and the status of each URL. It is written to a temporary file
+// that is renamed over the path, so an interrupted save leaves the last state.
+func (d *Data) Save(path string) error {
+	d.Mu.Lock()
+	links := make(map[string]savedLink, len(d.Links))
+	for link := range d.Links {
+		links[link] = savedLink{Depth: d.Depth[link], Status: d.Status[link]}
+	}
+	d.Mu.Unlock()
+
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("Error saving the crawl state %s: %v", path, err)
+	}
+	defer os.Remove(file.Name())
+	if err := json.NewEncoder(file).Encode(links); err != nil {
+		file.Close()
+		return fmt.Errorf("Error saving the crawl state %s: %v", path, err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("Error saving the crawl state %s: %v", path, err)
+	}
+	if err := os.Rename(file.Name(), path); err != nil {
+		return fmt.Errorf("Error saving the crawl state %s: %v", path, err)
+	}
+	return nil
+}
+
+// Load reads the links saved by Save, the links that were fetched are marked
+// as scraped with their status and the others as not scraped so they can be
+// enqueued again. A file that does not exist loads nothing so the crawl
+// starts afresh.
+func (d *Data) Load(path string) error {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Error opening the crawl state %s: %v", path, err)
+	}
+	defer file.Close()
+
+	var links map[string]savedLink
+	if err := json.NewDecoder(file).Decode(&links); err != nil {
+		return fmt.Errorf("Error loading the crawl state %s: %v", path, err)
+	}
+	d.Mu.Lock()
+	defer d.Mu.Unlock()
+	for link, saved := range links {
+		d.Links[link] = saved.Status != 0
+		d.Depth[link] = saved.Depth
+		if saved.Status != 0 {
+			d.Status[link] = saved.Status
+		}
+	}
+	return nil
+}
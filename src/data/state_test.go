@@ -0,0 +1,60 @@
+package data
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Save the links of a crawl, fetched and waiting to be fetched, and test that
+// loading them marks only the fetched links as scraped. The save replaces the
+// file without leaving its temporary file behind.
+func Test_SaveLoad(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "state.json")
+	if err := os.WriteFile(path, []byte("an earlier state"), 0644); err != nil {
+		t.Fatalf("Failed to write the earlier state: %v", err)
+	}
+
+	d := NewData()
+	d.Links["https://example.com"] = true
+	d.Depth["https://example.com"] = 0
+	d.Status["https://example.com"] = 200
+	d.Links["https://example.com/gone"] = true
+	d.Depth["https://example.com/gone"] = 1
+	d.Status["https://example.com/gone"] = 404
+	d.Links["https://example.com/pending"] = true
+	d.Depth["https://example.com/pending"] = 2
+	if err := d.Save(path); err != nil {
+		t.Fatalf("Failed to save the state: %v", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("Expected only the state file to be left, found %d files", len(entries))
+	}
+
+	loaded := NewData()
+	if err := loaded.Load(path); err != nil {
+		t.Fatalf("Failed to load the state: %v", err)
+	}
+	if !loaded.Links["https://example.com"] || !loaded.Links["https://example.com/gone"] || loaded.Status["https://example.com/gone"] != 404 {
+		t.Errorf("The fetched links were not loaded as scraped: %v %v", loaded.Links, loaded.Status)
+	}
+	if scraped, ok := loaded.Links["https://example.com/pending"]; !ok || scraped || loaded.Depth["https://example.com/pending"] != 2 {
+		t.Errorf("Expected the pending link to be loaded as not scraped at depth 2, got %v at %d", scraped, loaded.Depth["https://example.com/pending"])
+	}
+	if _, ok := loaded.Status["https://example.com/pending"]; ok {
+		t.Error("The pending link should not have a status")
+	}
+
+	// A missing file starts afresh and a corrupt one is an error
+	fresh := NewData()
+	if err := fresh.Load(filepath.Join(dir, "missing.json")); err != nil || len(fresh.Links) != 0 {
+		t.Errorf("Expected a missing state file to load nothing, got %v and %v", fresh.Links, err)
+	}
+	if err := os.WriteFile(path, []byte("{"), 0644); err != nil {
+		t.Fatalf("Failed to write the corrupt state: %v", err)
+	}
+	if err := fresh.Load(path); err == nil {
+		t.Error("Expected an error loading a corrupt state file")
+	}
+}
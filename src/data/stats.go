@@ -0,0 +1,180 @@
+package data
+
+// The Stats structure accumulates the counters that describe a crawl so that
+// a summary can be produced once it has completed.
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Stats holds the running totals for a crawl, the Mutex protects the
+// counters as they are updated by many goroutines.
+type Stats struct {
+	Mu       *sync.Mutex
+	Start    time.Time
+	Fetched  int
+	Errors   int
+	Dropped  int
+	Bytes    int64
+	Status   map[int]int
+	finished bool
+
+	// CheckpointEvery is the number of fetched pages between checkpoints,
+	// zero turns the checkpoints off.
+	CheckpointEvery int
+
+	// RunID identifies the crawl in the done and checkpoint records so they
+	// can be matched to the requests sent with the same X-Crawl-Id.
+	RunID string
+}
+
+// DoneEvent is the structured record emitted once when the crawl completes
+type DoneEvent struct {
+	Event      string            `json:"event"`
+	RunID      string            `json:"run_id,omitempty"`
+	Discovered int               `json:"discovered"`
+	Fetched    int               `json:"fetched"`
+	Errors     int               `json:"errors"`
+	Dropped    int               `json:"dropped"`
+	Bytes      int64             `json:"bytes"`
+	Duration   string            `json:"duration"`
+	DurationMs int64             `json:"duration_ms"`
+	Status     map[int]int       `json:"status"`
+	Config     map[string]string `json:"config"`
+}
+
+// CheckpointEvent is the structured record emitted each time CheckpointEvery
+// more pages have been fetched, it holds the counters so far.
+type CheckpointEvent struct {
+	Event      string      `json:"event"`
+	RunID      string      `json:"run_id,omitempty"`
+	Discovered int         `json:"discovered"`
+	Fetched    int         `json:"fetched"`
+	Errors     int         `json:"errors"`
+	Bytes      int64       `json:"bytes"`
+	Duration   string      `json:"duration"`
+	DurationMs int64       `json:"duration_ms"`
+	Status     map[int]int `json:"status"`
+}
+
+// NewStats function returns a pointer to an empty data.Stats structure with
+// the start time of the crawl set to now.
+func NewStats() *Stats {
+	return &Stats{
+		Mu:     &sync.Mutex{},
+		Start:  time.Now(),
+		Status: map[int]int{},
+	}
+}
+
+// RecordStatus counts a fetched page against its http status code, it
+// returns true when the count reaches a multiple of CheckpointEvery so that
+// a checkpoint is emitted once for each multiple.
+func (s *Stats) RecordStatus(code int) bool {
+	s.Mu.Lock()
+	defer s.Mu.Unlock()
+	s.Fetched++
+	s.Status[code]++
+	return s.CheckpointEvery > 0 && s.Fetched%s.CheckpointEvery == 0
+}
+
+// Checkpoint returns a checkpoint event built from the counters so far, the
+// number of discovered URLs and the bytes read, which are only added to the
+// totals once the crawl completes.
+func (s *Stats) Checkpoint(discovered int, bytes int64) *CheckpointEvent {
+	s.Mu.Lock()
+	defer s.Mu.Unlock()
+
+	elapsed := time.Since(s.Start)
+	return &CheckpointEvent{
+		Event:      "checkpoint",
+		RunID:      s.RunID,
+		Discovered: discovered,
+		Fetched:    s.Fetched,
+		Errors:     s.Errors,
+		Bytes:      s.Bytes + bytes,
+		Duration:   elapsed.Round(time.Millisecond).String(),
+		DurationMs: elapsed.Milliseconds(),
+		Status:     copyStatus(s.Status),
+	}
+}
+
+// RecordError counts an error reported during the crawl
+func (s *Stats) RecordError() {
+	s.Mu.Lock()
+	defer s.Mu.Unlock()
+	s.Errors++
+}
+
+// RecordDropped counts the output records dropped because the consumer of
+// the output could not keep up
+func (s *Stats) RecordDropped(count int) {
+	s.Mu.Lock()
+	defer s.Mu.Unlock()
+	s.Dropped += count
+}
+
+// RecordBytes adds the number of bytes read from the page bodies to the total
+func (s *Stats) RecordBytes(count int64) {
+	s.Mu.Lock()
+	defer s.Mu.Unlock()
+	s.Bytes += count
+}
+
+// Finish marks the crawl as complete and returns the done event built from
+// the counters, the number of discovered URLs and the configuration used.
+// Only the first call returns an event, later calls return nil so the event
+// can only ever be emitted once.
+func (s *Stats) Finish(discovered int, config map[string]string) *DoneEvent {
+	s.Mu.Lock()
+	defer s.Mu.Unlock()
+	if s.finished {
+		return nil
+	}
+	s.finished = true
+
+	elapsed := time.Since(s.Start)
+	return &DoneEvent{
+		Event:      "done",
+		RunID:      s.RunID,
+		Discovered: discovered,
+		Fetched:    s.Fetched,
+		Errors:     s.Errors,
+		Dropped:    s.Dropped,
+		Bytes:      s.Bytes,
+		Duration:   elapsed.Round(time.Millisecond).String(),
+		DurationMs: elapsed.Milliseconds(),
+		Status:     copyStatus(s.Status),
+		Config:     config,
+	}
+}
+
+// Summary returns a human readable tally of the crawl, the URLs discovered,
+// the pages fetched with their counts by status class, the errors and the
+// time the crawl took.
+func (e *DoneEvent) Summary() string {
+	var classes [6]int
+	for code, count := range e.Status {
+		if class := code / 100; class >= 2 && class <= 5 {
+			classes[class] += count
+		}
+	}
+	return fmt.Sprintf("Crawl summary\n"+
+		"  discovered: %d\n"+
+		"  fetched:    %d (2xx: %d, 3xx: %d, 4xx: %d, 5xx: %d)\n"+
+		"  errors:     %d\n"+
+		"  elapsed:    %s\n",
+		e.Discovered, e.Fetched, classes[2], classes[3], classes[4], classes[5], e.Errors, e.Duration)
+}
+
+// copyStatus returns a copy of the status counts so the event is not changed
+// by the pages fetched after it is built
+func copyStatus(counts map[int]int) map[int]int {
+	status := make(map[int]int, len(counts))
+	for code, count := range counts {
+		status[code] = count
+	}
+	return status
+}
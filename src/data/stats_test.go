@@ -0,0 +1,126 @@
+package data
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+)
+
+// Record statuses and errors concurrently and test the fields of the done
+// event, and that it is only returned the first time Finish is called.
+func Test_Finish(t *testing.T) {
+	s := NewStats()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if i < 7 {
+				s.RecordStatus(200)
+			} else {
+				s.RecordStatus(404)
+				s.RecordError()
+			}
+		}(i)
+	}
+	wg.Wait()
+	s.RecordDropped(2)
+	s.RecordDropped(3)
+	s.RecordBytes(1024)
+	s.RecordBytes(512)
+	s.RunID = "nightly-42"
+
+	done := s.Finish(12, map[string]string{"domain": "https://example.com"})
+	if done == nil {
+		t.Fatal("The first call to Finish should return the done event")
+	}
+
+	// Round trip the event through JSON to test the structured fields
+	encoded, err := json.Marshal(done)
+	if err != nil {
+		t.Fatalf("Failed to encode the done event: %v", err)
+	}
+	var event DoneEvent
+	if err := json.Unmarshal(encoded, &event); err != nil {
+		t.Fatalf("Failed to decode the done event: %v", err)
+	}
+
+	if event.Event != "done" {
+		t.Errorf("The event type is %s, expected done", event.Event)
+	}
+	if event.RunID != "nightly-42" {
+		t.Errorf("The run id of the done event is [%s], expected [nightly-42]", event.RunID)
+	}
+	if event.Discovered != 12 || event.Fetched != 10 || event.Errors != 3 || event.Dropped != 5 || event.Bytes != 1536 {
+		t.Errorf("Unexpected totals in the done event: %s", encoded)
+	}
+	if event.Status[200] != 7 || event.Status[404] != 3 {
+		t.Errorf("Unexpected status breakdown in the done event: %v", event.Status)
+	}
+	if event.Config["domain"] != "https://example.com" {
+		t.Errorf("The config used is missing from the done event: %v", event.Config)
+	}
+	if len(event.Duration) == 0 {
+		t.Error("The duration is missing from the done event")
+	}
+
+	if s.Finish(12, nil) != nil {
+		t.Error("The done event should only be returned once")
+	}
+}
+
+// Record the statuses of 10 pages with a checkpoint every 3 pages and test
+// that the checkpoints fire at 3, 6 and 9 with the counters at that point.
+func Test_Checkpoint(t *testing.T) {
+	s := NewStats()
+	s.CheckpointEvery = 3
+
+	var fired []int
+	for i := 1; i <= 10; i++ {
+		code := 200
+		if i%4 == 0 {
+			code = 500
+		}
+		if s.RecordStatus(code) {
+			checkpoint := s.Checkpoint(i*2, int64(i*100))
+			if checkpoint.Event != "checkpoint" || checkpoint.Fetched != i || checkpoint.Discovered != i*2 || checkpoint.Bytes != int64(i*100) {
+				t.Errorf("Unexpected counters in the checkpoint event: %+v", checkpoint)
+			}
+			fired = append(fired, checkpoint.Fetched)
+		}
+	}
+	if len(fired) != 3 || fired[0] != 3 || fired[1] != 6 || fired[2] != 9 {
+		t.Errorf("The checkpoints fired at %v, expected [3 6 9]", fired)
+	}
+	if checkpoint := s.Checkpoint(0, 0); checkpoint.Status[200] != 8 || checkpoint.Status[500] != 2 {
+		t.Errorf("Unexpected status breakdown in the checkpoint event: %v", checkpoint.Status)
+	}
+
+	s = NewStats()
+	for i := 0; i < 10; i++ {
+		if s.RecordStatus(200) {
+			t.Fatal("No checkpoints should fire when CheckpointEvery is 0")
+		}
+	}
+}
+
+// Build a done event with statuses from each class and test the summary
+// counts the pages of each class.
+func Test_Summary(t *testing.T) {
+	event := &DoneEvent{
+		Discovered: 12,
+		Fetched:    10,
+		Errors:     2,
+		Duration:   "1.5s",
+		Status:     map[int]int{200: 5, 204: 1, 301: 1, 404: 2, 503: 1},
+	}
+	expected := "Crawl summary\n" +
+		"  discovered: 12\n" +
+		"  fetched:    10 (2xx: 6, 3xx: 1, 4xx: 2, 5xx: 1)\n" +
+		"  errors:     2\n" +
+		"  elapsed:    1.5s\n"
+	if summary := event.Summary(); summary != expected {
+		t.Errorf("Unexpected summary:\n%s\nexpected:\n%s", summary, expected)
+	}
+}
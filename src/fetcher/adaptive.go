@@ -0,0 +1,115 @@
+package fetcher
+
+// An adaptive delay between the requests to each host. The delay is doubled
+// while the moving average of the host's response times is above a target,
+// and is decreased by a step while it is below, so the crawl backs off
+// quickly when a server slows down and speeds up gradually as it recovers.
+
+import (
+	"sync"
+	"time"
+)
+
+// adaptiveWeight is the weight of the latest response time in the moving
+// average of a host's response times
+const adaptiveWeight = 0.3
+
+// hostDelay is the state of the adaptive delay for a single host, next is
+// the earliest time the next request can be sent.
+type hostDelay struct {
+	average time.Duration
+	delay   time.Duration
+	next    time.Time
+}
+
+// AdaptiveDelay spaces out the requests to each host by a delay that follows
+// the host's response times, it is kept within Min and Max.
+type AdaptiveDelay struct {
+	Target time.Duration
+	Min    time.Duration
+	Max    time.Duration
+
+	// Step is how much the delay is decreased by after a fast response, and
+	// the delay it is increased to from zero.
+	Step  time.Duration
+	clock Clock
+
+	mu    sync.Mutex
+	hosts map[string]*hostDelay
+}
+
+// NewAdaptiveDelay returns a pointer to a fetcher.AdaptiveDelay for the
+// target response time, the clock is used for the pauses between requests.
+func NewAdaptiveDelay(target, min, max time.Duration, clock Clock) *AdaptiveDelay {
+	return &AdaptiveDelay{
+		Target: target,
+		Min:    min,
+		Max:    max,
+		Step:   100 * time.Millisecond,
+		clock:  clock,
+		hosts:  map[string]*hostDelay{},
+	}
+}
+
+// host returns the delay state of a host, starting at the Min delay
+func (a *AdaptiveDelay) host(host string) *hostDelay {
+	h, ok := a.hosts[host]
+	if !ok {
+		h = &hostDelay{delay: a.Min}
+		a.hosts[host] = h
+	}
+	return h
+}
+
+// Wait pauses until a request can be sent to the host. The requests from
+// the workers are given turns, each one the host's delay after the last.
+func (a *AdaptiveDelay) Wait(host string) {
+	a.mu.Lock()
+	h := a.host(host)
+	now := a.clock.Now()
+	turn := h.next
+	if turn.Before(now) {
+		turn = now
+	}
+	h.next = turn.Add(h.delay)
+	a.mu.Unlock()
+
+	if wait := turn.Sub(now); wait > 0 {
+		a.clock.Sleep(wait)
+	}
+}
+
+// Observe records the response time of a request to the host and adjusts
+// its delay by the new moving average.
+func (a *AdaptiveDelay) Observe(host string, latency time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	h := a.host(host)
+	if h.average == 0 {
+		h.average = latency
+	} else {
+		h.average += time.Duration(adaptiveWeight * float64(latency-h.average))
+	}
+
+	if h.average > a.Target {
+		h.delay *= 2
+		if h.delay < a.Step {
+			h.delay = a.Step
+		}
+	} else {
+		h.delay -= a.Step
+	}
+	if h.delay > a.Max {
+		h.delay = a.Max
+	}
+	if h.delay < a.Min {
+		h.delay = a.Min
+	}
+}
+
+// Delay returns the current delay between the requests to the host
+func (a *AdaptiveDelay) Delay(host string) time.Duration {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.host(host).delay
+}
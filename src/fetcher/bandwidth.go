@@ -0,0 +1,88 @@
+package fetcher
+
+// Limit the rate the response bodies are read at, a single token bucket of
+// bytes is shared by every worker so the total download rate is bounded no
+// matter how many requests are in flight.
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// Bandwidth is a token bucket filled at Rate bytes per second, readers take
+// the bytes they have read from it and wait when it is empty.
+type Bandwidth struct {
+	Rate  int64
+	clock Clock
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// NewBandwidth returns a pointer to a fetcher.Bandwidth limited to rate bytes
+// per second, the bucket starts empty so there is no initial burst.
+func NewBandwidth(rate int64, clock Clock) *Bandwidth {
+	return &Bandwidth{
+		Rate:  rate,
+		clock: clock,
+		last:  clock.Now(),
+	}
+}
+
+// chunk is the most that is read in one call, a tenth of a second's worth
+// of bytes keeps the pauses short and the rate smooth.
+func (b *Bandwidth) chunk() int {
+	size := b.Rate / 10
+	if size < 1 {
+		size = 1
+	}
+	return int(size)
+}
+
+// take removes n bytes from the bucket and pauses until they have been paid
+// for. The bucket can go into debt, so concurrent readers queue behind each
+// other and each one waits for its share of the rate.
+func (b *Bandwidth) take(n int) {
+	b.mu.Lock()
+	now := b.clock.Now()
+	rate := float64(b.Rate)
+	b.tokens += now.Sub(b.last).Seconds() * rate
+	if burst := float64(b.chunk()); b.tokens > burst {
+		b.tokens = burst
+	}
+	b.last = now
+	b.tokens -= float64(n)
+	var wait time.Duration
+	if b.tokens < 0 {
+		wait = time.Duration(-b.tokens / rate * float64(time.Second))
+	}
+	b.mu.Unlock()
+
+	if wait > 0 {
+		b.clock.Sleep(wait)
+	}
+}
+
+// Reader wraps a response body so that reading it is limited by the bucket
+func (b *Bandwidth) Reader(body io.ReadCloser) io.ReadCloser {
+	return &limitedBody{ReadCloser: body, bandwidth: b}
+}
+
+// limitedBody is a response body that is read no faster than its Bandwidth
+type limitedBody struct {
+	io.ReadCloser
+	bandwidth *Bandwidth
+}
+
+func (l *limitedBody) Read(p []byte) (int, error) {
+	if size := l.bandwidth.chunk(); len(p) > size {
+		p = p[:size]
+	}
+	n, err := l.ReadCloser.Read(p)
+	if n > 0 {
+		l.bandwidth.take(n)
+	}
+	return n, err
+}
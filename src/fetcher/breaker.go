@@ -0,0 +1,98 @@
+package fetcher
+
+// A circuit breaker for each host, once a host has failed too many requests
+// in a row the requests to it are stopped for a cooldown, after which a
+// single trial request decides whether it has recovered.
+
+import (
+	"sync"
+	"time"
+)
+
+// The states of a host's circuit
+const (
+	closed = iota
+	open
+	halfOpen
+)
+
+// circuit is the state of the breaker for a single host
+type circuit struct {
+	state    int
+	failures int
+	opened   time.Time
+}
+
+// Breaker tracks the consecutive failures of each host, a host's circuit
+// opens after Threshold failures and stays open for the Cooldown.
+type Breaker struct {
+	Threshold int
+	Cooldown  time.Duration
+	clock     Clock
+
+	mu    sync.Mutex
+	hosts map[string]*circuit
+}
+
+// NewBreaker returns a pointer to a fetcher.Breaker, the clock is used to
+// time the cooldown.
+func NewBreaker(threshold int, cooldown time.Duration, clock Clock) *Breaker {
+	return &Breaker{
+		Threshold: threshold,
+		Cooldown:  cooldown,
+		clock:     clock,
+		hosts:     map[string]*circuit{},
+	}
+}
+
+// host returns the circuit for a host, creating a closed one the first time
+func (b *Breaker) host(host string) *circuit {
+	c, ok := b.hosts[host]
+	if !ok {
+		c = &circuit{}
+		b.hosts[host] = c
+	}
+	return c
+}
+
+// Allow reports whether a request can be made to the host. An open circuit
+// allows a single trial request once the cooldown has passed, no others are
+// allowed until the outcome of the trial is recorded.
+func (b *Breaker) Allow(host string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c := b.host(host)
+	switch c.state {
+	case open:
+		if b.clock.Now().Sub(c.opened) < b.Cooldown {
+			return false
+		}
+		c.state = halfOpen
+		return true
+	case halfOpen:
+		return false
+	}
+	return true
+}
+
+// Success closes the host's circuit and resets its failures
+func (b *Breaker) Success(host string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c := b.host(host)
+	c.state = closed
+	c.failures = 0
+}
+
+// Failure counts a failed request to the host, the circuit opens when the
+// threshold is reached or when the trial request of a half open circuit fails.
+func (b *Breaker) Failure(host string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c := b.host(host)
+	c.failures++
+	if c.state == halfOpen || c.failures >= b.Threshold {
+		c.state = open
+		c.opened = b.clock.Now()
+	}
+}
@@ -0,0 +1,57 @@
+package fetcher
+
+// Count the bytes read from the response bodies across all of the workers
+// so that the crawl can be stopped once a total byte budget has been spent.
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// byteBudget holds the number of bytes read against the max, spent is
+// called once the first time more than max bytes have been read.
+type byteBudget struct {
+	max   int64
+	read  atomic.Int64
+	once  sync.Once
+	spent func(read int64)
+}
+
+// newByteBudget returns a pointer to a byteBudget of max bytes
+func newByteBudget(max int64, spent func(read int64)) *byteBudget {
+	return &byteBudget{max: max, spent: spent}
+}
+
+// exceeded reports whether more than max bytes have been read
+func (b *byteBudget) exceeded() bool {
+	return b.read.Load() > b.max
+}
+
+// add counts n bytes that have been read
+func (b *byteBudget) add(n int) {
+	if read := b.read.Add(int64(n)); read > b.max {
+		b.once.Do(func() {
+			b.spent(read)
+		})
+	}
+}
+
+// Reader wraps a response body so the bytes read from it are counted
+func (b *byteBudget) Reader(body io.ReadCloser) io.ReadCloser {
+	return &countedBody{ReadCloser: body, budget: b}
+}
+
+// countedBody is a response body whose reads are counted against a budget
+type countedBody struct {
+	io.ReadCloser
+	budget *byteBudget
+}
+
+func (c *countedBody) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	if n > 0 {
+		c.budget.add(n)
+	}
+	return n, err
+}
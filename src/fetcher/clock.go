@@ -0,0 +1,33 @@
+package fetcher
+
+// The Clock interface abstracts the passing of time so that the retry and
+// delay logic in the fetcher can be driven by tests without waiting in real
+// time.
+
+import "time"
+
+// Clock provides the current time and the ability to pause for a duration,
+// After is the pause as a channel so that it can be given up part way.
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+	After(d time.Duration) <-chan time.Time
+}
+
+// realClock is the default Clock, it uses the time package directly
+type realClock struct{}
+
+// Now returns the current local time
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+// Sleep pauses the calling goroutine for the duration d
+func (realClock) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+// After returns a channel that receives the time once the duration d passes
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
@@ -0,0 +1,93 @@
+package fetcher
+
+// The Resolver pre-resolves the hosts of newly discovered URLs in the
+// background so that the DNS lookup is not on the critical path when a
+// request is made to them. Lookups are bounded by their own concurrency
+// limit and the results are cached for the fetcher's dialer.
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Resolver caches the addresses of the hosts that have been prefetched
+type Resolver struct {
+	Lookup  func(ctx context.Context, host string) ([]string, error)
+	Timeout time.Duration
+
+	mu    sync.Mutex
+	hosts map[string][]string
+	seen  map[string]bool
+	sem   chan struct{}
+	wg    sync.WaitGroup
+}
+
+// NewResolver returns a pointer to a fetcher.Resolver that performs at most
+// concurrency lookups at once using the default net.Resolver.
+func NewResolver(concurrency int) *Resolver {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return &Resolver{
+		Lookup:  net.DefaultResolver.LookupHost,
+		Timeout: 5 * time.Second,
+		hosts:   map[string][]string{},
+		seen:    map[string]bool{},
+		sem:     make(chan struct{}, concurrency),
+	}
+}
+
+// Prefetch starts resolving the host of rawUrl in the background if it has
+// not been seen before, IP addresses and invalid URLs are ignored.
+func (r *Resolver) Prefetch(rawUrl string) {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return
+	}
+	host := u.Hostname()
+	if len(host) == 0 || net.ParseIP(host) != nil {
+		return
+	}
+
+	r.mu.Lock()
+	if r.seen[host] {
+		r.mu.Unlock()
+		return
+	}
+	r.seen[host] = true
+	r.mu.Unlock()
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		r.sem <- struct{}{}
+		defer func() { <-r.sem }()
+
+		ctx, cancel := context.WithTimeout(context.Background(), r.Timeout)
+		defer cancel()
+		addrs, err := r.Lookup(ctx, host)
+		if err != nil || len(addrs) == 0 {
+			return
+		}
+
+		r.mu.Lock()
+		r.hosts[host] = addrs
+		r.mu.Unlock()
+	}()
+}
+
+// Addrs returns the cached addresses for a host, nil if it has not been
+// resolved.
+func (r *Resolver) Addrs(host string) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.hosts[host]
+}
+
+// Wait blocks until all of the lookups that have been started are complete
+func (r *Resolver) Wait() {
+	r.wg.Wait()
+}
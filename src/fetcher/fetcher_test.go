@@ -1,11 +1,26 @@
 package fetcher
 
 import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
 	"io"
+	"linkcrawl/data"
+	"linkcrawl/random"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 )
@@ -60,3 +75,2314 @@ func Test_Fetcher(t *testing.T) {
 		}
 	}
 }
+
+// Spawn a test server that tracks the number of requests it is handling at
+// once and test that the fetcher never exceeds MaxInFlight concurrent
+// requests, even though there are more workers than slots.
+func Test_MaxInFlight(t *testing.T) {
+	var mu sync.Mutex
+	current, highest := 0, 0
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		current++
+		if current > highest {
+			highest = current
+		}
+		mu.Unlock()
+
+		time.Sleep(50 * time.Millisecond)
+
+		mu.Lock()
+		current--
+		mu.Unlock()
+		fmt.Fprint(w, "<html></html>")
+	}))
+	defer ts.Close()
+
+	output := make(chan string)
+	errors := make(chan error)
+	fetch := make(chan *http.Response)
+	done := make(chan struct{})
+	defer close(done)
+
+	fetcher := NewFetcher(10, 0, 5*time.Second, output, errors, fetch, done)
+	fetcher.MaxInFlight = 2
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go fetcher.StartFetching(&wg)
+
+	requests := 10
+	go func() {
+		for i := 0; i < requests; i++ {
+			fetcher.NewRequest(ts.URL)
+		}
+	}()
+
+	for i := 0; i < requests; i++ {
+		resp := <-fetch
+		resp.Body.Close()
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if highest > fetcher.MaxInFlight {
+		t.Errorf("%d concurrent requests were made, the limit is %d", highest, fetcher.MaxInFlight)
+	}
+}
+
+// Spawn a test server that holds each request until it is released, raise
+// and then lower the in-flight limit while requests are waiting and test that
+// the number of requests the server handles at once follows the limit.
+func Test_Resize(t *testing.T) {
+	var mu sync.Mutex
+	current, highest := 0, 0
+	release := make(chan struct{}, 6)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		current++
+		if current > highest {
+			highest = current
+		}
+		mu.Unlock()
+
+		<-release
+
+		mu.Lock()
+		current--
+		mu.Unlock()
+		fmt.Fprint(w, "<html></html>")
+	}))
+	defer ts.Close()
+	inFlight := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return current
+	}
+	waitFor := func(expected int) {
+		t.Helper()
+		for start := time.Now(); inFlight() != expected; time.Sleep(time.Millisecond) {
+			if time.Since(start) > 2*time.Second {
+				t.Fatalf("%d requests are in flight, expected %d", inFlight(), expected)
+			}
+		}
+	}
+
+	output := make(chan string, 10)
+	errors := make(chan error)
+	fetch := make(chan *http.Response)
+	done := make(chan struct{})
+	defer close(done)
+
+	fetcher := NewFetcher(6, 0, 5*time.Second, output, errors, fetch, done)
+	fetcher.MaxInFlight = 2
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go fetcher.StartFetching(&wg)
+
+	requests := 6
+	go func() {
+		for i := 0; i < requests; i++ {
+			fetcher.NewRequest(ts.URL)
+		}
+	}()
+	var fetched atomic.Int32
+	go func() {
+		for i := 0; i < requests; i++ {
+			resp := <-fetch
+			resp.Body.Close()
+			fetched.Add(1)
+		}
+	}()
+
+	waitFor(2)
+	time.Sleep(20 * time.Millisecond)
+	if inFlight() != 2 {
+		t.Fatalf("%d requests are in flight, the limit is 2", inFlight())
+	}
+
+	if limit := fetcher.Resize(2); limit != 4 {
+		t.Errorf("The limit was raised to %d, expected 4", limit)
+	}
+	waitFor(4)
+
+	// The requests in flight finish when the limit is lowered, the rest are
+	// then made one at a time
+	if limit := fetcher.Resize(-3); limit != 1 {
+		t.Errorf("The limit was lowered to %d, expected 1", limit)
+	}
+	for i := 0; i < 4; i++ {
+		release <- struct{}{}
+	}
+	for start := time.Now(); fetched.Load() < 4; time.Sleep(time.Millisecond) {
+		if time.Since(start) > 2*time.Second {
+			t.Fatalf("Only %d of the held requests completed", fetched.Load())
+		}
+	}
+	mu.Lock()
+	highest = current
+	mu.Unlock()
+	release <- struct{}{}
+	release <- struct{}{}
+	for start := time.Now(); fetched.Load() < int32(requests); time.Sleep(time.Millisecond) {
+		if time.Since(start) > 2*time.Second {
+			t.Fatalf("Only %d of the requests completed", fetched.Load())
+		}
+	}
+	mu.Lock()
+	if highest > 1 {
+		t.Errorf("%d concurrent requests were made after the limit was lowered to 1", highest)
+	}
+	mu.Unlock()
+
+	// The limit is kept between 1 and the number of workers
+	if limit := fetcher.Resize(-10); limit != 1 {
+		t.Errorf("The limit was lowered to %d, expected 1", limit)
+	}
+	if limit := fetcher.Resize(10); limit != 6 {
+		t.Errorf("The limit was raised to %d, expected the 6 workers", limit)
+	}
+	expected := []string{"concurrency,4", "concurrency,1", "concurrency,1", "concurrency,6"}
+	for _, record := range expected {
+		if got := <-output; got != record {
+			t.Errorf("Unexpected record [%s], expected [%s]", got, record)
+		}
+	}
+}
+
+// fakeClock records the pauses the fetcher makes and advances its own time
+// instead of sleeping.
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	sleeps []time.Duration
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Sleep(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sleeps = append(c.sleeps, d)
+	c.now = c.now.Add(d)
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.Sleep(d)
+	passed := make(chan time.Time, 1)
+	passed <- c.Now()
+	return passed
+}
+
+// Spawn a test server that holds back its response headers and test that
+// each attempt is aborted near the Timeout and reported as timed out rather
+// than waiting for the server. A response whose headers arrive in time but
+// whose body is streamed slowly fails the read of the body at the Timeout.
+func Test_Timeout(t *testing.T) {
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer slow.Close()
+	defer close(release)
+
+	streaming := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		time.Sleep(300 * time.Millisecond)
+		fmt.Fprint(w, "<html></html>")
+	}))
+	defer streaming.Close()
+
+	output := make(chan string)
+	errors := make(chan error)
+	fetch := make(chan *http.Response)
+	done := make(chan struct{})
+	defer close(done)
+
+	fetcher := NewFetcher(1, 1, 100*time.Millisecond, output, errors, fetch, done)
+	fetcher.Clock = &fakeClock{now: time.Now()}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go fetcher.StartFetching(&wg)
+
+	began := time.Now()
+	go fetcher.NewRequest(slow.URL)
+	for i := 0; i <= fetcher.RetryCount; i++ {
+		select {
+		case err := <-errors:
+			if !strings.Contains(err.Error(), "Timed out fetching") {
+				t.Errorf("Expected the attempt to time out, got: %v", err)
+			}
+		case <-fetch:
+			t.Fatal("The response of the slow server should not be delivered")
+		case <-time.After(5 * time.Second):
+			t.Fatal("The request was not aborted by the timeout")
+		}
+	}
+	if resp := <-fetch; resp != nil {
+		t.Fatal("Expected the request that timed out to be answered with a nil response")
+	}
+	if elapsed := time.Since(began); elapsed > time.Second {
+		t.Errorf("The %d attempts took %v, expected them to be aborted after 100ms each", fetcher.RetryCount+1, elapsed)
+	}
+
+	go fetcher.NewRequest(streaming.URL)
+	select {
+	case resp := <-fetch:
+		began = time.Now()
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err == nil || !strings.Contains(err.Error(), "timed out reading the body after 100ms") || len(body) != 0 {
+			t.Errorf("Expected the slow body to time out, read [%s]: %v", body, err)
+		}
+		if elapsed := time.Since(began); elapsed > 250*time.Millisecond {
+			t.Errorf("The read of the slow body took %v, expected it to be aborted by the timeout", elapsed)
+		}
+	case err := <-errors:
+		t.Fatalf("Failed to fetch the streaming page: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the response")
+	}
+}
+
+// Load the robots.txt and probe the seed's host with a Timeout of 0, which
+// is no limit, and test that neither request fails with a deadline that has
+// already passed so the Disallow rules are applied.
+func Test_NoTimeout(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			fmt.Fprint(w, "User-agent: *\nDisallow: /private\n")
+			return
+		}
+		fmt.Fprint(w, "present")
+	}))
+	defer ts.Close()
+
+	output := make(chan string, 10)
+	errs := make(chan error, 10)
+	fetcher := NewFetcher(1, 0, 0, output, errs, nil, nil)
+	seed, _ := url.Parse(ts.URL)
+	fetcher.LoadRobots(seed)
+	if fetcher.robots == nil || fetcher.robots.Allowed(&url.URL{Host: seed.Host, Path: "/private/page"}) {
+		t.Errorf("The robots.txt was not applied without a timeout, with %d errors", len(errs))
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	fetcher.Probe(seed, []string{"/favicon.ico"}, &wg)
+	if record := <-output; record != fmt.Sprintf("probe,%s/favicon.ico,200", ts.URL) {
+		t.Errorf("Unexpected probe record without a timeout %s", record)
+	}
+	if len(errs) != 0 {
+		t.Errorf("Unexpected error without a timeout: %v", <-errs)
+	}
+}
+
+// Request a URL that refuses connections and test that each retry pauses
+// on the injected clock rather than in real time.
+func Test_RetryClock(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	unreachable := ts.URL
+	ts.Close()
+
+	output := make(chan string)
+	errors := make(chan error)
+	fetch := make(chan *http.Response)
+	done := make(chan struct{})
+	defer close(done)
+
+	clock := &fakeClock{now: time.Now()}
+	start := clock.Now()
+	fetcher := NewFetcher(1, 3, 5*time.Second, output, errors, fetch, done)
+	fetcher.Clock = clock
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go fetcher.StartFetching(&wg)
+
+	began := time.Now()
+	go fetcher.NewRequest(unreachable)
+
+	// One error is reported for the first attempt and each of the retries
+	for i := 0; i <= fetcher.RetryCount; i++ {
+		select {
+		case <-errors:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for the fetch errors")
+		}
+	}
+
+	if time.Since(began) > 2*time.Second {
+		t.Error("The retries should not wait in real time")
+	}
+
+	clock.mu.Lock()
+	defer clock.mu.Unlock()
+	if len(clock.sleeps) != fetcher.RetryCount {
+		t.Fatalf("Expected %d pauses between attempts, got %d", fetcher.RetryCount, len(clock.sleeps))
+	}
+	for _, d := range clock.sleeps {
+		if d != fetcher.RetryDelay {
+			t.Errorf("Paused for %v between retries, expected %v", d, fetcher.RetryDelay)
+		}
+	}
+	if elapsed := clock.now.Sub(start); elapsed != 3*fetcher.RetryDelay {
+		t.Errorf("The clock advanced by %v, expected %v", elapsed, 3*fetcher.RetryDelay)
+	}
+}
+
+// Prefetch the hosts for several URLs using a stub lookup and test that each
+// new host is only resolved once, then test that the fetcher dials the
+// prefetched address for a host that does not exist in DNS.
+func Test_DNSPrefetch(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "<html></html>")
+	}))
+	defer ts.Close()
+
+	var mu sync.Mutex
+	lookups := map[string]int{}
+
+	resolver := NewResolver(2)
+	resolver.Lookup = func(ctx context.Context, host string) ([]string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		lookups[host]++
+		return []string{"127.0.0.1"}, nil
+	}
+
+	port := ts.URL[strings.LastIndex(ts.URL, ":"):]
+	for _, url := range []string{
+		"http://crawl.invalid" + port + "/",
+		"http://crawl.invalid" + port + "/about",
+		"http://other.invalid/",
+		"http://127.0.0.1/", // IP addresses are not looked up
+		ts.URL,
+	} {
+		resolver.Prefetch(url)
+	}
+	resolver.Wait()
+
+	mu.Lock()
+	if len(lookups) != 2 || lookups["crawl.invalid"] != 1 || lookups["other.invalid"] != 1 {
+		t.Errorf("Expected a single lookup for each new host, got %v", lookups)
+	}
+	mu.Unlock()
+
+	output := make(chan string)
+	errors := make(chan error)
+	fetch := make(chan *http.Response)
+	done := make(chan struct{})
+	defer close(done)
+
+	fetcher := NewFetcher(1, 0, 5*time.Second, output, errors, fetch, done)
+	fetcher.Resolver = resolver
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go fetcher.StartFetching(&wg)
+
+	fetcher.NewRequest("http://crawl.invalid" + port + "/")
+	select {
+	case resp := <-fetch:
+		resp.Body.Close()
+		if resp.StatusCode != 200 {
+			t.Errorf("Invalid Status Code %d", resp.StatusCode)
+		}
+	case err := <-errors:
+		t.Errorf("The prefetched address was not used: %v", err)
+	}
+}
+
+// Spawn a test server that sets a session cookie on a 302 and requires it
+// on the redirect target, test that the target is only reached with the
+// cookie when the cookie jar is enabled.
+func Test_CookiesOnRedirect(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/login":
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "abc123", Path: "/"})
+			http.Redirect(w, r, "/account", http.StatusFound)
+		case "/account":
+			cookie, err := r.Cookie("session")
+			if err != nil || cookie.Value != "abc123" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			fmt.Fprint(w, "<html></html>")
+		}
+	}))
+	defer ts.Close()
+
+	for _, enabled := range []bool{false, true} {
+		output := make(chan string, 10)
+		errors := make(chan error)
+		fetch := make(chan *http.Response)
+		done := make(chan struct{})
+
+		fetcher := NewFetcher(1, 0, 5*time.Second, output, errors, fetch, done)
+		if enabled {
+			fetcher.EnableCookies()
+		}
+
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go fetcher.StartFetching(&wg)
+
+		fetcher.NewRequest(ts.URL + "/login")
+		select {
+		case resp := <-fetch:
+			resp.Body.Close()
+			expected := http.StatusUnauthorized
+			if enabled {
+				expected = http.StatusOK
+			}
+			if resp.StatusCode != expected {
+				t.Errorf("With cookies enabled %v the status is %d, expected %d", enabled, resp.StatusCode, expected)
+			}
+			if resp.Request.URL.Path != "/account" {
+				t.Errorf("The redirect was not followed to /account: %s", resp.Request.URL)
+			}
+			if msg := <-output; msg != fmt.Sprintf("redirect,%s/login,%s/account,302", ts.URL, ts.URL) {
+				t.Errorf("Unexpected redirect record %s", msg)
+			}
+		case err := <-errors:
+			t.Errorf("Failed to fetch the redirect chain: %v", err)
+		}
+
+		close(done)
+		wg.Wait()
+	}
+}
+
+// Spawn a test server that records the headers of a request and test that
+// the default User-Agent, the configured User-Agent and the extra headers are
+// sent. An extra User-Agent or Host header replaces the one set by the fetcher.
+func Test_Headers(t *testing.T) {
+	received := make(chan *http.Request, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r
+		fmt.Fprint(w, "<html></html>")
+	}))
+	defer ts.Close()
+
+	testCases := []struct {
+		agent    string
+		headers  http.Header
+		expected string
+		host     string
+	}{
+		{DefaultUserAgent, nil, "go-web-scraper/1.0", ""},
+		{"audit-bot/2.0", http.Header{"X-Api-Key": {"secret"}}, "audit-bot/2.0", ""},
+		{"", nil, "Go-http-client/1.1", ""},
+		{"audit-bot/2.0", http.Header{"User-Agent": {"header-bot"}, "Host": {"www.example.com"}}, "header-bot", "www.example.com"},
+	}
+	for _, tc := range testCases {
+		output := make(chan string)
+		errors := make(chan error)
+		fetch := make(chan *http.Response)
+		done := make(chan struct{})
+
+		fetcher := NewFetcher(1, 0, 5*time.Second, output, errors, fetch, done)
+		fetcher.UserAgent = tc.agent
+		fetcher.Headers = tc.headers
+
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go fetcher.StartFetching(&wg)
+
+		fetcher.NewRequest(ts.URL)
+		select {
+		case resp := <-fetch:
+			resp.Body.Close()
+		case err := <-errors:
+			t.Fatalf("Failed to fetch the page: %v", err)
+		}
+		r := <-received
+		if r.UserAgent() != tc.expected {
+			t.Errorf("The request was sent with the User-Agent [%s], expected [%s]", r.UserAgent(), tc.expected)
+		}
+		if key := tc.headers.Get("X-Api-Key"); r.Header.Get("X-Api-Key") != key {
+			t.Errorf("The request was sent with the X-Api-Key [%s], expected [%s]", r.Header.Get("X-Api-Key"), key)
+		}
+		if tc.host != "" && r.Host != tc.host {
+			t.Errorf("The request was sent to the host [%s], expected [%s]", r.Host, tc.host)
+		}
+		close(done)
+		wg.Wait()
+	}
+}
+
+// Spawn a test server that records the User-Agent of each request, load
+// the rotation list from a file and test that the agents are used in turn.
+// A single agent is sent with every request.
+func Test_UserAgentRotation(t *testing.T) {
+	var mu sync.Mutex
+	var agents []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		agents = append(agents, r.UserAgent())
+		mu.Unlock()
+		fmt.Fprint(w, "<html></html>")
+	}))
+	defer ts.Close()
+
+	path := filepath.Join(t.TempDir(), "agents.txt")
+	if err := os.WriteFile(path, []byte("agent-one\n\n# a comment\nagent-two\n  agent-three  \n"), 0644); err != nil {
+		t.Fatalf("Failed to write the user agents file: %v", err)
+	}
+	rotation, err := LoadUserAgents(path)
+	if err != nil {
+		t.Fatalf("Failed to load the user agents: %v", err)
+	}
+
+	for _, configured := range [][]string{rotation, {"only-agent"}} {
+		mu.Lock()
+		agents = nil
+		mu.Unlock()
+
+		output := make(chan string)
+		errors := make(chan error)
+		fetch := make(chan *http.Response)
+		done := make(chan struct{})
+
+		fetcher := NewFetcher(1, 0, 5*time.Second, output, errors, fetch, done)
+		fetcher.UserAgents = configured
+
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go fetcher.StartFetching(&wg)
+
+		for i := 0; i < 4; i++ {
+			fetcher.NewRequest(fmt.Sprintf("%s/page%d", ts.URL, i))
+			select {
+			case resp := <-fetch:
+				resp.Body.Close()
+			case err := <-errors:
+				t.Fatalf("Failed to fetch the page: %v", err)
+			}
+		}
+		close(done)
+		wg.Wait()
+
+		expected := []string{"agent-one", "agent-two", "agent-three", "agent-one"}
+		if len(configured) == 1 {
+			expected = []string{"only-agent", "only-agent", "only-agent", "only-agent"}
+		}
+		mu.Lock()
+		if strings.Join(agents, ",") != strings.Join(expected, ",") {
+			t.Errorf("The user agents sent were %v, expected %v", agents, expected)
+		}
+		mu.Unlock()
+	}
+}
+
+// Spawn a test server that records the X-Crawl-Id of each request, including
+// the request for the target of a redirect, and test that every request is
+// sent with the run id and that no header is sent without one.
+func Test_RunID(t *testing.T) {
+	var mu sync.Mutex
+	var ids []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		ids = append(ids, r.Header.Get("X-Crawl-Id"))
+		mu.Unlock()
+		if r.URL.Path == "/old" {
+			http.Redirect(w, r, "/new", http.StatusMovedPermanently)
+			return
+		}
+		fmt.Fprint(w, "<html></html>")
+	}))
+	defer ts.Close()
+
+	for _, id := range []string{"nightly-42", ""} {
+		mu.Lock()
+		ids = nil
+		mu.Unlock()
+
+		output := make(chan string, 10)
+		errors := make(chan error)
+		fetch := make(chan *http.Response)
+		done := make(chan struct{})
+
+		fetcher := NewFetcher(1, 0, 5*time.Second, output, errors, fetch, done)
+		fetcher.RunID = id
+
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go fetcher.StartFetching(&wg)
+
+		for _, path := range []string{"/page", "/old"} {
+			fetcher.NewRequest(ts.URL + path)
+			select {
+			case resp := <-fetch:
+				resp.Body.Close()
+			case err := <-errors:
+				t.Fatalf("Failed to fetch the page: %v", err)
+			}
+		}
+		if msg := <-output; msg != fmt.Sprintf("redirect,%s/old,%s/new,301", ts.URL, ts.URL) {
+			t.Errorf("Unexpected redirect record %s", msg)
+		}
+		close(done)
+		wg.Wait()
+
+		mu.Lock()
+		if strings.Join(ids, ",") != strings.Join([]string{id, id, id}, ",") {
+			t.Errorf("The crawl ids sent were %q, expected %q on every request", ids, id)
+		}
+		mu.Unlock()
+	}
+}
+
+// Spawn a test server with a robots.txt that disallows some paths for every
+// user-agent and others for a named one, load it and request a mix of paths.
+// Test that only the paths disallowed for * are skipped, without reaching the
+// server, and that they are recorded as disallowed and answered with a nil
+// response.
+func Test_Robots(t *testing.T) {
+	var mu sync.Mutex
+	var paths []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		paths = append(paths, r.URL.RequestURI())
+		mu.Unlock()
+		if r.URL.Path == "/robots.txt" {
+			fmt.Fprint(w, "User-agent: otherbot\nDisallow: /bots\n\n"+
+				"User-agent: examplebot\nUser-agent: *\n# keep out\nDisallow: /private\nDisallow: /search?q=\nDisallow:\n")
+			return
+		}
+		fmt.Fprint(w, "<html></html>")
+	}))
+	defer ts.Close()
+
+	output := make(chan string, 10)
+	errs := make(chan error, 10)
+	fetch := make(chan *http.Response)
+	done := make(chan struct{})
+
+	fetcher := NewFetcher(1, 0, 5*time.Second, output, errs, fetch, done)
+	fetcher.ReportSkipped = true
+	seed, _ := url.Parse(ts.URL)
+	fetcher.LoadRobots(seed)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go fetcher.StartFetching(&wg)
+
+	for _, path := range []string{"/private/page", "/bots", "/search?q=links", "/search", "/"} {
+		fetcher.NewRequest(ts.URL + path)
+		if path == "/private/page" || path == "/search?q=links" {
+			for _, record := range []string{"robots,%s%s,disallowed", "skipped,%s%s,robots"} {
+				if got := <-output; got != fmt.Sprintf(record, ts.URL, path) {
+					t.Errorf("Unexpected record [%s] for the disallowed %s", got, path)
+				}
+			}
+			if resp := <-fetch; resp != nil {
+				t.Errorf("Expected the disallowed %s to be answered with a nil response", path)
+			}
+			continue
+		}
+		select {
+		case resp := <-fetch:
+			resp.Body.Close()
+		case err := <-errs:
+			t.Fatalf("Failed to fetch %s: %v", path, err)
+		}
+	}
+	close(done)
+	wg.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if strings.Join(paths, ",") != "/robots.txt,/bots,/search,/" {
+		t.Errorf("Unexpected requests %v", paths)
+	}
+
+	// Without a robots.txt every path is allowed
+	missing := httptest.NewServer(http.NotFoundHandler())
+	defer missing.Close()
+	seed, _ = url.Parse(missing.URL)
+	fetcher = NewFetcher(1, 0, 5*time.Second, output, errs, fetch, done)
+	fetcher.LoadRobots(seed)
+	if fetcher.robots != nil {
+		t.Errorf("Expected no rules without a robots.txt, got %v", fetcher.robots.disallow)
+	}
+}
+
+// Set a request filter that vetoes one URL and adds a header to the rest,
+// test that the vetoed URL is reported as filtered and skipped, is answered
+// with a nil response and never reaches the server while the other request
+// is sent with the added header.
+func Test_RequestFilter(t *testing.T) {
+	var mu sync.Mutex
+	var paths []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		paths = append(paths, r.URL.Path)
+		mu.Unlock()
+		if r.Header.Get("X-Filtered") != "allowed" {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+		fmt.Fprint(w, "<html></html>")
+	}))
+	defer ts.Close()
+
+	output := make(chan string)
+	errors := make(chan error)
+	fetch := make(chan *http.Response)
+	done := make(chan struct{})
+
+	fetcher := NewFetcher(1, 0, 5*time.Second, output, errors, fetch, done)
+	fetcher.ReportSkipped = true
+	fetcher.RequestFilter = func(req *http.Request) bool {
+		if req.URL.Path == "/private" {
+			return false
+		}
+		req.Header.Set("X-Filtered", "allowed")
+		return true
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go fetcher.StartFetching(&wg)
+
+	fetcher.NewRequest(ts.URL + "/private")
+	select {
+	case msg := <-output:
+		if msg != "filtered,"+ts.URL+"/private" {
+			t.Errorf("Unexpected output for the vetoed request: %s", msg)
+		}
+		if msg := <-output; msg != "skipped,"+ts.URL+"/private,filtered" {
+			t.Errorf("Unexpected skipped record for the vetoed request: %s", msg)
+		}
+		if resp := <-fetch; resp != nil {
+			t.Error("Expected the vetoed request to be answered with a nil response")
+		}
+	case resp := <-fetch:
+		resp.Body.Close()
+		t.Error("The vetoed request should not be fetched")
+	case err := <-errors:
+		t.Errorf("Unexpected error for the vetoed request: %v", err)
+	}
+
+	fetcher.NewRequest(ts.URL + "/public")
+	select {
+	case resp := <-fetch:
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("The filter did not add the header to the request, the status was %d", resp.StatusCode)
+		}
+	case err := <-errors:
+		t.Errorf("Failed to fetch the allowed request: %v", err)
+	}
+
+	close(done)
+	wg.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if strings.Join(paths, ",") != "/public" {
+		t.Errorf("The server received requests for %v, expected only /public", paths)
+	}
+}
+
+// Spawn a TLS test server that records the server name from the handshake
+// and the Host header, connect to it by its IP address and test that the
+// overridden SNI and Host are used while the certificate is still verified.
+func Test_HostOverrideSNI(t *testing.T) {
+	var mu sync.Mutex
+	var serverName, host string
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		host = r.Host
+		mu.Unlock()
+		fmt.Fprint(w, "<html></html>")
+	}))
+	ts.TLS = &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			mu.Lock()
+			serverName = hello.ServerName
+			mu.Unlock()
+			return nil, nil
+		},
+	}
+	ts.StartTLS()
+	defer ts.Close()
+
+	output := make(chan string)
+	errors := make(chan error)
+	fetch := make(chan *http.Response)
+	done := make(chan struct{})
+
+	fetcher := NewFetcher(1, 0, 5*time.Second, output, errors, fetch, done)
+	fetcher.HostOverride = "www.example.com"
+	fetcher.SetServerName("example.com") // The name in the test server's certificate
+	roots := x509.NewCertPool()
+	roots.AddCert(ts.Certificate())
+	fetcher.transport.TLSClientConfig.RootCAs = roots
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go fetcher.StartFetching(&wg)
+
+	fetcher.NewRequest(ts.URL)
+	select {
+	case resp := <-fetch:
+		resp.Body.Close()
+	case err := <-errors:
+		t.Errorf("Failed to fetch from the TLS server: %v", err)
+	}
+	close(done)
+	wg.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if serverName != "example.com" {
+		t.Errorf("The TLS server name was [%s], expected [example.com]", serverName)
+	}
+	if host != "www.example.com" {
+		t.Errorf("The Host header was [%s], expected [www.example.com]", host)
+	}
+}
+
+// Bind the fetcher to the loopback address and test that the dialer is
+// configured with it and the test server sees the request come from it,
+// an address that is not an IP or is not on an interface is rejected.
+func Test_BindAddress(t *testing.T) {
+	var mu sync.Mutex
+	var remote string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		remote = r.RemoteAddr
+		mu.Unlock()
+		fmt.Fprint(w, "<html></html>")
+	}))
+	defer ts.Close()
+
+	output := make(chan string)
+	errs := make(chan error)
+	fetch := make(chan *http.Response)
+	done := make(chan struct{})
+
+	fetcher := NewFetcher(1, 0, 5*time.Second, output, errs, fetch, done)
+	if err := fetcher.SetLocalAddr("127.0.0.1"); err != nil {
+		t.Fatalf("Failed to bind to the loopback address: %v", err)
+	}
+	local, ok := fetcher.dialer.LocalAddr.(*net.TCPAddr)
+	if !ok || !local.IP.Equal(net.ParseIP("127.0.0.1")) {
+		t.Fatalf("The dialer has the local address %v, expected 127.0.0.1", fetcher.dialer.LocalAddr)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go fetcher.StartFetching(&wg)
+
+	fetcher.NewRequest(ts.URL)
+	select {
+	case resp := <-fetch:
+		resp.Body.Close()
+	case err := <-errs:
+		t.Errorf("Failed to fetch from the bound address: %v", err)
+	}
+	close(done)
+	wg.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if host, _, _ := net.SplitHostPort(remote); host != "127.0.0.1" {
+		t.Errorf("The request came from %s, expected 127.0.0.1", remote)
+	}
+
+	for _, address := range []string{"localhost", "not-an-ip", "192.0.2.1"} {
+		if err := NewFetcher(1, 0, time.Second, nil, nil, nil, nil).SetLocalAddr(address); err == nil {
+			t.Errorf("Expected an error binding to %s", address)
+		}
+	}
+}
+
+// Download two large bodies at the same time and test that the combined
+// rate they are read at stays near the configured bandwidth cap.
+func Test_MaxBandwidth(t *testing.T) {
+	body := strings.Repeat("a", 64*1024)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, body)
+	}))
+	defer ts.Close()
+
+	output := make(chan string)
+	errors := make(chan error)
+	fetch := make(chan *http.Response)
+	done := make(chan struct{})
+	defer close(done)
+
+	fetcher := NewFetcher(2, 0, 5*time.Second, output, errors, fetch, done)
+	fetcher.MaxBandwidth = 256 * 1024
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go fetcher.StartFetching(&wg)
+
+	requests := 2
+	start := time.Now()
+	go func() {
+		for i := 0; i < requests; i++ {
+			fetcher.NewRequest(ts.URL)
+		}
+	}()
+
+	var reads sync.WaitGroup
+	var mu sync.Mutex
+	total := 0
+	for i := 0; i < requests; i++ {
+		resp := <-fetch
+		reads.Add(1)
+		go func() {
+			defer reads.Done()
+			defer resp.Body.Close()
+			b, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Errorf("Failed to read the body: %v", err)
+			}
+			mu.Lock()
+			total += len(b)
+			mu.Unlock()
+		}()
+	}
+	reads.Wait()
+
+	if total != requests*len(body) {
+		t.Fatalf("Read %d bytes, expected %d", total, requests*len(body))
+	}
+	rate := float64(total) / time.Since(start).Seconds()
+	limit := float64(fetcher.MaxBandwidth)
+	if rate > limit*1.1 || rate < limit*0.5 {
+		t.Errorf("The bodies were read at %.0f bytes/sec, expected close to %.0f", rate, limit)
+	}
+}
+
+// Record credentials for the test server's host and test that they are sent
+// as basic auth, while a request to another host is sent without them.
+func Test_Credentials(t *testing.T) {
+	type auth struct {
+		user, password string
+		ok             bool
+	}
+	received := make(chan auth, 2)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, ok := r.BasicAuth()
+		received <- auth{user, password, ok}
+		fmt.Fprint(w, "<html></html>")
+	}))
+	defer ts.Close()
+
+	output := make(chan string)
+	errors := make(chan error)
+	fetch := make(chan *http.Response)
+	done := make(chan struct{})
+	defer close(done)
+
+	parsed, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatalf("Failed to parse the test server URL: %v", err)
+	}
+	fetcher := NewFetcher(1, 0, 5*time.Second, output, errors, fetch, done)
+	fetcher.Credentials = data.NewCredentials()
+	fetcher.Credentials.Add(parsed.Host, url.UserPassword("user", "pass"))
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go fetcher.StartFetching(&wg)
+
+	// localhost reaches the same server under a host without credentials
+	other := strings.Replace(ts.URL, "127.0.0.1", "localhost", 1)
+	for _, target := range []string{ts.URL, other} {
+		fetcher.NewRequest(target)
+		select {
+		case resp := <-fetch:
+			resp.Body.Close()
+		case err := <-errors:
+			t.Fatalf("Failed to fetch %s: %v", target, err)
+		}
+	}
+
+	if got := <-received; !got.ok || got.user != "user" || got.password != "pass" {
+		t.Errorf("Expected basic auth user:pass, got %+v", got)
+	}
+	if got := <-received; got.ok {
+		t.Errorf("Expected no credentials for the other host, got %+v", got)
+	}
+}
+
+// roundTripFunc lets a function stand in for the client's transport
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// Fail the first two attempts of a request with a transport stub and test
+// that a connection reset pauses for the ResetDelay before each retry while
+// any other error pauses for the RetryDelay.
+func Test_ConnectionResetBackoff(t *testing.T) {
+	reset := &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}
+	other := errors.New("unexpected EOF")
+
+	for _, failure := range []error{reset, other} {
+		output := make(chan string)
+		errs := make(chan error)
+		fetch := make(chan *http.Response)
+		done := make(chan struct{})
+
+		clock := &fakeClock{now: time.Now()}
+		fetcher := NewFetcher(1, 3, 5*time.Second, output, errs, fetch, done)
+		fetcher.Clock = clock
+
+		attempts := 0
+		fetcher.Client = &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			attempts++
+			if attempts <= 2 {
+				return nil, failure
+			}
+			return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader("")), Request: req}, nil
+		})}
+
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go fetcher.StartFetching(&wg)
+		go fetcher.NewRequest("http://example.com")
+
+		for received := false; !received; {
+			select {
+			case <-errs:
+			case resp := <-fetch:
+				resp.Body.Close()
+				received = true
+			case <-time.After(5 * time.Second):
+				t.Fatal("Timed out waiting for the response")
+			}
+		}
+		close(done)
+		wg.Wait()
+
+		expected := fetcher.RetryDelay
+		if failure == reset {
+			expected = fetcher.ResetDelay
+		}
+		clock.mu.Lock()
+		if len(clock.sleeps) != 2 || clock.sleeps[0] != expected || clock.sleeps[1] != expected {
+			t.Errorf("Paused for %v after the [%v] errors, expected two pauses of %v", clock.sleeps, failure, expected)
+		}
+		clock.mu.Unlock()
+	}
+}
+
+// Make a server fail with 500s until it is told to recover. Test that the
+// breaker opens after the threshold so no further requests reach the server,
+// that a trial request is allowed once the cooldown has passed on the clock
+// and that the circuit closes again when the trial succeeds.
+func Test_CircuitBreaker(t *testing.T) {
+	var mu sync.Mutex
+	hits := 0
+	healthy := false
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		hits++
+		if !healthy {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer ts.Close()
+
+	output := make(chan string)
+	errs := make(chan error)
+	fetch := make(chan *http.Response)
+	done := make(chan struct{})
+	defer close(done)
+
+	clock := &fakeClock{now: time.Now()}
+	fetcher := NewFetcher(1, 0, 5*time.Second, output, errs, fetch, done)
+	fetcher.Clock = clock
+	fetcher.BreakerThreshold = 2
+	fetcher.BreakerCooldown = time.Minute
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go fetcher.StartFetching(&wg)
+
+	// request returns the status of the response, or 0 when the request is
+	// refused by the breaker and answered with a nil response
+	request := func() int {
+		go fetcher.NewRequest(ts.URL)
+		select {
+		case resp := <-fetch:
+			resp.Body.Close()
+			return resp.StatusCode
+		case err := <-errs:
+			if !strings.Contains(err.Error(), "Circuit breaker open") {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if resp := <-fetch; resp != nil {
+				t.Error("Expected the refused request to be answered with a nil response")
+			}
+			return 0
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for the request")
+		}
+		return 0
+	}
+
+	for i := 0; i < 2; i++ {
+		if status := request(); status != http.StatusInternalServerError {
+			t.Fatalf("Expected a 500 before the breaker opens, got %d", status)
+		}
+	}
+	if status := request(); status != 0 {
+		t.Errorf("Expected the open breaker to refuse the request, got %d", status)
+	}
+	mu.Lock()
+	if hits != 2 {
+		t.Errorf("The server was hit %d times, expected 2", hits)
+	}
+	healthy = true
+	mu.Unlock()
+
+	clock.Sleep(time.Minute)
+	if status := request(); status != http.StatusOK {
+		t.Errorf("Expected the trial request to succeed after the cooldown, got %d", status)
+	}
+	if status := request(); status != http.StatusOK {
+		t.Errorf("Expected the circuit to close after the trial, got %d", status)
+	}
+}
+
+// Spawn a test server that drops every connection and record when each
+// attempt arrives, test that the gaps between the retries grow with
+// exponential backoff. The pauses double from the base up to the cap and
+// have up to a tenth of the pause added as jitter.
+func Test_Backoff(t *testing.T) {
+	var mu sync.Mutex
+	var attempts []time.Time
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		attempts = append(attempts, time.Now())
+		mu.Unlock()
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err == nil {
+			conn.Close()
+		}
+	}))
+	defer ts.Close()
+
+	output := make(chan string)
+	errors := make(chan error)
+	fetch := make(chan *http.Response)
+	done := make(chan struct{})
+	defer close(done)
+
+	fetcher := NewFetcher(1, 3, 5*time.Second, output, errors, fetch, done)
+	fetcher.BackoffBase = 40 * time.Millisecond
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go fetcher.StartFetching(&wg)
+
+	go fetcher.NewRequest(ts.URL)
+	for i := 0; i <= fetcher.RetryCount; i++ {
+		select {
+		case <-errors:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for the fetch errors")
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(attempts) != fetcher.RetryCount+1 {
+		t.Fatalf("Expected %d attempts, got %d", fetcher.RetryCount+1, len(attempts))
+	}
+	for i := 2; i < len(attempts); i++ {
+		previous, gap := attempts[i-1].Sub(attempts[i-2]), attempts[i].Sub(attempts[i-1])
+		if gap <= previous {
+			t.Errorf("The gap before attempt %d was %v, expected it to be longer than the %v before it", i+1, gap, previous)
+		}
+	}
+
+	fetcher.BackoffBase = time.Second
+	fetcher.BackoffMax = 3 * time.Second
+	for attempt, expected := range []time.Duration{time.Second, 2 * time.Second, 3 * time.Second, 3 * time.Second} {
+		if delay := fetcher.retryDelay(nil, attempt); delay < expected || delay > expected+expected/10 {
+			t.Errorf("The delay after attempt %d was %v, expected %v plus up to a tenth as jitter", attempt, delay, expected)
+		}
+	}
+
+	fetcher.BackoffBase = 0
+	if delay := fetcher.retryDelay(nil, 3); delay != fetcher.RetryDelay {
+		t.Errorf("The delay without backoff was %v, expected the flat %v", delay, fetcher.RetryDelay)
+	}
+}
+
+// Parse the Retry-After header in its delta-seconds and HTTP-date forms and
+// test the wait, a date in the past is no wait and other values do not parse.
+func Test_parseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		header string
+		wait   time.Duration
+		ok     bool
+	}{
+		{"120", 2 * time.Minute, true},
+		{" 0 ", 0, true},
+		{"Wed, 01 May 2024 12:00:30 GMT", 30 * time.Second, true},
+		{"Wednesday, 01-May-24 12:01:00 GMT", time.Minute, true},
+		{"Wed, 01 May 2024 11:59:00 GMT", 0, true},
+		{"", 0, false},
+		{"-5", 0, false},
+		{"soon", 0, false},
+		{"99999999999999999999", 0, false},
+	}
+	for _, tc := range testCases {
+		if wait, ok := parseRetryAfter(tc.header, now); wait != tc.wait || ok != tc.ok {
+			t.Errorf("Retry-After [%s] parsed as %v and %v, expected %v and %v", tc.header, wait, ok, tc.wait, tc.ok)
+		}
+	}
+}
+
+// Spawn a test server that rate limits the first request of each page with a
+// Retry-After header, in the seconds and date forms, and test that the
+// fetcher waits for it on its clock before the retry that is delivered.
+func Test_RetryAfter(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	var mu sync.Mutex
+	requested := map[string]int{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requested[r.URL.Path]++
+		first := requested[r.URL.Path] == 1
+		mu.Unlock()
+		if !first {
+			fmt.Fprint(w, "<html></html>")
+			return
+		}
+		switch r.URL.Path {
+		case "/seconds":
+			w.Header().Set("Retry-After", "7")
+			w.WriteHeader(http.StatusTooManyRequests)
+		case "/date":
+			w.Header().Set("Retry-After", clock.Now().Add(20*time.Second).UTC().Format(http.TimeFormat))
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer ts.Close()
+
+	output := make(chan string, 10)
+	errors := make(chan error)
+	fetch := make(chan *http.Response)
+	done := make(chan struct{})
+	defer close(done)
+
+	fetcher := NewFetcher(1, 3, 5*time.Second, output, errors, fetch, done)
+	fetcher.Clock = clock
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go fetcher.StartFetching(&wg)
+
+	for _, path := range []string{"/seconds", "/date"} {
+		clock.mu.Lock()
+		clock.sleeps = nil
+		clock.mu.Unlock()
+
+		go fetcher.NewRequest(ts.URL + path)
+		select {
+		case resp := <-fetch:
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("Expected the retry of %s to be delivered, got %d", path, resp.StatusCode)
+			}
+		case err := <-errors:
+			t.Fatalf("Failed to fetch %s: %v", path, err)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for %s", path)
+		}
+
+		clock.mu.Lock()
+		sleeps := clock.sleeps
+		clock.mu.Unlock()
+		if len(sleeps) != 1 {
+			t.Fatalf("Expected one pause before retrying %s, got %v", path, sleeps)
+		}
+		switch path {
+		case "/seconds":
+			if sleeps[0] != 7*time.Second {
+				t.Errorf("Waited %v before retrying %s, expected 7s", sleeps[0], path)
+			}
+		case "/date":
+			if sleeps[0] <= 18*time.Second || sleeps[0] > 20*time.Second {
+				t.Errorf("Waited %v before retrying %s, expected about 20s", sleeps[0], path)
+			}
+		}
+		if record := <-output; !strings.HasPrefix(record, "retry-after,"+ts.URL+path+",") {
+			t.Errorf("Unexpected record for the rate limited %s: %s", path, record)
+		}
+	}
+}
+
+// Spawn a test server that asks for an hour long Retry-After and test that
+// the wait is capped at the MaxRetryAfter, and that with the real clock the
+// wait is given up once the fetcher is shut down.
+func Test_MaxRetryAfter(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "3600")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer ts.Close()
+
+	clock := &fakeClock{now: time.Now()}
+	fetcher := NewFetcher(1, 1, 5*time.Second, nil, nil, nil, nil)
+	fetcher.Clock = clock
+	fetcher.MaxRetryAfter = time.Minute
+	res, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("Failed to get the page from the httptest server: %v", err)
+	}
+	res.Body.Close()
+	if wait, ok := fetcher.retryAfter(res, 0); !ok || wait != time.Minute {
+		t.Errorf("Expected the Retry-After to be capped at 1m, got %v and %v", wait, ok)
+	}
+
+	output := make(chan string, 10)
+	fetch := make(chan *http.Response)
+	done := make(chan struct{})
+	fetcher = NewFetcher(1, 1, 5*time.Second, output, nil, fetch, done)
+	fetcher.MaxRetryAfter = 0
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go fetcher.StartFetching(&wg)
+	go fetcher.NewRequest(ts.URL)
+	if record := <-output; !strings.HasPrefix(record, "retry-after,"+ts.URL+",429,1h0m0s") {
+		t.Fatalf("Unexpected record for the rate limited page: %s", record)
+	}
+
+	close(done)
+	stopped := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("The fetcher kept waiting for the Retry-After once it was shut down")
+	}
+}
+
+// Spawn a test server that records when each request arrives and test that
+// two requests to it are at least the CrawlDelay apart, with a Crawl-delay in
+// its robots.txt taking the place of the CrawlDelay.
+func Test_CrawlDelay(t *testing.T) {
+	var mu sync.Mutex
+	var arrived []time.Time
+	robots := ""
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			fmt.Fprint(w, robots)
+			return
+		}
+		mu.Lock()
+		arrived = append(arrived, time.Now())
+		mu.Unlock()
+		fmt.Fprint(w, "<html></html>")
+	}))
+	defer ts.Close()
+	seed, _ := url.Parse(ts.URL)
+
+	testCases := []struct {
+		robots   string
+		expected time.Duration
+	}{
+		{"", 150 * time.Millisecond},
+		{"User-agent: otherbot\nCrawl-delay: 5\n\nUser-agent: *\nCrawl-delay: 0.3\n", 300 * time.Millisecond},
+	}
+	for _, tc := range testCases {
+		mu.Lock()
+		arrived, robots = nil, tc.robots
+		mu.Unlock()
+
+		output := make(chan string, 10)
+		errs := make(chan error, 10)
+		fetch := make(chan *http.Response)
+		done := make(chan struct{})
+
+		fetcher := NewFetcher(2, 0, 5*time.Second, output, errs, fetch, done)
+		fetcher.CrawlDelay = 150 * time.Millisecond
+		fetcher.LoadRobots(seed)
+
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go fetcher.StartFetching(&wg)
+
+		go fetcher.NewRequest(ts.URL + "/one")
+		go fetcher.NewRequest(ts.URL + "/two")
+		for i := 0; i < 2; i++ {
+			select {
+			case resp := <-fetch:
+				resp.Body.Close()
+			case err := <-errs:
+				t.Fatalf("Failed to fetch the page: %v", err)
+			}
+		}
+		close(done)
+		wg.Wait()
+
+		mu.Lock()
+		if len(arrived) != 2 {
+			t.Fatalf("Expected 2 requests, got %d", len(arrived))
+		}
+		// The first request also dials the connection, which the second
+		// can reuse, so a little of the delay is taken by the dial
+		if gap := arrived[1].Sub(arrived[0]); gap < tc.expected-10*time.Millisecond {
+			t.Errorf("The requests were %v apart, expected at least %v", gap, tc.expected)
+		}
+		mu.Unlock()
+	}
+
+	if delay := ParseRobots("example.com", strings.NewReader("User-agent: *\nCrawl-delay: soon\n")).crawlDelay; delay != 0 {
+		t.Errorf("Expected a Crawl-delay that does not parse to be ignored, got %v", delay)
+	}
+}
+
+// Seed the random package twice with the same value and test that the
+// retry delays have the same jitter, within the configured RetryJitter.
+func Test_RetryJitterSeed(t *testing.T) {
+	fetcher := NewFetcher(1, 3, 5*time.Second, nil, nil, nil, nil)
+	fetcher.RetryJitter = 500 * time.Millisecond
+	failure := errors.New("unexpected EOF")
+
+	delays := func(seed int64) []time.Duration {
+		random.Seed(seed)
+		var sequence []time.Duration
+		for i := 0; i < 5; i++ {
+			sequence = append(sequence, fetcher.retryDelay(failure, i))
+		}
+		return sequence
+	}
+
+	first, second := delays(42), delays(42)
+	if fmt.Sprint(first) != fmt.Sprint(second) {
+		t.Errorf("The delays for the same seed differ: %v and %v", first, second)
+	}
+	for _, d := range first {
+		if d < fetcher.RetryDelay || d >= fetcher.RetryDelay+fetcher.RetryJitter {
+			t.Errorf("The delay %v is outside of the retry delay plus jitter", d)
+		}
+	}
+}
+
+// Make a server fail every request and test that its host is reported as
+// abandoned after the maximum number of errors, its later requests are
+// skipped without reaching the server and answered with a nil response, and
+// other hosts are still fetched.
+func Test_MaxErrorsPerHost(t *testing.T) {
+	var mu sync.Mutex
+	hits := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		hits++
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	output := make(chan string)
+	errs := make(chan error)
+	fetch := make(chan *http.Response)
+	done := make(chan struct{})
+	defer close(done)
+
+	fetcher := NewFetcher(1, 0, 5*time.Second, output, errs, fetch, done)
+	fetcher.MaxErrorsPerHost = 3
+	fetcher.ReportSkipped = true
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go fetcher.StartFetching(&wg)
+
+	host := strings.TrimPrefix(ts.URL, "http://")
+	for i := 0; i < 3; i++ {
+		go fetcher.NewRequest(ts.URL)
+		resp := <-fetch
+		resp.Body.Close()
+	}
+	if record := <-output; record != fmt.Sprintf("abandoned,%s,3", host) {
+		t.Errorf("Unexpected record [%s], expected the host to be abandoned", record)
+	}
+
+	go fetcher.NewRequest(ts.URL + "/later")
+	if record := <-output; record != fmt.Sprintf("skipped,%s/later,host-abandoned", ts.URL) {
+		t.Errorf("Unexpected record [%s], expected the request to be skipped", record)
+	}
+	if resp := <-fetch; resp != nil {
+		t.Error("Expected the skipped request to be answered with a nil response")
+	}
+
+	// localhost reaches the same server under a host that has not failed
+	go fetcher.NewRequest(strings.Replace(ts.URL, "127.0.0.1", "localhost", 1))
+	resp := <-fetch
+	resp.Body.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if hits != 4 {
+		t.Errorf("The server was hit %d times, expected 4", hits)
+	}
+}
+
+// Serve 64KB bodies with a byte budget of 100KB, test that the budget is
+// spent while reading the second body, the crawl is asked to stop once, and
+// the requests after it are skipped without reaching the server and are
+// answered with a nil response.
+func Test_MaxBytes(t *testing.T) {
+	var mu sync.Mutex
+	hits := 0
+	body := strings.Repeat("x", 64*1024)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hits++
+		mu.Unlock()
+		fmt.Fprint(w, body)
+	}))
+	defer ts.Close()
+
+	output := make(chan string, 10)
+	errs := make(chan error)
+	fetch := make(chan *http.Response)
+	done := make(chan struct{})
+	defer close(done)
+
+	stops := 0
+	fetcher := NewFetcher(1, 0, 5*time.Second, output, errs, fetch, done)
+	fetcher.MaxBytes = 100 * 1024
+	fetcher.ReportSkipped = true
+	fetcher.OnMaxBytes = func() { stops++ }
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go fetcher.StartFetching(&wg)
+
+	for i := 0; i < 2; i++ {
+		go fetcher.NewRequest(fmt.Sprintf("%s/%d", ts.URL, i))
+		resp := <-fetch
+		io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if i == 0 && len(output) != 0 {
+			t.Errorf("The budget should not be spent by the first body, got %s", <-output)
+		}
+	}
+	var max, read int64
+	if record := <-output; !strings.HasPrefix(record, "max-bytes,") {
+		t.Errorf("Unexpected record [%s], expected the byte budget to be spent", record)
+	} else if fmt.Sscanf(record, "max-bytes,%d,%d", &max, &read); max != 100*1024 || read <= max || read > 128*1024 {
+		t.Errorf("Unexpected record [%s], expected more than the 102400 byte budget to have been read", record)
+	}
+	if stops != 1 {
+		t.Errorf("OnMaxBytes was called %d times, expected once", stops)
+	}
+
+	for i := 2; i < 4; i++ {
+		go fetcher.NewRequest(fmt.Sprintf("%s/%d", ts.URL, i))
+		if record := <-output; record != fmt.Sprintf("skipped,%s/%d,max-bytes", ts.URL, i) {
+			t.Errorf("Unexpected record [%s], expected the request to be skipped", record)
+		}
+		if resp := <-fetch; resp != nil {
+			t.Errorf("Expected the skipped request to be answered with a nil response")
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if hits != 2 {
+		t.Errorf("The server was hit %d times, expected 2", hits)
+	}
+}
+
+// Spawn a test server that has a favicon and a security.txt behind a
+// redirect, probe it and test that a request is issued for every path and
+// the status of each is recorded.
+func Test_Probe(t *testing.T) {
+	var mu sync.Mutex
+	var requested []string
+	var agents []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requested = append(requested, r.URL.Path)
+		agents = append(agents, r.UserAgent())
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/favicon.ico", "/.well-known/security.txt":
+			fmt.Fprint(w, "present")
+		case "/security.txt":
+			http.Redirect(w, r, "/.well-known/security.txt", http.StatusMovedPermanently)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	output := make(chan string, 10)
+	errs := make(chan error, 10)
+	fetcher := NewFetcher(1, 0, 5*time.Second, output, errs, nil, nil)
+	fetcher.UserAgents = []string{"probe-agent"}
+
+	seed, _ := url.Parse(ts.URL + "/docs/intro")
+	var wg sync.WaitGroup
+	wg.Add(1)
+	fetcher.Probe(seed, []string{"/favicon.ico", "humans.txt", "/security.txt"}, &wg)
+	close(output)
+
+	var records []string
+	for record := range output {
+		records = append(records, record)
+	}
+	expected := []string{
+		fmt.Sprintf("probe,%s/favicon.ico,200", ts.URL),
+		fmt.Sprintf("probe,%s/humans.txt,404", ts.URL),
+		fmt.Sprintf("probe,%s/security.txt,200", ts.URL),
+	}
+	if strings.Join(records, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("The probes were recorded as %v, expected %v", records, expected)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if strings.Join(requested, ",") != "/favicon.ico,/humans.txt,/security.txt,/.well-known/security.txt" {
+		t.Errorf("Unexpected probe requests %v", requested)
+	}
+	for _, agent := range agents {
+		if agent != "probe-agent" {
+			t.Errorf("The probe was sent with the User-Agent [%s], expected [probe-agent]", agent)
+		}
+	}
+
+	output = make(chan string, 1)
+	fetcher = NewFetcher(1, 0, time.Second, output, errs, nil, nil)
+	closed, _ := url.Parse("http://127.0.0.1:1")
+	wg.Add(1)
+	fetcher.Probe(closed, []string{"/favicon.ico"}, &wg)
+	if record := <-output; record != "probe,http://127.0.0.1:1/favicon.ico,0" || len(errs) != 1 {
+		t.Errorf("Expected the failed probe to be recorded as 0 with an error, got %s and %d errors", record, len(errs))
+	}
+}
+
+// Spawn a test server and probe it while the only in-flight slot is taken,
+// test that no probe is sent until the slot is released and that the probes
+// are spaced out by the CrawlDelay on the fetcher's clock.
+func Test_ProbeLimits(t *testing.T) {
+	var hits atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		fmt.Fprint(w, "present")
+	}))
+	defer ts.Close()
+
+	output := make(chan string, 10)
+	errs := make(chan error, 10)
+	fetcher := NewFetcher(1, 0, 5*time.Second, output, errs, nil, nil)
+	clock := &fakeClock{now: time.Now()}
+	fetcher.Clock = clock
+	fetcher.CrawlDelay = 150 * time.Millisecond
+	fetcher.inflight.Resize(1)
+	fetcher.acquire()
+
+	seed, _ := url.Parse(ts.URL)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go fetcher.Probe(seed, []string{"/favicon.ico", "/humans.txt", "/robots.txt"}, &wg)
+	time.Sleep(100 * time.Millisecond)
+	if hits.Load() != 0 {
+		t.Errorf("The probe was sent while the in-flight slot was taken")
+	}
+	fetcher.release()
+	wg.Wait()
+
+	if hits.Load() != 3 || len(output) != 3 {
+		t.Errorf("Expected 3 probes once the slot was released, got %d requests and %d records", hits.Load(), len(output))
+	}
+	clock.mu.Lock()
+	defer clock.mu.Unlock()
+	if len(clock.sleeps) != 2 || clock.sleeps[0] != fetcher.CrawlDelay || clock.sleeps[1] != fetcher.CrawlDelay {
+		t.Errorf("The probes slept %v, expected the CrawlDelay between each", clock.sleeps)
+	}
+}
+
+// Write the report of an earlier crawl with broken records for pages that
+// have since been fixed, moved and left broken, load it and re-check it
+// against a test server. Test that only the broken URLs are requested, once
+// each, and that they are reported as fixed or still broken.
+func Test_Recheck(t *testing.T) {
+	var mu sync.Mutex
+	var requested []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requested = append(requested, r.URL.Path)
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/fixed", "/new":
+			fmt.Fprint(w, "fixed")
+		case "/moved":
+			http.Redirect(w, r, "/new", http.StatusMovedPermanently)
+		case "/error":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	report := filepath.Join(t.TempDir(), "crawl.csv")
+	lines := []string{
+		fmt.Sprintf("data,200,%s,%s/fixed", ts.URL, ts.URL),
+		fmt.Sprintf("data,broken,404,%s/fixed", ts.URL),
+		fmt.Sprintf("data,broken,404,%s/missing", ts.URL),
+		fmt.Sprintf("broken,410,%s/moved", ts.URL),
+		fmt.Sprintf("data,broken,503,%s/error", ts.URL),
+		fmt.Sprintf("data,broken,404,%s/missing", ts.URL),
+		"error,Failed to fetch: connection refused",
+		`done,{"pages":4}`,
+	}
+	if err := os.WriteFile(report, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatalf("Failed to write the report: %v", err)
+	}
+	urls, err := LoadBroken(report)
+	if err != nil {
+		t.Fatalf("Failed to load the report: %v", err)
+	}
+
+	output := make(chan string, 10)
+	errs := make(chan error, 10)
+	fetcher := NewFetcher(1, 0, 5*time.Second, output, errs, nil, nil)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	fetcher.Recheck(urls, &wg)
+	close(output)
+
+	var records []string
+	for record := range output {
+		records = append(records, record)
+	}
+	expected := []string{
+		fmt.Sprintf("fixed,%s/fixed,200", ts.URL),
+		fmt.Sprintf("still-broken,%s/missing,404", ts.URL),
+		fmt.Sprintf("fixed,%s/moved,200", ts.URL),
+		fmt.Sprintf("still-broken,%s/error,500", ts.URL),
+	}
+	if strings.Join(records, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("The re-check was recorded as %v, expected %v", records, expected)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if strings.Join(requested, ",") != "/fixed,/missing,/moved,/new,/error" {
+		t.Errorf("Unexpected re-check requests %v", requested)
+	}
+}
+
+// Hold a number of requests at the server until the fetcher has been shut
+// down and then abort them. Test that the errors of all of the requests are
+// still received, none are dropped because Done has been closed.
+func Test_ErrorsAfterDone(t *testing.T) {
+	requests := 10
+	var arrived sync.WaitGroup
+	arrived.Add(requests)
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		arrived.Done()
+		<-release
+		panic(http.ErrAbortHandler)
+	}))
+	defer ts.Close()
+
+	output := make(chan string)
+	errs := make(chan error)
+	fetch := make(chan *http.Response)
+	done := make(chan struct{})
+
+	fetcher := NewFetcher(requests, 0, 5*time.Second, output, errs, fetch, done)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go fetcher.StartFetching(&wg)
+	for i := 0; i < requests; i++ {
+		fetcher.NewRequest(fmt.Sprintf("%s/page%d", ts.URL, i))
+	}
+	arrived.Wait()
+	close(done)
+	close(release)
+
+	for i := 0; i < requests; i++ {
+		select {
+		case <-errs:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Only %d of the %d errors were received after Done", i, requests)
+		}
+	}
+	wg.Wait()
+}
+
+// Test that the compressed encodings are only advertised with Compression
+func Test_Compression(t *testing.T) {
+	received := make(chan string, 2)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Get("Accept-Encoding")
+	}))
+	defer ts.Close()
+
+	for _, compression := range []bool{false, true} {
+		output := make(chan string)
+		errs := make(chan error)
+		fetch := make(chan *http.Response)
+		done := make(chan struct{})
+
+		fetcher := NewFetcher(1, 0, 5*time.Second, output, errs, fetch, done)
+		fetcher.Compression = compression
+
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go fetcher.StartFetching(&wg)
+		fetcher.NewRequest(ts.URL)
+		resp := <-fetch
+		resp.Body.Close()
+		close(done)
+		wg.Wait()
+
+		expected := "gzip"
+		if compression {
+			expected = "br, gzip, deflate"
+		}
+		if accepted := <-received; accepted != expected {
+			t.Errorf("With compression %v the Accept-Encoding was [%s], expected [%s]", compression, accepted, expected)
+		}
+	}
+}
+
+// Spawn a test server that responds with an empty body, then with a gzip
+// body that is empty once it is decompressed and then with the page. Test
+// that both empty bodies are retried with RetryEmptyBody and that the page
+// is passed on, while without it the first empty body is passed on.
+func Test_RetryEmptyBody(t *testing.T) {
+	for _, retry := range []bool{true, false} {
+		var requests atomic.Int32
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch requests.Add(1) {
+			case 1:
+			case 2:
+				w.Header().Set("Content-Encoding", "gzip")
+				gz := gzip.NewWriter(w)
+				gz.Close()
+			default:
+				fmt.Fprint(w, `<html><body>Page</body></html>`)
+			}
+		}))
+
+		output := make(chan string, 10)
+		errs := make(chan error, 10)
+		fetch := make(chan *http.Response)
+		done := make(chan struct{})
+
+		fetcher := NewFetcher(1, 3, 5*time.Second, output, errs, fetch, done)
+		fetcher.Clock = &fakeClock{now: time.Now()}
+		fetcher.Compression = true
+		fetcher.RetryEmptyBody = retry
+		fetcher.Decode = func(encoding string, body io.Reader) (io.Reader, error) {
+			if encoding == "gzip" {
+				return gzip.NewReader(body)
+			}
+			return body, nil
+		}
+
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go fetcher.StartFetching(&wg)
+		fetcher.NewRequest(ts.URL)
+		resp := <-fetch
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		close(done)
+		wg.Wait()
+		ts.Close()
+
+		if err != nil {
+			t.Fatalf("Failed to read the body: %v", err)
+		}
+		if retry && (string(body) != `<html><body>Page</body></html>` || requests.Load() != 3 || len(errs) != 2) {
+			t.Errorf("Expected the page after 2 retries, got [%s] after %d requests and %d errors", body, requests.Load(), len(errs))
+		}
+		if !retry && (len(body) != 0 || requests.Load() != 1) {
+			t.Errorf("Expected the empty body without RetryEmptyBody, got [%s] after %d requests", body, requests.Load())
+		}
+	}
+}
+
+// Spawn a test server that sends the start of a large page and then stalls,
+// and test that the check for an empty body only reads a prefix of it so the
+// response is delivered while the server is stalled, with the whole page
+// still read from its body once the server sends the rest.
+func Test_EmptyBodyPrefix(t *testing.T) {
+	release := make(chan struct{})
+	page := strings.Repeat("<p>content</p>", 1000)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, page[:4096])
+		w.(http.Flusher).Flush()
+		<-release
+		fmt.Fprint(w, page[4096:])
+	}))
+	defer ts.Close()
+
+	output := make(chan string, 10)
+	errs := make(chan error, 10)
+	fetch := make(chan *http.Response)
+	done := make(chan struct{})
+
+	fetcher := NewFetcher(1, 1, 5*time.Second, output, errs, fetch, done)
+	fetcher.RetryEmptyBody = true
+	fetcher.Decode = func(encoding string, body io.Reader) (io.Reader, error) { return body, nil }
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go fetcher.StartFetching(&wg)
+	fetcher.NewRequest(ts.URL)
+	select {
+	case resp := <-fetch:
+		close(release)
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil || string(body) != page {
+			t.Errorf("The body read after the check was %d bytes, expected %d: %v", len(body), len(page), err)
+		}
+	case <-time.After(2 * time.Second):
+		close(release)
+		t.Error("The response was not delivered until the whole body had been read")
+	}
+	close(done)
+	wg.Wait()
+	if len(errs) != 0 {
+		t.Errorf("Unexpected error checking the body: %v", <-errs)
+	}
+}
+
+// Drive the adaptive delay of a host with synthetic response times and test
+// that slow responses double the delay up to the max, that fast responses
+// reduce it by a step to the min, and that the requests are spaced out by it.
+func Test_AdaptiveDelay(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	adaptive := NewAdaptiveDelay(500*time.Millisecond, 50*time.Millisecond, time.Second, clock)
+
+	if delay := adaptive.Delay("example.com"); delay != 50*time.Millisecond {
+		t.Errorf("The delay started at %v, expected the min", delay)
+	}
+
+	var delays []time.Duration
+	for i := 0; i < 5; i++ {
+		adaptive.Observe("example.com", 2*time.Second)
+		delays = append(delays, adaptive.Delay("example.com"))
+	}
+	if fmt.Sprint(delays) != "[100ms 200ms 400ms 800ms 1s]" {
+		t.Errorf("The delays under slow responses were %v", delays)
+	}
+	if delay := adaptive.Delay("other.com"); delay != 50*time.Millisecond {
+		t.Errorf("The delay of another host was changed to %v", delay)
+	}
+
+	// The moving average has to come down below the target first
+	delays = delays[:0]
+	for i := 0; i < 16; i++ {
+		adaptive.Observe("example.com", 100*time.Millisecond)
+		delays = append(delays, adaptive.Delay("example.com"))
+	}
+	for i := 1; i < len(delays); i++ {
+		if delays[i] > delays[i-1] {
+			t.Errorf("The delay increased under fast responses: %v", delays)
+			break
+		}
+	}
+	if last := delays[len(delays)-1]; last != 50*time.Millisecond {
+		t.Errorf("The delay came down to %v, expected the min: %v", last, delays)
+	}
+
+	// Three requests at once are sent a delay apart
+	adaptive.Observe("slow.com", 2*time.Second)
+	for i := 0; i < 3; i++ {
+		adaptive.Wait("slow.com")
+	}
+	clock.mu.Lock()
+	defer clock.mu.Unlock()
+	if fmt.Sprint(clock.sleeps) != "[100ms 100ms]" {
+		t.Errorf("The requests waited for %v, expected [100ms 100ms]", clock.sleeps)
+	}
+}
+
+// Spawn a test server that returns 401 unless the request has the expected
+// basic auth or bearer token and test that the credentials are sent to the
+// seed's host, but not to another host or a redirect that leaves it.
+func Test_Auth(t *testing.T) {
+	other := make(chan string, 1)
+	offsite := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		other <- r.Header.Get("Authorization")
+		fmt.Fprint(w, "<html></html>")
+	}))
+	defer offsite.Close()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/away" {
+			http.Redirect(w, r, offsite.URL, http.StatusFound)
+			return
+		}
+		user, pass, ok := r.BasicAuth()
+		if r.Header.Get("Authorization") != "Bearer token" && (!ok || user != "staging" || pass != "secret") {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, "<html></html>")
+	}))
+	defer ts.Close()
+
+	testCases := []struct {
+		user     string
+		pass     string
+		bearer   string
+		expected int
+	}{
+		{"", "", "", http.StatusUnauthorized},
+		{"staging", "secret", "", http.StatusOK},
+		{"staging", "wrong", "", http.StatusUnauthorized},
+		{"", "", "token", http.StatusOK},
+		{"staging", "wrong", "token", http.StatusOK},
+		{"", "", "wrong", http.StatusUnauthorized},
+	}
+	for _, tc := range testCases {
+		output := make(chan string, 10)
+		errors := make(chan error)
+		fetch := make(chan *http.Response)
+		done := make(chan struct{})
+
+		fetcher := NewFetcher(1, 0, 5*time.Second, output, errors, fetch, done)
+		fetcher.BasicUser = tc.user
+		fetcher.BasicPass = tc.pass
+		fetcher.Bearer = tc.bearer
+		fetcher.AuthHost = strings.TrimPrefix(ts.URL, "http://")
+
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go fetcher.StartFetching(&wg)
+
+		for _, link := range []string{ts.URL, offsite.URL, ts.URL + "/away"} {
+			fetcher.NewRequest(link)
+			select {
+			case resp := <-fetch:
+				resp.Body.Close()
+				if link == ts.URL && resp.StatusCode != tc.expected {
+					t.Errorf("The request with the user [%s] and token [%s] returned %d, expected %d", tc.user, tc.bearer, resp.StatusCode, tc.expected)
+				}
+			case err := <-errors:
+				t.Fatalf("Failed to fetch the page %s: %v", link, err)
+			}
+			if link != ts.URL {
+				if auth := <-other; auth != "" {
+					t.Errorf("The credentials [%s] were sent to another host from %s", auth, link)
+				}
+			}
+		}
+		close(done)
+		wg.Wait()
+	}
+}
+
+// Spawn a stub HTTP proxy and a stub SOCKS5 proxy that answer every request
+// themselves and test that a page on a host that does not resolve is fetched
+// through the proxy that is set, and that an unsupported proxy is an error.
+func Test_Proxy(t *testing.T) {
+	proxied := make(chan string, 1)
+	httpProxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied <- r.URL.String()
+		fmt.Fprint(w, "<html></html>")
+	}))
+	defer httpProxy.Close()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen for the SOCKS5 proxy: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveSOCKS5(conn, proxied)
+		}
+	}()
+
+	for _, proxy := range []string{httpProxy.URL, "socks5://" + listener.Addr().String()} {
+		output := make(chan string, 10)
+		errors := make(chan error)
+		fetch := make(chan *http.Response)
+		done := make(chan struct{})
+
+		fetcher := NewFetcher(1, 0, 5*time.Second, output, errors, fetch, done)
+		if err := fetcher.SetProxy(proxy); err != nil {
+			t.Fatalf("Failed to set the proxy %s: %v", proxy, err)
+		}
+
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go fetcher.StartFetching(&wg)
+
+		fetcher.NewRequest("http://crawl.invalid/page")
+		select {
+		case resp := <-fetch:
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("The request through %s returned %d, expected 200", proxy, resp.StatusCode)
+			}
+		case err := <-errors:
+			t.Fatalf("Failed to fetch the page through %s: %v", proxy, err)
+		}
+		if link := <-proxied; link != "http://crawl.invalid/page" {
+			t.Errorf("The proxy %s received the request for [%s], expected [http://crawl.invalid/page]", proxy, link)
+		}
+		close(done)
+		wg.Wait()
+	}
+
+	fetcher := NewFetcher(1, 0, 5*time.Second, nil, nil, nil, nil)
+	for _, proxy := range []string{"ftp://proxy:21", "proxy:8080", ""} {
+		if err := fetcher.SetProxy(proxy); err == nil {
+			t.Errorf("Expected an error for the proxy [%s]", proxy)
+		}
+	}
+}
+
+// serveSOCKS5 accepts a SOCKS5 CONNECT without authentication and answers the
+// HTTP request sent over it, sending the host and path that were requested.
+func serveSOCKS5(conn net.Conn, proxied chan<- string) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	greeting := make([]byte, 2)
+	if _, err := io.ReadFull(reader, greeting); err != nil {
+		return
+	}
+	if _, err := io.ReadFull(reader, make([]byte, greeting[1])); err != nil {
+		return
+	}
+	conn.Write([]byte{5, 0})
+
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(reader, request); err != nil {
+		return
+	}
+	var host string
+	switch request[3] {
+	case 1:
+		address := make([]byte, 4)
+		io.ReadFull(reader, address)
+		host = net.IP(address).String()
+	case 3:
+		length, _ := reader.ReadByte()
+		name := make([]byte, length)
+		io.ReadFull(reader, name)
+		host = string(name)
+	default:
+		return
+	}
+	io.ReadFull(reader, make([]byte, 2))
+	conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+
+	req, err := http.ReadRequest(reader)
+	if err != nil {
+		return
+	}
+	proxied <- "http://" + host + req.URL.Path
+	fmt.Fprint(conn, "HTTP/1.1 200 OK\r\nContent-Length: 13\r\nConnection: close\r\n\r\n<html></html>")
+}
+
+// Spawn a test server with a chain of two redirects and test that each hop
+// is recorded in order and the response is for the end of the chain, and
+// that with a lower MaxRedirects the chain is an error that is not retried.
+func Test_Redirects(t *testing.T) {
+	var requests atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		switch r.URL.Path {
+		case "/old":
+			http.Redirect(w, r, "/moved", http.StatusMovedPermanently)
+		case "/moved":
+			http.Redirect(w, r, "/new", http.StatusFound)
+		default:
+			fmt.Fprint(w, "<html></html>")
+		}
+	}))
+	defer ts.Close()
+
+	for _, max := range []int{10, 2, 1} {
+		requests.Store(0)
+		output := make(chan string, 10)
+		errors := make(chan error, 10)
+		fetch := make(chan *http.Response)
+		done := make(chan struct{})
+
+		fetcher := NewFetcher(1, 2, 5*time.Second, output, errors, fetch, done)
+		fetcher.Clock = &fakeClock{now: time.Now()}
+		fetcher.MaxRedirects = max
+
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go fetcher.StartFetching(&wg)
+
+		fetcher.NewRequest(ts.URL + "/old")
+		expected := []string{
+			fmt.Sprintf("redirect,%s/old,%s/moved,301", ts.URL, ts.URL),
+			fmt.Sprintf("redirect,%s/moved,%s/new,302", ts.URL, ts.URL),
+		}
+		if max < 2 {
+			err := <-errors
+			if !strings.Contains(err.Error(), "too many redirects, stopped after 1") {
+				t.Errorf("Unexpected error for a chain longer than the limit: %v", err)
+			}
+			expected = expected[:1]
+		} else {
+			resp := <-fetch
+			resp.Body.Close()
+			if resp.Request.URL.String() != ts.URL+"/new" {
+				t.Errorf("The redirects were not followed to /new: %s", resp.Request.URL)
+			}
+		}
+		close(done)
+		wg.Wait()
+		close(output)
+
+		var records []string
+		for record := range output {
+			records = append(records, record)
+		}
+		if strings.Join(records, "\n") != strings.Join(expected, "\n") {
+			t.Errorf("With MaxRedirects %d the redirects were recorded as %v, expected %v", max, records, expected)
+		}
+		if len(errors) != 0 || requests.Load() != int32(len(expected)+1) {
+			t.Errorf("With MaxRedirects %d there were %d requests and %d more errors, expected %d requests", max, requests.Load(), len(errors), len(expected)+1)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package fetcher
+
+// Count the failed requests to each host so that a host that keeps failing
+// can be abandoned for the rest of the crawl.
+
+import "sync"
+
+// hostErrors holds the total number of failed requests to each host, a host
+// is abandoned once it has failed max requests.
+type hostErrors struct {
+	mu     sync.Mutex
+	max    int
+	counts map[string]int
+}
+
+// newHostErrors returns a pointer to a hostErrors that abandons the hosts
+// after max failed requests.
+func newHostErrors(max int) *hostErrors {
+	return &hostErrors{max: max, counts: map[string]int{}}
+}
+
+// abandoned reports whether the host has failed too many requests
+func (h *hostErrors) abandoned(host string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.counts[host] >= h.max
+}
+
+// add counts a failed request to the host, it returns true only for the
+// failure that causes the host to be abandoned.
+func (h *hostErrors) add(host string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.counts[host]++
+	return h.counts[host] == h.max
+}
@@ -0,0 +1,63 @@
+package fetcher
+
+// A semaphore for the in-flight requests whose limit can be changed while the
+// crawl runs, so the concurrency can be turned up or down without restarting.
+
+import "sync"
+
+// Limiter caps the number of concurrent holders, a limit of 0 is unlimited.
+// Lowering the limit does not interrupt the current holders, new ones wait
+// until the number active has dropped below it.
+type Limiter struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	limit  int
+	active int
+}
+
+// NewLimiter returns a pointer to a fetcher.Limiter with the limit set
+func NewLimiter(limit int) *Limiter {
+	l := &Limiter{limit: limit}
+	l.cond = sync.NewCond(&l.mu)
+	return l
+}
+
+// Acquire blocks until the number active is below the limit
+func (l *Limiter) Acquire() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for l.limit > 0 && l.active >= l.limit {
+		l.cond.Wait()
+	}
+	l.active++
+}
+
+// Release gives up a slot once the holder has finished
+func (l *Limiter) Release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.active--
+	l.cond.Signal()
+}
+
+// Resize changes the limit, the waiters are woken when it is raised
+func (l *Limiter) Resize(limit int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.limit = limit
+	l.cond.Broadcast()
+}
+
+// Limit returns the current limit
+func (l *Limiter) Limit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.limit
+}
+
+// Active returns the number of slots that are held
+func (l *Limiter) Active() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.active
+}
@@ -0,0 +1,60 @@
+package fetcher
+
+// A minimum interval between the requests to each host, the crawl delay, so
+// that a small site is not overwhelmed by the workers requesting it at once.
+
+import (
+	"sync"
+	"time"
+)
+
+// politeness spaces out the requests to each host, next is the earliest time
+// the next request to a host can be sent.
+type politeness struct {
+	clock Clock
+	mu    sync.Mutex
+	next  map[string]time.Time
+}
+
+// newPoliteness returns a pointer to a politeness that pauses on the clock
+func newPoliteness(clock Clock) *politeness {
+	return &politeness{clock: clock, next: map[string]time.Time{}}
+}
+
+// Wait pauses until a request can be sent to the host. The requests from the
+// workers are given turns, each one at least the interval after the last.
+func (p *politeness) Wait(host string, interval time.Duration) {
+	p.mu.Lock()
+	now := p.clock.Now()
+	turn := p.next[host]
+	if turn.Before(now) {
+		turn = now
+	}
+	p.next[host] = turn.Add(interval)
+	p.mu.Unlock()
+
+	if wait := turn.Sub(now); wait > 0 {
+		p.clock.Sleep(wait)
+	}
+}
+
+// crawlInterval returns the interval between the requests to a host, the
+// Crawl-delay of the robots.txt of its host takes the place of the CrawlDelay.
+func (f *Fetcher) crawlInterval(host string) time.Duration {
+	if f.robots != nil && f.robots.host == host && f.robots.crawlDelay > 0 {
+		return f.robots.crawlDelay
+	}
+	return f.CrawlDelay
+}
+
+// waitTurn pauses until a request can be sent to the host by the crawl
+// interval, the workers and the probes take their turns from the same
+// politeness so the interval holds across both.
+func (f *Fetcher) waitTurn(host string) {
+	interval := f.crawlInterval(host)
+	if interval <= 0 {
+		return
+	}
+	f.politeOnce.Do(func() { f.polite = newPoliteness(f.Clock) })
+	f.polite.Wait(host, interval)
+}
@@ -0,0 +1,60 @@
+package fetcher
+
+// Probe the well-known files of a site, such as its favicon and
+// security.txt, to record which of them it has for profiling the site.
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// DefaultProbePaths are the well-known paths probed when none are configured
+var DefaultProbePaths = []string{
+	"/favicon.ico",
+	"/robots.txt",
+	"/sitemap.xml",
+	"/humans.txt",
+	"/.well-known/security.txt",
+	"/.well-known/change-password",
+}
+
+// Probe requests each of the paths on the host of the seed and emits a
+// probe,<url>,<status> record for each, the status is 0 when the request
+// failed and the error is reported. The requests are built like the crawl's
+// so they have the same User-Agent, Host and credentials, redirects are
+// followed so a path that redirects is recorded with its final status. The
+// probes share the in-flight limit and the crawl interval with the workers.
+func (f *Fetcher) Probe(seed *url.URL, paths []string, wg *sync.WaitGroup) {
+	defer wg.Done()
+	for _, path := range paths {
+		if !strings.HasPrefix(path, "/") {
+			path = "/" + path
+		}
+		target := seed.Scheme + "://" + seed.Host + path
+		f.emit(fmt.Sprintf("probe,%s,%d", target, f.probe(target)))
+	}
+}
+
+// probe requests a single URL and returns its status code, 0 on failure
+func (f *Fetcher) probe(target string) int {
+	req, err := f.BuildRequest(target)
+	if err != nil {
+		f.report(fmt.Errorf("Failed to build the probe for %s: %v", target, err))
+		return 0
+	}
+	f.waitTurn(req.URL.Host)
+	f.acquire()
+	resp, timedOut, err := f.send(req)
+	f.release()
+	if timedOut {
+		err = fmt.Errorf("timed out after %v", f.Timeout)
+	}
+	if err != nil {
+		f.report(fmt.Errorf("Failed to probe %s: %v", target, err))
+		return 0
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
@@ -0,0 +1,59 @@
+package fetcher
+
+// Re-check the URLs that were reported as broken by an earlier crawl, so that
+// the fixes to a site can be verified without crawling all of it again.
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// LoadBroken reads the URLs of the broken records from the output of an
+// earlier crawl run with -report-broken. The records are broken,<status>,<url>
+// with or without the data prefix added by the stream, the other records are
+// skipped and each URL is returned once in the order it was first reported.
+func LoadBroken(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error opening broken report: %v", err)
+	}
+	defer file.Close()
+
+	var urls []string
+	seen := map[string]bool{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		record := strings.TrimPrefix(strings.TrimSpace(scanner.Text()), "data,")
+		fields := strings.SplitN(record, ",", 3)
+		if len(fields) != 3 || fields[0] != "broken" || seen[fields[2]] {
+			continue
+		}
+		seen[fields[2]] = true
+		urls = append(urls, fields[2])
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Error reading broken report: %v", err)
+	}
+	return urls, nil
+}
+
+// Recheck requests each of the URLs without looking for links on them and
+// emits a fixed,<url>,<status> record for those that now succeed and a
+// still-broken,<url>,<status> record for the others. They are requested the
+// same way as the probes, so a URL that now redirects to a working page is
+// fixed, and the status is 0 when the request failed.
+func (f *Fetcher) Recheck(urls []string, wg *sync.WaitGroup) {
+	defer wg.Done()
+	for _, url := range urls {
+		status := f.probe(url)
+		if status == 0 || status >= http.StatusBadRequest {
+			f.emit(fmt.Sprintf("still-broken,%s,%d", url, status))
+		} else {
+			f.emit(fmt.Sprintf("fixed,%s,%d", url, status))
+		}
+	}
+}
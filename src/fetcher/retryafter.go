@@ -0,0 +1,57 @@
+package fetcher
+
+// Honour the Retry-After header of a rate limited or unavailable response by
+// waiting for as long as the server asks before the request is retried.
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// retryAfter returns how long to wait before retrying a response that asks
+// the client to back off, a 429 or a 503 with a Retry-After header. A 429
+// without a usable header waits for the usual retry delay, and the wait
+// is capped at the MaxRetryAfter.
+func (f *Fetcher) retryAfter(resp *http.Response, attempt int) (time.Duration, bool) {
+	header := resp.Header.Get("Retry-After")
+	wait, ok := time.Duration(0), false
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests:
+		if wait, ok = parseRetryAfter(header, f.Clock.Now()); !ok {
+			wait, ok = f.retryDelay(nil, attempt), true
+		}
+	case http.StatusServiceUnavailable:
+		wait, ok = parseRetryAfter(header, f.Clock.Now())
+	}
+	if ok && f.MaxRetryAfter > 0 && wait > f.MaxRetryAfter {
+		wait = f.MaxRetryAfter
+	}
+	return wait, ok
+}
+
+// parseRetryAfter parses a Retry-After header in either of its forms, a
+// number of seconds or an HTTP date which is waited for from now. A date in
+// the past is no wait and a header that does not parse is not ok.
+func parseRetryAfter(header string, now time.Time) (time.Duration, bool) {
+	header = strings.TrimSpace(header)
+	if len(header) == 0 {
+		return 0, false
+	}
+	if seconds, err := strconv.ParseInt(header, 10, 64); err == nil {
+		if seconds < 0 || seconds > int64(math.MaxInt64/time.Second) {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	date, err := http.ParseTime(header)
+	if err != nil {
+		return 0, false
+	}
+	if wait := date.Sub(now); wait > 0 {
+		return wait, true
+	}
+	return 0, true
+}
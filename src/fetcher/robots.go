@@ -0,0 +1,109 @@
+package fetcher
+
+// Honour the Disallow rules of the robots.txt of the seed's host, it is
+// downloaded once at the start of the crawl and the requests for the paths
+// it disallows are skipped. Its Crawl-delay spaces out the requests to the
+// host.
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Robots holds the Disallow path prefixes and the Crawl-delay of a host's
+// robots.txt that apply to every user-agent, the rules only apply to the URLs
+// of that host.
+type Robots struct {
+	host       string
+	disallow   []string
+	crawlDelay time.Duration
+}
+
+// ParseRobots reads the Disallow rules and the Crawl-delay of the groups for
+// the * user-agent from a robots.txt, the groups for named user-agents are
+// skipped. An empty Disallow allows every path so it adds no rule, and the
+// Crawl-delay is a number of seconds that may be fractional.
+func ParseRobots(host string, body io.Reader) *Robots {
+	robots := &Robots{host: host}
+	applies, agents := false, false
+	scanner := bufio.NewScanner(body)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key, value = strings.ToLower(strings.TrimSpace(key)), strings.TrimSpace(value)
+		switch key {
+		case "user-agent":
+			// Consecutive user-agent lines share the rules that follow them
+			if !agents {
+				applies = false
+			}
+			agents = true
+			if value == "*" {
+				applies = true
+			}
+		case "disallow":
+			agents = false
+			if applies && len(value) > 0 {
+				robots.disallow = append(robots.disallow, value)
+			}
+		case "crawl-delay":
+			agents = false
+			if seconds, err := strconv.ParseFloat(value, 64); applies && err == nil && seconds > 0 {
+				robots.crawlDelay = time.Duration(seconds * float64(time.Second))
+			}
+		default:
+			agents = false
+		}
+	}
+	return robots
+}
+
+// Allowed reports whether a URL can be fetched, a URL of another host than
+// the robots.txt's is always allowed.
+func (r *Robots) Allowed(u *url.URL) bool {
+	if u.Host != r.host {
+		return true
+	}
+	path := u.RequestURI()
+	for _, prefix := range r.disallow {
+		if strings.HasPrefix(path, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// LoadRobots downloads the robots.txt of the seed's host so that the paths it
+// disallows are skipped by the workers, it must be called before fetching
+// starts. A robots.txt that is missing, or cannot be fetched, allows every
+// path and the failure is reported.
+func (f *Fetcher) LoadRobots(seed *url.URL) {
+	target := seed.Scheme + "://" + seed.Host + "/robots.txt"
+	req, err := f.BuildRequest(target)
+	if err != nil {
+		f.report(fmt.Errorf("Failed to build the request for %s: %v", target, err))
+		return
+	}
+	resp, timedOut, err := f.send(req)
+	if timedOut {
+		err = fmt.Errorf("timed out after %v", f.Timeout)
+	}
+	if err != nil {
+		f.report(fmt.Errorf("Failed to fetch %s, every path is allowed: %v", target, err))
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return
+	}
+	f.robots = ParseRobots(seed.Host, resp.Body)
+}
@@ -0,0 +1,456 @@
+package fronter
+
+// The fronter package manages the crawl frontier, it receives the links
+// found by the workers on the Worklist channel, records them in the thread
+// safe data.Data structure and passes any that have not been seen before to
+// the Unseen channel for the workers to crawl. It also monitors the crawl
+// and signals on the Done channel once there is nothing left to crawl.
+
+import (
+	"fmt"
+	"linkcrawl/data"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Link is a URL along with its depth from the seed, the seed is depth 0, the
+// links found on the seed are depth 1 and so on. Queued is the time the link
+// was enqueued, it is zero for links that were not passed through Enqueue.
+// Fetched marks a link that has already been fetched, such as the target of
+// a redirect, it is recorded as visited without being sent to the workers.
+type Link struct {
+	URL     string
+	Depth   int
+	Queued  time.Time
+	Fetched bool
+}
+
+// The Fronter struct holds the channels used to pass links between the
+// workers and the frontier along with the crawl limits.
+type Fronter struct {
+	Seen     *data.Data
+	Worklist chan []Link
+	Unseen   chan Link
+	Done     chan struct{}
+	Out      chan<- string
+
+	// MaxDepth is the deepest level that is crawled, zero or negative values
+	// mean the depth is unlimited.
+	MaxDepth int
+
+	// HostDepth overrides MaxDepth for the links to a host, keyed by the
+	// lower case host name without its port. A depth of zero is unlimited.
+	HostDepth map[string]int
+
+	// RecordLeaves reports the links found beyond MaxDepth as discovered,
+	// without enqueueing them to be fetched.
+	RecordLeaves bool
+
+	// ReportSkipped emits a skipped record with the reason for each link that
+	// is dropped for being beyond MaxDepth or stale.
+	ReportSkipped bool
+
+	// Interval is how often the monitor checks whether the crawl is finished
+	Interval time.Duration
+
+	// TTL is how long a link can wait in the frontier before it is dropped as
+	// stale instead of being fetched, zero keeps links until they are fetched.
+	TTL time.Duration
+
+	// Now returns the current time used to age the queued links
+	Now func() time.Time
+
+	// MaxIdle ends the crawl when no page has been fetched for this long,
+	// such as when every worker is held up by a hung host, zero waits for
+	// the crawl to finish. The workers record each fetch with Progress.
+	MaxIdle      time.Duration
+	lastProgress atomic.Int64
+
+	// MaxPages is the number of pages that are fetched successfully, as
+	// recorded by the workers with Fetched. Once it is reached the links that
+	// are found are dropped, the pages still being fetched are not crawled
+	// and the crawl ends. Zero is unlimited.
+	MaxPages int
+	fetched  atomic.Int64
+	capped   map[string]bool
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewFronter returns a pointer to a fronter.Fronter object, it records the
+// links in the seen data.Data structure and closes done once the crawl is
+// complete.
+func NewFronter(seen *data.Data, output chan<- string, done chan struct{}) *Fronter {
+	return &Fronter{
+		Seen:     seen,
+		Worklist: make(chan []Link), // Data returned from crawling
+		Unseen:   make(chan Link),   // URLs to scrape
+		Done:     done,
+		Out:      output,
+		Interval: 1 * time.Second,
+		Now:      time.Now,
+		stop:     make(chan struct{}),
+	}
+}
+
+// Stop asks the monitor to end the crawl early, it is safe to call more
+// than once and from any goroutine. The monitor remains the only place the
+// Done channel is closed.
+func (f *Fronter) Stop() {
+	f.stopOnce.Do(func() {
+		close(f.stop)
+	})
+}
+
+// Progress records that a page has been fetched, the crawl is ended by the
+// monitor when there has been no progress for MaxIdle.
+func (f *Fronter) Progress() {
+	f.lastProgress.Store(f.Now().UnixNano())
+}
+
+// idleFor returns how long it has been since the last Progress
+func (f *Fronter) idleFor() time.Duration {
+	return f.Now().Sub(time.Unix(0, f.lastProgress.Load()))
+}
+
+// Enqueue passes the links found on a page to the Worklist channel in the
+// background, giving up if the crawl ends before they are taken. The links
+// are stamped with the time they were enqueued.
+func (f *Fronter) Enqueue(links []Link, wg *sync.WaitGroup) {
+	now := f.Now()
+	for i := range links {
+		links[i].Queued = now
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		select {
+		case f.Worklist <- links:
+		case <-f.Done:
+		}
+	}()
+}
+
+// Seed takes the supplied URL as the `seed` and enqueues it into the
+// worklist channel for processing, giving up if the crawl ends before it
+// is taken so the goroutine does not leak.
+func (f *Fronter) Seed(domain string, wg *sync.WaitGroup) {
+	seed := []Link{{URL: domain, Depth: 0, Queued: f.Now()}}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		select {
+		case f.Worklist <- seed:
+		case <-f.Done:
+		}
+	}()
+}
+
+// Resume enqueues the links in Seen that have not been scraped, such as the
+// links loaded from the state of an interrupted crawl, at the depth they were
+// found at. It reports whether Seen holds any links so that a crawl that is
+// resumed is not seeded again.
+func (f *Fronter) Resume(wg *sync.WaitGroup) bool {
+	f.Seen.Mu.Lock()
+	var pending []Link
+	for url, scraped := range f.Seen.Links {
+		if !scraped {
+			pending = append(pending, Link{URL: url, Depth: f.Seen.Depth[url], Queued: f.Now()})
+		}
+	}
+	loaded := len(f.Seen.Links) > 0
+	f.Seen.Mu.Unlock()
+
+	sort.Slice(pending, func(i, j int) bool {
+		if pending[i].Depth != pending[j].Depth {
+			return pending[i].Depth < pending[j].Depth
+		}
+		return pending[i].URL < pending[j].URL
+	})
+	if len(pending) > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			select {
+			case f.Worklist <- pending:
+			case <-f.Done:
+			}
+		}()
+	}
+	return loaded
+}
+
+// Start spawns the goroutines that maintain the frontier and monitor the
+// crawl for completion.
+func (f *Fronter) Start(wg *sync.WaitGroup) {
+	wg.Add(2)
+	go f.cache(wg)
+	go f.monitor(wg)
+}
+
+// Depth returns the depth a URL was enqueued at, URLs that have not been
+// enqueued are reported at depth 0.
+func (f *Fronter) Depth(url string) int {
+	f.Seen.Mu.Lock()
+	defer f.Seen.Mu.Unlock()
+	return f.Seen.Depth[url]
+}
+
+// RecordStatus records the final status code a URL was fetched with, the URL
+// is the one the response was for after any redirects.
+func (f *Fronter) RecordStatus(url string, code int) {
+	f.Seen.Mu.Lock()
+	defer f.Seen.Mu.Unlock()
+	f.Seen.Status[url] = code
+}
+
+// Fetched counts a page that a worker has fetched against MaxPages, only a
+// page with a status below 400 is counted so failed pages do not use up the
+// limit. It returns false for a page fetched once the limit has been
+// reached, which is not crawled, and the limit is reported when the page
+// that reaches it is counted.
+func (f *Fronter) Fetched(code int) bool {
+	if f.MaxPages <= 0 {
+		return true
+	}
+	limit := int64(f.MaxPages)
+	for {
+		fetched := f.fetched.Load()
+		if fetched >= limit {
+			return false
+		}
+		if code >= 400 {
+			return true
+		}
+		if f.fetched.CompareAndSwap(fetched, fetched+1) {
+			if fetched+1 == limit {
+				f.Out <- fmt.Sprintf("max-pages,%d", f.MaxPages)
+			}
+			return true
+		}
+	}
+}
+
+// cache retrieves the links that are returned from the workers and stores
+// them along with their visited state and depth in the thread safe
+// data.Data structure.
+// Unseen URLs within the depth limit are written to the Unseen channel,
+// unless they have been waiting for longer than the TTL.
+func (f *Fronter) cache(wg *sync.WaitGroup) {
+	defer wg.Done()
+	for {
+		select {
+		case list, ok := <-f.Worklist:
+			if !ok {
+				return
+			}
+			for _, link := range list {
+				queue := false
+				var records []string
+				f.Seen.Mu.Lock()
+				if !f.Seen.Links[link.URL] {
+					switch {
+					case link.Fetched:
+						f.Seen.Links[link.URL] = true
+						if _, ok := f.Seen.Depth[link.URL]; !ok {
+							f.Seen.Depth[link.URL] = link.Depth
+						}
+					case f.stale(link, &records):
+						// Dropped without being recorded so it is queued
+						// again if it is found on a later page
+					case f.beyondDepth(link):
+						f.discovered(link, &records)
+					case f.MaxPages > 0 && f.fetched.Load() >= int64(f.MaxPages):
+						f.limited(link, &records)
+					default:
+						// Recorded as waiting to be fetched until it is queued
+						// so the monitor does not see the crawl as finished
+						f.Seen.Links[link.URL] = false
+						f.Seen.Depth[link.URL] = link.Depth
+						queue = true
+					}
+				}
+				f.Seen.Mu.Unlock()
+
+				// The records and the link are sent without holding the lock
+				// as the workers take it to record the pages they fetch
+				for _, record := range records {
+					select {
+					case f.Out <- record:
+					case <-f.Done:
+						return
+					}
+				}
+				if queue {
+					select {
+					case f.Unseen <- link:
+					case <-f.Done:
+						return
+					}
+					f.Seen.Mu.Lock()
+					f.Seen.Links[link.URL] = true
+					f.Seen.Mu.Unlock()
+				}
+			}
+		case <-f.Done:
+			return
+		}
+	}
+}
+
+// beyondDepth reports whether a link is deeper than the depth limit of its
+// host, the HostDepth of the host is used over MaxDepth when it is set.
+func (f *Fronter) beyondDepth(link Link) bool {
+	limit := f.MaxDepth
+	if len(f.HostDepth) > 0 {
+		if u, err := url.Parse(link.URL); err == nil {
+			if depth, ok := f.HostDepth[strings.ToLower(u.Hostname())]; ok {
+				limit = depth
+			}
+		}
+	}
+	return limit > 0 && link.Depth > limit
+}
+
+// discovered records a link found beyond the depth limit the first time it
+// is seen, the caller must hold the data.Data lock and send the records added
+// to records once it is released.
+func (f *Fronter) discovered(link Link, records *[]string) {
+	if (!f.RecordLeaves && !f.ReportSkipped) || f.Seen.Discovered[link.URL] {
+		return
+	}
+	f.Seen.Discovered[link.URL] = true
+	if f.RecordLeaves {
+		*records = append(*records, fmt.Sprintf("discovered,%s,%d", link.URL, link.Depth))
+	}
+	if f.ReportSkipped {
+		*records = append(*records, fmt.Sprintf("skipped,%s,max-depth", link.URL))
+	}
+}
+
+// limited drops a link found once MaxPages have been fetched, the link is
+// reported the first time it is seen. The caller must hold the data.Data
+// lock and send the records added to records once it is released.
+func (f *Fronter) limited(link Link, records *[]string) {
+	if f.capped == nil {
+		f.capped = map[string]bool{}
+	}
+	if f.capped[link.URL] {
+		return
+	}
+	f.capped[link.URL] = true
+	if f.ReportSkipped {
+		*records = append(*records, fmt.Sprintf("skipped,%s,max-pages", link.URL))
+	}
+}
+
+// stale reports a link that has waited in the frontier for longer than the
+// TTL, the caller must hold the data.Data lock and send the records added to
+// records once it is released.
+func (f *Fronter) stale(link Link, records *[]string) bool {
+	if f.TTL <= 0 || link.Queued.IsZero() {
+		return false
+	}
+	age := f.Now().Sub(link.Queued)
+	if age <= f.TTL {
+		return false
+	}
+	*records = append(*records, fmt.Sprintf("stale,%s,%s", link.URL, age))
+	if f.ReportSkipped {
+		*records = append(*records, fmt.Sprintf("skipped,%s,stale", link.URL))
+	}
+	return true
+}
+
+// Monitor for completion
+// The number of URLs (keys) in the data.Links map indicate the number of
+// URLs that have been found. Using this structure along with checking the
+// size of the worker queue gives indication when the program can exit
+// Three chances are given with a pregnant pause in between to make sure that
+// the crawling really is finished before the Done channel is closed.
+// Calling Stop ends the crawl straight away, as does MaxIdle passing
+// without a page being fetched.
+// The Worklist and Unseen channels are left open, every goroutine that uses
+// them exits on Done, and closing them could panic a goroutine that is still
+// sending when the crawl is stopped early.
+func (f *Fronter) monitor(wg *sync.WaitGroup) {
+	defer wg.Done()
+	chances := 0
+	lastSeen := 0
+	lastVisited := 0
+	f.Progress() // The idle time is counted from the start of the crawl
+	for {
+		if !f.pause(f.Interval) {
+			close(f.Done)
+			return
+		}
+
+		// The crawl is stuck when nothing has been fetched within MaxIdle,
+		// even if there are links still waiting to be fetched
+		if idle := f.idleFor(); f.MaxIdle > 0 && idle > f.MaxIdle {
+			f.Out <- fmt.Sprintf("max-idle,%v,%v", f.MaxIdle, idle.Truncate(time.Millisecond))
+			close(f.Done)
+			return
+		}
+		var seen []string
+
+		// Lock data structure from further R/W before processing its contents
+		f.Seen.Mu.Lock()
+
+		// Store the URLs that have been crawled
+		for url, been := range f.Seen.Links {
+			if been {
+				seen = append(seen, url)
+			}
+		}
+
+		// Reset the chances counter if the crawling starts again
+		if len(seen) != len(f.Seen.Links) {
+			chances = 0
+		}
+
+		visited := len(f.Seen.Links)
+		idle := len(seen) == visited && len(seen) == lastSeen && visited == lastVisited && visited > 0
+
+		// Unlock the data structure
+		f.Seen.Mu.Unlock()
+
+		if idle {
+			chances++
+			if !f.pause(3 * f.Interval) {
+				chances = 3
+			}
+		}
+
+		// The conditions to exit the program have been met, stop all running
+		// goroutines and exit
+		if chances == 3 {
+			close(f.Done)
+			return
+		}
+
+		// Update the last seen and last visited variables use in the next loop
+		// iteration
+		lastSeen = len(seen)
+		lastVisited = visited
+
+		// Reset the seen list so that on the next loop iteration it is filled
+		// with a fresh list of URLs that have now been scraped
+		seen = seen[:0]
+	}
+}
+
+// pause waits for the duration d, it returns false if Stop is called first
+func (f *Fronter) pause(d time.Duration) bool {
+	select {
+	case <-time.After(d):
+		return true
+	case <-f.stop:
+		return false
+	}
+}
@@ -0,0 +1,637 @@
+package fronter
+
+import (
+	"fmt"
+	"linkcrawl/data"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Create a fronter with a short monitor interval so the tests do not need
+// to wait for the default polling period, the output channel is returned so
+// the tests can read what was reported.
+func newTestFronter() (*Fronter, chan string) {
+	output := make(chan string, 100)
+	f := NewFronter(data.NewData(), output, make(chan struct{}))
+	f.Interval = 10 * time.Millisecond
+	return f, output
+}
+
+// receive reads the next link from the Unseen channel, failing the test if
+// nothing arrives in time.
+func receive(t *testing.T, f *Fronter) Link {
+	t.Helper()
+	select {
+	case link := <-f.Unseen:
+		return link
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for a link on the Unseen channel")
+	}
+	return Link{}
+}
+
+// Walk a crawl through a depth limit of 1 and test that the links found at
+// depth 2 are reported as discovered but are never passed on to be fetched.
+func Test_MaxDepthRecordLeaves(t *testing.T) {
+	f, output := newTestFronter()
+	f.MaxDepth = 1
+	f.RecordLeaves = true
+
+	var wg sync.WaitGroup
+	f.Seed("https://example.com", &wg)
+	wg.Add(1)
+	go f.cache(&wg)
+
+	if link := receive(t, f); link.URL != "https://example.com" || link.Depth != 0 {
+		t.Fatalf("Expected the seed at depth 0, got %v", link)
+	}
+
+	f.Worklist <- []Link{
+		{URL: "https://example.com/about", Depth: 1},
+		{URL: "https://example.com/blog", Depth: 1},
+	}
+	receive(t, f)
+	receive(t, f)
+
+	// Links found on the last crawled level, including a duplicate
+	f.Worklist <- []Link{
+		{URL: "https://example.com/team", Depth: 2},
+		{URL: "https://example.com/team", Depth: 2},
+		{URL: "https://example.com", Depth: 2},
+	}
+
+	select {
+	case link := <-f.Unseen:
+		t.Errorf("The link %v beyond the depth limit should not be fetched", link)
+	case msg := <-output:
+		if msg != "discovered,https://example.com/team,2" {
+			t.Errorf("Unexpected output for a leaf link: %s", msg)
+		}
+	case <-time.After(time.Second):
+		t.Error("Timed out waiting for the leaf link to be reported")
+	}
+
+	close(f.Done)
+	wg.Wait()
+
+	if len(output) != 0 {
+		t.Error("Each leaf link should only be reported once")
+	}
+	if f.Seen.Links["https://example.com/team"] || !f.Seen.Discovered["https://example.com/team"] {
+		t.Error("The leaf link should be recorded as discovered rather than crawled")
+	}
+	if f.Seen.Depth["https://example.com/blog"] != 1 {
+		t.Errorf("The depth of the crawled link is %d, expected 1", f.Seen.Depth["https://example.com/blog"])
+	}
+}
+
+// Walk a crawl through links far deeper than any limit with a MaxDepth of 0
+// and of -1, and test that every link is passed on to be fetched.
+func Test_MaxDepthUnlimited(t *testing.T) {
+	for _, depth := range []int{0, -1} {
+		f, output := newTestFronter()
+		f.MaxDepth = depth
+		f.RecordLeaves = true
+
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go f.cache(&wg)
+
+		f.Worklist <- []Link{
+			{URL: "https://example.com/a", Depth: 1},
+			{URL: "https://example.com/a/b", Depth: 50},
+			{URL: "https://example.com/a/b/c", Depth: 1000},
+		}
+		for i := 0; i < 3; i++ {
+			receive(t, f)
+		}
+
+		close(f.Done)
+		wg.Wait()
+		if len(output) != 0 {
+			t.Errorf("No link should be beyond a max depth of %d, got %s", depth, <-output)
+		}
+	}
+}
+
+// Test that a depth limit of 0 leaves the crawl depth unlimited.
+func Test_UnlimitedDepth(t *testing.T) {
+	f, _ := newTestFronter()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go f.cache(&wg)
+
+	f.Worklist <- []Link{{URL: "https://example.com/deep", Depth: 50}}
+	if link := receive(t, f); link.Depth != 50 {
+		t.Errorf("Expected the link at depth 50, got %v", link)
+	}
+
+	close(f.Done)
+	wg.Wait()
+}
+
+// Crawl two hosts with a global depth limit of 1 and an override of 3 for
+// the main host, test that the links of each host are dropped beyond the
+// limit of their own host.
+func Test_HostDepth(t *testing.T) {
+	f, output := newTestFronter()
+	f.MaxDepth = 1
+	f.HostDepth = map[string]int{"example.com": 3}
+	f.RecordLeaves = true
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go f.cache(&wg)
+
+	f.Worklist <- []Link{
+		{URL: "https://example.com/a/b/c", Depth: 3},
+		{URL: "https://cdn.example.com/one", Depth: 1},
+		{URL: "https://example.com:8443/a/b/c/d", Depth: 4},
+		{URL: "https://CDN.example.com/two", Depth: 2},
+	}
+	if link := receive(t, f); link.URL != "https://example.com/a/b/c" {
+		t.Errorf("Expected the main host link at depth 3 to be fetched, got %v", link)
+	}
+	if link := receive(t, f); link.URL != "https://cdn.example.com/one" {
+		t.Errorf("Expected the cdn link at depth 1 to be fetched, got %v", link)
+	}
+
+	for _, expected := range []string{
+		"discovered,https://example.com:8443/a/b/c/d,4",
+		"discovered,https://CDN.example.com/two,2",
+	} {
+		select {
+		case link := <-f.Unseen:
+			t.Errorf("The link %v beyond its host's depth limit should not be fetched", link)
+		case msg := <-output:
+			if msg != expected {
+				t.Errorf("Unexpected output %s, expected %s", msg, expected)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("Timed out waiting for %s", expected)
+		}
+	}
+
+	close(f.Done)
+	wg.Wait()
+}
+
+// Seed a crawl that is torn down before the seed is taken and test that the
+// seeding goroutine exits rather than blocking on the Worklist forever.
+func Test_SeedDone(t *testing.T) {
+	before := runtime.NumGoroutine()
+	f, _ := newTestFronter()
+
+	var wg sync.WaitGroup
+	f.Seed("https://example.com", &wg)
+	close(f.Done)
+
+	exited := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(exited)
+	}()
+	select {
+	case <-exited:
+	case <-time.After(time.Second):
+		t.Fatal("The seeding goroutine did not exit once Done was closed")
+	}
+
+	// Give the goroutines that have exited time to be cleaned up
+	for i := 0; i < 100 && runtime.NumGoroutine() > before; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if after := runtime.NumGoroutine(); after > before {
+		t.Errorf("Expected at most %d goroutines once Done was closed, got %d", before, after)
+	}
+}
+
+// Load the state of an interrupted crawl into the fronter and test that only
+// the links that were not scraped are enqueued again, at their depths, and
+// that a fronter without links is not resumed.
+func Test_Resume(t *testing.T) {
+	f, _ := newTestFronter()
+	var wg sync.WaitGroup
+	if f.Resume(&wg) {
+		t.Error("Expected a fronter without links not to be resumed")
+	}
+
+	depths := map[string]int{"https://example.com/b": 1, "https://example.com/a/deep": 2, "https://example.com/a": 1}
+	f.Seen.Links["https://example.com"] = true
+	for url, depth := range depths {
+		f.Seen.Links[url] = false
+		f.Seen.Depth[url] = depth
+	}
+	if !f.Resume(&wg) {
+		t.Fatal("Expected the loaded links to be resumed")
+	}
+	f.Start(&wg)
+
+	var urls []string
+	for i := 0; i < 3; i++ {
+		link := receive(t, f)
+		if link.Depth != depths[link.URL] {
+			t.Errorf("The link %s was enqueued at depth %d, expected %d", link.URL, link.Depth, depths[link.URL])
+		}
+		urls = append(urls, link.URL)
+	}
+	if strings.Join(urls, ",") != "https://example.com/a,https://example.com/b,https://example.com/a/deep" {
+		t.Errorf("Unexpected links enqueued on resuming: %v", urls)
+	}
+	f.Stop()
+	wg.Wait()
+}
+
+// Test that the monitor closes the Done channel once all the links that
+// have been found are no longer changing.
+func Test_Monitor(t *testing.T) {
+	f, _ := newTestFronter()
+	f.Seen.Links["https://example.com"] = true
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go f.monitor(&wg)
+
+	select {
+	case <-f.Done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("The monitor did not signal that the crawl was complete")
+	}
+	wg.Wait()
+}
+
+// Leave a link waiting to be fetched so the crawl is never complete, test
+// that the crawl is kept going while pages are fetched and ended with a
+// max-idle record once the fetches stall for longer than MaxIdle.
+func Test_MaxIdle(t *testing.T) {
+	f, output := newTestFronter()
+	f.MaxIdle = 50 * time.Millisecond
+	f.Seen.Links["https://example.com"] = true
+	f.Seen.Links["https://example.com/hung"] = false
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go f.monitor(&wg)
+
+	// Pages are fetched for longer than MaxIdle
+	for i := 0; i < 10; i++ {
+		f.Progress()
+		time.Sleep(10 * time.Millisecond)
+	}
+	select {
+	case <-f.Done:
+		t.Fatal("The crawl was ended while pages were being fetched")
+	default:
+	}
+
+	f.Progress()
+	stalled := time.Now()
+	select {
+	case <-f.Done:
+	case <-time.After(time.Second):
+		t.Fatal("The monitor did not end the stalled crawl")
+	}
+	wg.Wait()
+	if elapsed := time.Since(stalled); elapsed < f.MaxIdle {
+		t.Errorf("The crawl was ended after %v, before MaxIdle", elapsed)
+	}
+	record := <-output
+	if !strings.HasPrefix(record, "max-idle,50ms,") {
+		t.Errorf("Expected a max-idle record, got [%s]", record)
+	}
+}
+
+// Stop a crawl that is still running with links waiting to be enqueued and
+// test that the Done channel is closed and every goroutine exits.
+func Test_Stop(t *testing.T) {
+	f, _ := newTestFronter()
+	f.Interval = time.Hour
+
+	var wg sync.WaitGroup
+	f.Seed("https://example.com", &wg)
+	f.Start(&wg)
+	receive(t, f)
+
+	// Nothing is reading the Unseen channel so these are left pending
+	f.Enqueue([]Link{{URL: "https://example.com/about", Depth: 1}}, &wg)
+	f.Enqueue([]Link{{URL: "https://example.com/blog", Depth: 1}}, &wg)
+
+	f.Stop()
+	f.Stop()
+
+	select {
+	case <-f.Done:
+	case <-time.After(time.Second):
+		t.Fatal("Stop did not close the Done channel")
+	}
+
+	finished := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-time.After(time.Second):
+		t.Fatal("The goroutines did not exit after the crawl was stopped")
+	}
+}
+
+// Enqueue one link, move a fake clock past the TTL and enqueue a second,
+// test that only the second link is passed on to be fetched and the first
+// is reported as stale.
+func Test_StaleLinks(t *testing.T) {
+	f, output := newTestFronter()
+	f.TTL = time.Minute
+
+	var mu sync.Mutex
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	f.Now = func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	}
+
+	var wg sync.WaitGroup
+	f.Enqueue([]Link{{URL: "https://example.com/gone", Depth: 1}}, &wg)
+	mu.Lock()
+	now = now.Add(2 * time.Minute)
+	mu.Unlock()
+	f.Enqueue([]Link{{URL: "https://example.com/fresh", Depth: 1}}, &wg)
+
+	wg.Add(1)
+	go f.cache(&wg)
+
+	if link := receive(t, f); link.URL != "https://example.com/fresh" {
+		t.Errorf("Expected the fresh link to be fetched, got %v", link)
+	}
+	select {
+	case msg := <-output:
+		if msg != "stale,https://example.com/gone,2m0s" {
+			t.Errorf("Unexpected output for a stale link: %s", msg)
+		}
+	case <-time.After(time.Second):
+		t.Error("Timed out waiting for the stale link to be reported")
+	}
+
+	close(f.Done)
+	wg.Wait()
+
+	if f.Seen.Links["https://example.com/gone"] {
+		t.Error("The stale link should not be recorded as crawled")
+	}
+}
+
+// Crawl a densely linked test site, where every page links to ten others,
+// with workers that fetch each page, record it with Fetched and enqueue its
+// links. Half of the links are to missing pages and some to a host that
+// refuses connections. Test that a limit of 5 pages results in exactly 5
+// pages fetched successfully and crawled, that the failed pages do not count
+// towards it, and that the monitor then ends the crawl with the limit
+// reported once.
+func Test_MaxPages(t *testing.T) {
+	closed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	unreachable := closed.URL
+	closed.Close()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/missing") {
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	f, output := newTestFronter()
+	f.MaxPages = 5
+
+	var crawled, failed atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case link := <-f.Unseen:
+					resp, err := http.Get(link.URL)
+					f.Progress()
+					if err != nil {
+						failed.Add(1)
+						continue
+					}
+					resp.Body.Close()
+					if !f.Fetched(resp.StatusCode) {
+						continue
+					}
+					if resp.StatusCode != http.StatusOK {
+						failed.Add(1)
+						continue
+					}
+					crawled.Add(1)
+					var links []Link
+					for j := 0; j < 10; j++ {
+						page := fmt.Sprintf("%s/%d/%d", ts.URL, link.Depth+1, j)
+						switch j % 3 {
+						case 0:
+							page += "/missing"
+						case 1:
+							page = fmt.Sprintf("%s/%d/%d", unreachable, link.Depth+1, j)
+						}
+						links = append(links, Link{URL: page, Depth: link.Depth + 1})
+					}
+					f.Enqueue(links, &wg)
+				case <-f.Done:
+					return
+				}
+			}
+		}()
+	}
+	f.Seed(ts.URL, &wg)
+	f.Start(&wg)
+
+	select {
+	case <-f.Done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("The crawl did not end once the page limit was reached")
+	}
+	wg.Wait()
+	close(output)
+
+	if count := crawled.Load(); count != 5 {
+		t.Errorf("Expected exactly 5 pages to be crawled, got %d", count)
+	}
+	if failed.Load() == 0 {
+		t.Error("Expected some of the pages to fail before the limit was reached")
+	}
+	limits := 0
+	for msg := range output {
+		if msg == "max-pages,5" {
+			limits++
+		}
+	}
+	if limits != 1 {
+		t.Errorf("Expected the page limit to be reported once, got %d", limits)
+	}
+}
+
+// Test that the links dropped for being beyond the depth limit or stale are
+// reported as skipped with their reasons, the depth limit only once.
+func Test_ReportSkipped(t *testing.T) {
+	f, output := newTestFronter()
+	f.MaxDepth = 1
+	f.TTL = time.Minute
+	f.ReportSkipped = true
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go f.cache(&wg)
+
+	queued := time.Now().Add(-2 * time.Minute)
+	f.Worklist <- []Link{
+		{URL: "https://example.com/deep", Depth: 2},
+		{URL: "https://example.com/deep", Depth: 2},
+		{URL: "https://example.com/old", Depth: 1, Queued: queued},
+		{URL: "https://example.com/fresh", Depth: 1},
+	}
+	receive(t, f)
+
+	close(f.Done)
+	wg.Wait()
+	close(output)
+
+	skipped := map[string]int{}
+	for msg := range output {
+		if strings.HasPrefix(msg, "skipped,") {
+			skipped[msg]++
+		}
+	}
+	expected := map[string]int{
+		"skipped,https://example.com/deep,max-depth": 1,
+		"skipped,https://example.com/old,stale":      1,
+	}
+	if len(skipped) != len(expected) {
+		t.Errorf("Expected the skipped records %v, got %v", expected, skipped)
+	}
+	for msg, count := range expected {
+		if skipped[msg] != count {
+			t.Errorf("Expected [%s] to be reported %d times, got %d", msg, count, skipped[msg])
+		}
+	}
+}
+
+// Pass the URLs a request was redirected through as fetched links and test
+// that they are recorded as visited without being fetched again, when they
+// are found on a later page, while the other links are still fetched.
+func Test_FetchedLinks(t *testing.T) {
+	f, _ := newTestFronter()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go f.cache(&wg)
+
+	f.Worklist <- []Link{
+		{URL: "https://example.com/old", Depth: 1, Fetched: true},
+		{URL: "https://example.com/moved", Depth: 1, Fetched: true},
+	}
+	f.Worklist <- []Link{
+		{URL: "https://example.com/old", Depth: 2},
+		{URL: "https://example.com/new", Depth: 2},
+		{URL: "https://example.com/moved", Depth: 2},
+	}
+	if link := receive(t, f); link.URL != "https://example.com/new" {
+		t.Errorf("Expected only the link that was not fetched to be passed on, got %v", link)
+	}
+	select {
+	case link := <-f.Unseen:
+		t.Errorf("The fetched link %v should not be fetched again", link)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(f.Done)
+	wg.Wait()
+
+	for _, link := range []string{"https://example.com/old", "https://example.com/moved"} {
+		if !f.Seen.Links[link] || f.Seen.Depth[link] != 1 {
+			t.Errorf("The fetched link %s should be visited at depth 1, got %v at depth %d", link, f.Seen.Links[link], f.Seen.Depth[link])
+		}
+	}
+}
+
+// Queue more links than are taken from the Unseen channel and test that a
+// worker can still record the status and look up the depth of the page it
+// fetched while the cache waits to pass the next link on.
+func Test_RecordWhileQueueing(t *testing.T) {
+	f, _ := newTestFronter()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go f.cache(&wg)
+
+	f.Worklist <- []Link{
+		{URL: "https://example.com/about", Depth: 1},
+		{URL: "https://example.com/blog", Depth: 1},
+	}
+	link := receive(t, f)
+
+	recorded := make(chan struct{})
+	go func() {
+		f.RecordStatus(link.URL, 200)
+		f.Depth(link.URL)
+		close(recorded)
+	}()
+	select {
+	case <-recorded:
+	case <-time.After(time.Second):
+		t.Fatal("Recording the status blocked while the next link was waiting to be queued")
+	}
+	receive(t, f)
+
+	close(f.Done)
+	wg.Wait()
+	if f.Seen.Status[link.URL] != 200 {
+		t.Errorf("The status of %s is %d, expected 200", link.URL, f.Seen.Status[link.URL])
+	}
+}
+
+// Report a link beyond the depth limit to an output that is not read and
+// test that a worker can still record the status of the page it fetched
+// while the cache waits to send the record.
+func Test_RecordWhileReporting(t *testing.T) {
+	output := make(chan string)
+	f := NewFronter(data.NewData(), output, make(chan struct{}))
+	f.MaxDepth = 1
+	f.ReportSkipped = true
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go f.cache(&wg)
+	f.Worklist <- []Link{{URL: "https://example.com/team", Depth: 2}}
+
+	recorded := make(chan struct{})
+	go func() {
+		f.RecordStatus("https://example.com/about", 200)
+		close(recorded)
+	}()
+	select {
+	case <-recorded:
+	case <-time.After(time.Second):
+		t.Fatal("Recording the status blocked while the skipped record was waiting to be sent")
+	}
+	select {
+	case msg := <-output:
+		if msg != "skipped,https://example.com/team,max-depth" {
+			t.Errorf("Unexpected output for the skipped link: %s", msg)
+		}
+	case <-time.After(time.Second):
+		t.Error("Timed out waiting for the skipped link to be reported")
+	}
+
+	close(f.Done)
+	wg.Wait()
+}
@@ -2,4 +2,14 @@ module linkcrawl
 
 go 1.22.0
 
-require golang.org/x/net v0.28.0
+require (
+	github.com/andybalholm/brotli v1.2.5
+	github.com/segmentio/kafka-go v0.4.47
+	golang.org/x/net v0.28.0
+)
+
+require (
+	github.com/klauspost/compress v1.15.9 // indirect
+	github.com/pierrec/lz4/v4 v4.1.15 // indirect
+	golang.org/x/text v0.17.0 // indirect
+)
@@ -0,0 +1,79 @@
+package health
+
+// The health package serves a /healthz endpoint for a crawl that is run as a
+// long running service. The crawl is healthy while it is making progress, it
+// is reported as stalled once the progress count has not advanced within the
+// stall window.
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Checker tracks the progress of the crawl, Progress returns a count that
+// advances as the crawl makes progress, such as the number of pages fetched.
+type Checker struct {
+	Progress func() int
+	Window   time.Duration
+	Now      func() time.Time
+
+	mu      sync.Mutex
+	last    int
+	changed time.Time
+}
+
+// NewChecker returns a pointer to a health.Checker that reports the crawl as
+// stalled when progress has not advanced for the window.
+func NewChecker(progress func() int, window time.Duration) *Checker {
+	return &Checker{
+		Progress: progress,
+		Window:   window,
+		Now:      time.Now,
+		last:     progress(),
+		changed:  time.Now(),
+	}
+}
+
+// Stalled reports whether the progress count has not advanced within the
+// window, along with how long it has been since it last advanced.
+func (c *Checker) Stalled() (bool, time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.Now()
+	if count := c.Progress(); count != c.last {
+		c.last = count
+		c.changed = now
+	}
+	since := now.Sub(c.changed)
+	return since > c.Window, since
+}
+
+// ServeHTTP responds with 200 while the crawl is making progress and 503
+// once it has stalled.
+func (c *Checker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	stalled, since := c.Stalled()
+	if stalled {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintf(w, "stalled, no progress for %s\n", since.Round(time.Second))
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+// Serve listens on addr and serves the checker at /healthz in the
+// background, the Addr of the server is the address that is listened on.
+// An error is returned if the address cannot be listened on.
+func Serve(addr string, checker *Checker) (*http.Server, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("Error starting the health server: %v", err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/healthz", checker)
+	server := &http.Server{Addr: listener.Addr().String(), Handler: mux}
+	go server.Serve(listener)
+	return server, nil
+}
@@ -0,0 +1,61 @@
+package health
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Advance a fake clock while the progress count stays the same and test that
+// the endpoint returns 200 within the stall window, 503 once it has passed
+// and 200 again when the crawl makes progress.
+func Test_Stalled(t *testing.T) {
+	now := time.Now()
+	visited := 10
+	checker := NewChecker(func() int { return visited }, time.Minute)
+	checker.Now = func() time.Time { return now }
+
+	status := func() int {
+		recorder := httptest.NewRecorder()
+		checker.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+		return recorder.Code
+	}
+
+	now = now.Add(30 * time.Second)
+	if code := status(); code != http.StatusOK {
+		t.Errorf("Expected 200 within the stall window, got %d", code)
+	}
+
+	now = now.Add(31 * time.Second)
+	if code := status(); code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 for a stalled crawl, got %d", code)
+	}
+
+	visited++
+	if code := status(); code != http.StatusOK {
+		t.Errorf("Expected 200 once the crawl has made progress, got %d", code)
+	}
+}
+
+// Serve the checker on a random port and test that it answers at /healthz
+func Test_Serve(t *testing.T) {
+	checker := NewChecker(func() int { return 0 }, time.Minute)
+	server, err := Serve("127.0.0.1:0", checker)
+	if err != nil {
+		t.Fatalf("Failed to start the health server: %v", err)
+	}
+	defer server.Close()
+
+	resp, err := http.Get(fmt.Sprintf("http://%s/healthz", server.Addr))
+	if err != nil {
+		t.Fatalf("Failed to get the health endpoint: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != "ok\n" {
+		t.Errorf("Unexpected health response %d [%s]", resp.StatusCode, body)
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+)
+
+// stopOnInterrupt stops the crawl on the first SIGINT or SIGTERM so that the
+// workers drain and the results gathered so far are printed, a second signal
+// exits straight away. The signals are handled until the program exits, as
+// the workers can take a while to drain, and interrupted records whether the
+// crawl was stopped by one.
+func stopOnInterrupt(stop func(), interrupted *atomic.Bool) {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	for sig := range signals {
+		if interrupted.Swap(true) {
+			fmt.Fprintf(os.Stderr, "Error, interrupted again by %v, exiting without the partial results\n", sig)
+			os.Exit(130)
+		}
+		fmt.Fprintf(os.Stderr, "Interrupted by %v, stopping the crawl to print the partial results, interrupt again to exit immediately\n", sig)
+		stop()
+	}
+}
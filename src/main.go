@@ -8,91 +8,172 @@ package main
 // to the one that has been supplied.
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
+	"linkcrawl/config"
 	"linkcrawl/crawler"
 	"linkcrawl/data"
 	"linkcrawl/fetcher"
+	"linkcrawl/fronter"
+	"linkcrawl/health"
+	"linkcrawl/random"
+	"linkcrawl/sink"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // Create a goroutine to print the output from the crawler object.
 // Note: this will receive data from multiple goroutines.
-func stream(output <-chan string, errors <-chan error, done <-chan struct{}, wg *sync.WaitGroup) {
+// It drains both channels until they are closed so that no records are lost
+// from goroutines that are still finishing when the crawl is complete.
+// The stop function, when set, is called with each error that is received.
+// The records are written to out, which is stdout unless -output is set.
+func stream(out io.Writer, output <-chan string, results <-chan crawler.Result, errors <-chan error, stats *data.Stats, stop func(error), wg *sync.WaitGroup) {
 	defer wg.Done()
-	for {
+	for output != nil || results != nil || errors != nil {
 		select {
-		case msg := <-output:
-			fmt.Printf("data,%v\n", msg)
-		case err := <-errors:
-			fmt.Printf("error,%v\n", err)
-		case <-done:
-			return
+		case msg, ok := <-output:
+			if !ok {
+				output = nil
+				continue
+			}
+			fmt.Fprintf(out, "data,%v\n", msg)
+		case result, ok := <-results:
+			if !ok {
+				results = nil
+				continue
+			}
+			writeResult(out, result)
+		case err, ok := <-errors:
+			if !ok {
+				errors = nil
+				continue
+			}
+			stats.RecordError()
+			fmt.Fprintf(out, "error,%v\n", err)
+			if stop != nil {
+				stop(err)
+			}
 		}
 	}
 }
 
-// Take the supplied URL as the `seed` and enqueue it into the worklist
-// channel for processing.
-func seed(domain *string, worklist chan<- []string, wg *sync.WaitGroup) {
-	defer wg.Done()
-	worklist <- []string{*domain}
+// resultWriter is implemented by the writers that encode the links found on
+// the pages themselves, such as sink.JSONLines.
+type resultWriter interface {
+	WriteResult(result crawler.Result) error
 }
 
-func worker(c *crawler.Crawler, unseenUrls <-chan string, worklist chan<- []string, fetcher *fetcher.Fetcher, done chan struct{}, wg *sync.WaitGroup) {
+// writeResult writes a link found on a page to out, as a data record in its
+// CSV form unless out encodes it itself.
+func writeResult(out io.Writer, result crawler.Result) {
+	if w, ok := out.(resultWriter); ok {
+		w.WriteResult(result)
+		return
+	}
+	fmt.Fprintf(out, "data,%v\n", result)
+}
+
+func worker(c *crawler.Crawler, f *fronter.Fronter, fetcher *fetcher.Fetcher, graph *data.Graph, stats *data.Stats, wg *sync.WaitGroup) {
 	defer wg.Done()
 	for {
 		select {
-		case link, ok := <-unseenUrls:
+		case link, ok := <-f.Unseen:
 			if !ok {
 				return
 			}
 
-			fetcher.NewRequest(link)
+			fetcher.NewRequest(link.URL)
 
 			select {
 			case resp := <-fetcher.Fetch:
+				if resp == nil {
+					// The request was not fetched, i.e. robots.txt disallows it
+					continue
+				}
+				f.Progress()
+				if !f.Fetched(resp.StatusCode) {
+					// Fetched once -max-pages pages have been, it is not crawled
+					resp.Body.Close()
+					continue
+				}
+				f.RecordStatus(resp.Request.URL.String(), resp.StatusCode)
+				if requested := requestedUrl(resp); requested != resp.Request.URL.String() {
+					f.RecordStatus(requested, resp.StatusCode)
+				}
+				if stats.RecordStatus(resp.StatusCode) {
+					checkpoint(c, f, stats)
+				}
+				// The response may be for another worker's request, so the
+				// depth is looked up from the URL that was requested.
+				depth := f.Depth(requestedUrl(resp))
+				if redirected := redirectedUrls(resp, depth); len(redirected) > 0 {
+					f.Enqueue(redirected, wg)
+				}
 				foundLinks, err := c.ProcessResponse(resp)
 				if err != nil {
+					// Sent even after Done so the last errors are printed,
+					// the stream drains the channel until it is closed
 					fetcher.Err <- err
 				} else {
-					wg.Add(1)
-					go func() {
-						defer wg.Done()
-						worklist <- foundLinks
-					}()
+					graph.AddEdges(resp.Request.URL.String(), foundLinks)
+					for _, found := range foundLinks {
+						fetcher.Prefetch(found)
+					}
+					links := make([]fronter.Link, 0, len(foundLinks))
+					for _, found := range foundLinks {
+						links = append(links, fronter.Link{URL: found, Depth: depth + 1})
+					}
+					f.Enqueue(links, wg)
 				}
-			case <-done:
+			case <-f.Done:
 				return
 			}
-		case <-done:
+		case <-f.Done:
 			return
 		}
 	}
 }
 
-// Retrieve the data that is returned from the crawler.Crawl method and
-// process the links that are returned storing them and their visited state
-// in a thread safe data.Data.Links structure.
-// Unseen URLs are written to the unseenUrls channel
-func cache(visited *data.Data, unseenUrls chan<- string, worklist <-chan []string, done <-chan struct{}, wg *sync.WaitGroup) {
+// checkpoint emits a checkpoint record with the counters of the crawl so far
+func checkpoint(c *crawler.Crawler, f *fronter.Fronter, stats *data.Stats) {
+	f.Seen.Mu.Lock()
+	discovered := len(f.Seen.Links)
+	f.Seen.Mu.Unlock()
+	encoded, err := json.Marshal(stats.Checkpoint(discovered, c.BytesRead()))
+	if err != nil {
+		c.Err <- fmt.Errorf("Error encoding the checkpoint event: %v", err)
+		return
+	}
+	c.Out <- fmt.Sprintf("checkpoint,%s", encoded)
+}
+
+// saveState saves the links found so far to the state file every interval
+// until done is closed, so a crawl that is killed can be resumed from its
+// last save. Zero only saves the state once the crawl completes.
+func saveState(visited *data.Data, path string, every time.Duration, done <-chan struct{}, errors chan<- error, wg *sync.WaitGroup) {
 	defer wg.Done()
+	if every <= 0 {
+		return
+	}
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
 	for {
 		select {
-		case list, ok := <-worklist:
-			if !ok {
-				return
-			}
-			for _, link := range list {
-				visited.Mu.Lock()
-				if !visited.Links[link] {
-					visited.Links[link] = true
-					unseenUrls <- link
+		case <-ticker.C:
+			if err := visited.Save(path); err != nil {
+				select {
+				case errors <- err:
+				case <-done:
+					return
 				}
-				visited.Mu.Unlock()
 			}
 		case <-done:
 			return
@@ -100,79 +181,38 @@ func cache(visited *data.Data, unseenUrls chan<- string, worklist <-chan []strin
 	}
 }
 
-// Monitor for completion
-// The number of URLs (keys) in the data.Links map indicate the number of
-// URLs that have been found. Using this structure along with checking the
-// size of the worker queue gives indication when the program can exit
-// Three chances are given with a pregnant pause in between to make sure that
-// the crawling really is finished and that no more data will be written to
-// to the channels once they are closed.
-func monitor(visited *data.Data, unseenUrls chan string, worklist chan []string, done chan struct{}, wg *sync.WaitGroup) {
-	defer wg.Done()
-	chances := 0
-	lastSeen := 0
-	lastVisited := 0
-	for {
-		time.Sleep(1 * time.Second)
-		var seen []string
-
-		// Lock data structure from further R/W before processing its contents
-		visited.Mu.Lock()
-
-		// Store the URLs that have been crawled
-		for url, been := range visited.Links {
-			if been {
-				seen = append(seen, url)
-			}
-		}
-
-		// Reset the chances counter if the crawling starts again
-		if len(seen) != len(visited.Links) {
-			chances = 0
-		}
-
-		if len(seen) == len(visited.Links) && len(seen) == lastSeen && len(visited.Links) == lastVisited && len(visited.Links) > 0 {
-			chances++
-			time.Sleep(3 * time.Second)
-		}
-
-		// Unlock the data structure
-		visited.Mu.Unlock()
-
-		// The conditions to exit the program have been met, stop all running
-		// goroutines and exit
-		if chances == 3 {
-			close(done)
-			close(worklist)
-			close(unseenUrls)
-			return
-		}
-
-		// Update the last seen and last visited variables use in the next loop
-		// iteration
-		lastSeen = len(seen)
-		lastVisited = len(visited.Links)
-
-		// Reset the seen list so that on the next loop iteration it is filled
-		// with a fresh list of URLs that have now been scraped
-		seen = seen[:0]
+// redirectedUrls returns the URLs a request was redirected through to the
+// response, marked as fetched at the depth of the request so they are
+// recorded as visited and not requested again when they are found on a page.
+func redirectedUrls(resp *http.Response, depth int) []fronter.Link {
+	var links []fronter.Link
+	for _, hop := range crawler.RedirectChain(resp)[1:] {
+		links = append(links, fronter.Link{URL: hop.Request.URL.String(), Depth: depth, Fetched: true})
 	}
+	return links
+}
+
+// requestedUrl returns the URL that was originally requested for a response
+// by following any redirects back to the first request.
+func requestedUrl(resp *http.Response) string {
+	return crawler.RedirectChain(resp)[0].Request.URL.String()
 }
 
 // main function - This performs the following steps
 //   - Parses and checks for user input to get the domain
 //   - Creates channels for logging output and errors
 //   - Initialises a new Crawler object from the crawler package
-//   - Create a worklist channel to receive all the URLs from the crawling
-//   - Create an unseenUrls channel for non-scraped URLs to be passed to while
-//     processing the data from the worklist channel
+//   - Initialise a new Data object from the data package, it uses a mutex so
+//     it can be locked and unlocked to protect it from concurrent R/W access
+//   - Initialise a new Fronter object from the fronter package, it owns the
+//     worklist channel that receives all the URLs from the crawling and the
+//     unseen channel that non-scraped URLs are passed to
 //   - spawn a goroutine to receive logging and errors
 //   - spawn a goroutine to write the starting(seed) URL to the worklist chan
 //   - spawn a 20 worker goroutines to handle concurrent URL Crawling
-//   - Initialise a new Data object from the data package, it uses a mutex so
-//     it can be locked and unlocked to protect it from concurrent R/W access
-//   - spawn a goroutine to Process the output from the calls to crawler.Crawl
-//     method, writing any unseen URLs to the unseenUrls channel
+//   - spawn a goroutine to Process the output from the calls to
+//     crawler.ProcessResponse method, writing any unseen URLs within the depth
+//     limit to the unseen channel
 //   - Monitor the status of the crawling and when no new URLs are being
 //     scraped, close the channels, signal to the waitgroup that the processing
 //     is done and exit.
@@ -193,45 +233,364 @@ func main() {
 			}
 		}()
 	*/
-	domain := flag.String("domain", "", "The domain to crawl")
-	flag.Parse()
+	cfg, err := config.Parse(flag.CommandLine, os.Args[1:])
 
-	if *domain == "" {
-		fmt.Printf("Error, please pass a domain using -domain https://domain.com")
+	// A config with invalid options is still returned so that -validate can
+	// report every problem rather than only the first
+	if cfg != nil && cfg.DryRun {
+		problems := cfg.Check()
+		for _, problem := range problems {
+			fmt.Println(problem)
+		}
+		if len(problems) > 0 {
+			fmt.Printf("Error, found %d problems with the config\n", len(problems))
+			os.Exit(1)
+		}
+		fmt.Println("The config is valid")
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	// The baseline is loaded before the crawl so a bad file fails fast, the
+	// snapshot may be saved over it on completion
+	baseline, err := cfg.LoadBaseline()
+	if err != nil {
+		fmt.Println(err)
 		os.Exit(1)
 	}
 
+	// A re-check requests the broken URLs of an earlier crawl, it has no seed
+	rechecks, err := cfg.Rechecks()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if cfg.Domain == "" && cfg.Recheck == "" {
+		fmt.Printf("Error, please pass a domain using -domain https://domain.com or CRAWL_DOMAIN")
+		os.Exit(1)
+	}
+
+	// The seed is recorded with the config in the done record so a run with
+	// a time based seed can still be repeated.
+	if cfg.Seed == 0 {
+		cfg.Seed = time.Now().UnixNano()
+	}
+	random.Seed(cfg.Seed)
+	if cfg.RunID == "" {
+		cfg.RunID = config.NewRunID()
+	}
+
 	var wg sync.WaitGroup
 	visited := data.NewData()
-	worklist := make(chan []string) // Data returned from crawling
-	unseenUrls := make(chan string) // URLs to scrape
-	done := make(chan struct{})     // Signal go routines to exit
-	output := make(chan string)     // Channel to send output to
-	errors := make(chan error)      // Channel to send errors to
+	if cfg.Resume != "" {
+		// The links of the interrupted crawl are loaded so that the pages
+		// it fetched are skipped, a missing state file starts afresh
+		if err := visited.Load(cfg.Resume); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+	graph := data.NewGraph() // Edges between the crawled pages
+	stats := data.NewStats() // Counters for the completion event
+	stats.CheckpointEvery = cfg.CheckpointEvery
+	stats.RunID = cfg.RunID
+	done := make(chan struct{}) // Signal go routines to exit
+	errors := make(chan error)  // Channel to send errors to
 	fetch := make(chan *http.Response)
 
+	// The output records are buffered so that a slow consumer does not hold
+	// up the crawl, the relay blocks or drops records when it is full.
+	records, err := cfg.Relay()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	output := records.In // Channel to send output to
+
+	// Initialise the crawl frontier from the fronter package.
+	f := cfg.Fronter(visited, output, done)
+
 	// Initialise a new web crawler from the crawler package.
-	c := crawler.NewCrawler(*domain, output, errors, fetch)
+	c := cfg.Crawler(output, errors, fetch)
+	c.Results = records.Results // The links found are written as a crawler.Result
 
-	fetcher := fetcher.NewFetcher(5, 3, 5*time.Second, output, errors, fetch, done)
+	search := cfg.Sink()
+	if search != nil {
+		c.Sink = search
+	}
+
+	// The pages are published to Kafka as well as indexed when both are set
+	publish, err := cfg.Kafka()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if publish != nil {
+		if c.Sink != nil {
+			c.Sink = sink.Multi{c.Sink, publish}
+		} else {
+			c.Sink = publish
+		}
+	}
+
+	archive, err := cfg.Archive()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if archive != nil {
+		c.Archive = archive
+	}
+
+	// The records are written to stdout unless an output file is configured,
+	// with -append an existing file is added to rather than truncated.
+	var out io.Writer = os.Stdout
+	file, err := cfg.Writer()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if file != nil {
+		defer file.Close()
+		out = file
+	}
+	// With -socket the records are encoded as NDJSON for the socket clients,
+	// -format json encodes them the same way for stdout or the output file.
+	socket, err := cfg.Listen()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if socket != nil {
+		defer socket.Close()
+		out = sink.NewJSONLines(socket)
+	} else if cfg.Format == "json" {
+		out = sink.NewJSONLines(out)
+	}
+
+	fetcher, err := cfg.Fetcher(output, errors, fetch, done)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	fetcher.Credentials = c.Credentials // Userinfo stripped from the links authenticates the requests
+	c.Now = fetcher.Clock.Now           // The expiry dates follow the fetcher's clock
+
+	fetcher.OnMaxBytes = f.Stop // The byte budget ends the crawl like -strict
 
 	wg.Add(1)
 	go fetcher.StartFetching(&wg)
+	wg.Add(1)
+	go resizeOnSignal(fetcher, done, &wg)
+
+	// An interrupt stops the crawl like -strict so the monitor closes the
+	// done channel, a second interrupt exits without waiting.
+	var interrupted atomic.Bool
+	go stopOnInterrupt(f.Stop, &interrupted)
 
 	// Spawn the goroutines to form the worker pool.
 	for i := 0; i < 20; i++ {
 		wg.Add(1)
-		go worker(c, unseenUrls, worklist, fetcher, done, &wg)
+		go worker(c, f, fetcher, graph, stats, &wg)
 	}
 
-	wg.Add(4)
-	go stream(output, errors, done, &wg)
-	go seed(domain, worklist, &wg)
-	go cache(visited, unseenUrls, worklist, done, &wg)
-	go monitor(visited, unseenUrls, worklist, done, &wg)
+	// In strict mode the first error stops the crawl, the monitor then
+	// closes the done channel so everything shuts down in the normal order.
+	var failed error
+	var stop func(error)
+	if cfg.Strict {
+		stop = func(err error) {
+			if failed == nil {
+				failed = err
+				f.Stop()
+			}
+		}
+	}
+
+	// The health endpoint reports the crawl as stalled when no page has been
+	// fetched within the stall window.
+	checker := cfg.Health(func() int {
+		stats.Mu.Lock()
+		defer stats.Mu.Unlock()
+		return stats.Fetched
+	})
+	if checker != nil {
+		server, err := health.Serve(cfg.HealthAddr, checker)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		defer server.Close()
+	}
+
+	var streaming sync.WaitGroup
+	records.Start(&streaming)
+	streaming.Add(1)
+	go stream(out, records.Out, records.ResultsOut, errors, stats, stop, &streaming)
+	if cfg.Recheck != "" {
+		// Nothing is seeded so the monitor would wait for links forever, the
+		// crawl is stopped once every URL has been re-checked
+		wg.Add(1)
+		go func() {
+			fetcher.Recheck(rechecks, &wg)
+			f.Stop()
+		}()
+	} else {
+		// The robots.txt is loaded before the seed is requested, once the
+		// stream is running to report a failure
+		if !cfg.IgnoreRobots {
+			fetcher.LoadRobots(c.Domain)
+		}
+		if !f.Resume(&wg) {
+			f.Seed(c.Seed(), &wg)
+		}
+	}
+	if cfg.Resume != "" {
+		wg.Add(1)
+		go saveState(visited, cfg.Resume, time.Duration(cfg.ResumeEvery), done, errors, &wg)
+	}
+	if cfg.ProbeWellKnown && cfg.Recheck == "" {
+		wg.Add(1)
+		go fetcher.Probe(c.Domain, cfg.ProbePaths, &wg)
+	}
+	f.Start(&wg)
 
 	wg.Wait() // Wait for the processing to complete
 	close(fetch)
 	close(errors)
 	close(output)
+	close(records.Results)
+	streaming.Wait() // Wait for the remaining output to be printed
+	stats.RecordDropped(int(records.Dropped()))
+	stats.RecordBytes(c.BytesRead())
+
+	// The state is saved once more so the resumed crawl has every page
+	if cfg.Resume != "" {
+		if err := visited.Save(cfg.Resume); err != nil {
+			fmt.Fprintf(out, "error,%v\n", err)
+		}
+	}
+
+	if search != nil {
+		if err := search.Close(); err != nil {
+			fmt.Fprintf(out, "error,%v\n", err)
+		}
+	}
+	if publish != nil {
+		if err := publish.Close(); err != nil {
+			fmt.Fprintf(out, "error,%v\n", err)
+		}
+	}
+	if archive != nil {
+		if err := archive.Close(); err != nil {
+			fmt.Fprintf(out, "error,%v\n", err)
+		}
+	}
+
+	// The hosts and paths output modes print the unique set collected during
+	// the crawl in place of the link records.
+	switch cfg.OutputMode {
+	case "hosts":
+		for _, host := range visited.Hosts() {
+			fmt.Fprintf(out, "host,%s\n", host)
+		}
+	case "paths":
+		for _, path := range visited.Paths() {
+			fmt.Fprintf(out, "path,%s\n", path)
+		}
+	}
+
+	// The normalization report groups the links by the page they were found
+	// on, a dropped link has an empty cleaned URL and the reason it was dropped
+	for _, page := range c.Normalizations() {
+		for _, n := range page.Links {
+			fmt.Fprintf(out, "normalization,%s,%s,%s,%s\n", page.Page, n.Raw, n.Cleaned, n.Reason)
+		}
+	}
+
+	// The canonical report maps every requested URL to where it landed, the
+	// status chain is the status of each hop ending with the final status
+	for _, canonical := range c.Canonicals() {
+		chain := make([]string, len(canonical.Statuses))
+		for i, status := range canonical.Statuses {
+			chain[i] = strconv.Itoa(status)
+		}
+		fmt.Fprintf(out, "canonical,%s,%s,%s\n", canonical.Requested, canonical.Final, strings.Join(chain, ">"))
+	}
+
+	// The fragment check cross references the links with the anchors of the
+	// pages that were parsed once every page has been crawled
+	for _, link := range c.MissingFragments() {
+		fmt.Fprintf(out, "missing-fragment,%s,%s#%s\n", link.Page, link.Target, link.Fragment)
+	}
+
+	if cfg.ReportPopular > 0 {
+		for _, p := range graph.Popular(cfg.ReportPopular) {
+			fmt.Fprintf(out, "popular,%d,%s\n", p.Count, p.URL)
+		}
+	}
+
+	for _, t := range c.LinkTypes() {
+		fmt.Fprintf(out, "link-type,%s,%d\n", t.Type, t.Count)
+	}
+
+	// The text is the last field as it may contain a comma
+	if cfg.ReportAnchorText > 0 {
+		for _, a := range c.AnchorTexts(cfg.ReportAnchorText) {
+			fmt.Fprintf(out, "anchor-text,%d,%s\n", a.Count, a.Text)
+		}
+	}
+
+	// The diff classifies every page against the baseline, a removed page is
+	// one that was not fetched with a 200 in this crawl
+	if baseline != nil {
+		for _, change := range c.Hashes.Compare(baseline) {
+			fmt.Fprintf(out, "diff,%s,%s\n", change.Status, change.URL)
+		}
+	}
+	if cfg.Snapshot != "" {
+		if err := c.Hashes.Save(cfg.Snapshot); err != nil {
+			fmt.Fprintf(out, "error,%v\n", err)
+		}
+	}
+
+	// The broken link summary goes to stderr so it can be kept apart from the
+	// output, its records are in the format read by -recheck
+	if cfg.ReportBroken {
+		broken := visited.Broken()
+		fmt.Fprintf(os.Stderr, "Found %d broken links\n", len(broken))
+		for _, link := range broken {
+			fmt.Fprintf(os.Stderr, "broken,%d,%s\n", link.Status, link.URL)
+		}
+	}
+
+	// Emit the structured completion event as the final record, it includes
+	// the configuration the crawl was run with.
+	visited.Mu.Lock()
+	discovered := len(visited.Links)
+	visited.Mu.Unlock()
+	if event := stats.Finish(discovered, cfg.Map()); event != nil {
+		encoded, err := json.Marshal(event)
+		if err != nil {
+			fmt.Fprintf(out, "error,Failed to encode the done event: %v\n", err)
+		} else {
+			fmt.Fprintf(out, "done,%s\n", encoded)
+		}
+		// The summary is for reading so it is kept out of the output
+		if cfg.Summary {
+			fmt.Fprint(os.Stderr, event.Summary())
+		}
+	}
+
+	if failed != nil {
+		fmt.Fprintf(os.Stderr, "Error, stopped on the first error in strict mode: %v\n", failed)
+		os.Exit(1)
+	}
+	if interrupted.Load() {
+		os.Exit(130)
+	}
 }
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// runMainEnv is set in the environment of the test binary when it is run as
+// the crawler by runCrawl.
+const runMainEnv = "LINKCRAWL_TEST_MAIN"
+
+// TestMain runs main in place of the tests when the test binary is started
+// by runCrawl, so the crawl can be tested end to end with its exit code.
+func TestMain(m *testing.M) {
+	if os.Getenv(runMainEnv) == "1" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// runCrawl runs the crawler with the arguments in a child process and
+// returns what it wrote to stdout and stderr and its exit code, failing the
+// test if it does not exit in time.
+func runCrawl(t *testing.T, args ...string) (string, string, int) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, os.Args[0], args...)
+	cmd.Env = append(os.Environ(), runMainEnv+"=1")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if ctx.Err() != nil {
+		t.Fatalf("The crawl with %v did not exit, the output was:\n%s%s", args, stdout.String(), stderr.String())
+	}
+	var exit *exec.ExitError
+	if errors.As(err, &exit) {
+		return stdout.String(), stderr.String(), exit.ExitCode()
+	}
+	if err != nil {
+		t.Fatalf("Failed to run the crawl: %v", err)
+	}
+	return stdout.String(), stderr.String(), 0
+}
+
+// Re-check the broken records of an earlier crawl against a test server and
+// test that the crawl exits once every URL has been re-checked, nothing is
+// seeded so the crawl would otherwise wait for links until it is killed.
+func Test_Recheck(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, "fixed")
+	}))
+	defer ts.Close()
+
+	report := filepath.Join(t.TempDir(), "broken.csv")
+	lines := fmt.Sprintf("broken,404,%s/fixed\nbroken,404,%s/missing\n", ts.URL, ts.URL)
+	if err := os.WriteFile(report, []byte(lines), 0644); err != nil {
+		t.Fatalf("Failed to write the report: %v", err)
+	}
+
+	stdout, stderr, code := runCrawl(t, "-recheck", report)
+	if code != 0 {
+		t.Fatalf("The re-check exited with %d, expected 0: %s", code, stderr)
+	}
+	for _, record := range []string{
+		fmt.Sprintf("fixed,%s/fixed,200", ts.URL),
+		fmt.Sprintf("still-broken,%s/missing,404", ts.URL),
+	} {
+		if !strings.Contains(stdout, record+"\n") {
+			t.Errorf("Expected the record %s in the output:\n%s", record, stdout)
+		}
+	}
+}
+
+// Spawn a test server whose seed links to a page that cannot be crawled and
+// to a long chain of slow pages, crawl it in strict mode and test that the
+// crawl stops on the error with exit code 1 rather than crawling the chain.
+func Test_Strict(t *testing.T) {
+	var mu sync.Mutex
+	requested := map[string]bool{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requested[r.URL.Path] = true
+		mu.Unlock()
+		switch {
+		case r.URL.Path == "/":
+			fmt.Fprint(w, `<html><body><a href="/bad">bad</a><a href="/chain/1">chain</a></body></html>`)
+		case r.URL.Path == "/bad":
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{}`)
+		case strings.HasPrefix(r.URL.Path, "/chain/"):
+			time.Sleep(50 * time.Millisecond)
+			var n int
+			fmt.Sscanf(r.URL.Path, "/chain/%d", &n)
+			fmt.Fprintf(w, `<html><body><a href="/chain/%d">next</a></body></html>`, n+1)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	stdout, stderr, code := runCrawl(t, "-domain", ts.URL, "-strict")
+	if code != 1 {
+		t.Fatalf("The strict crawl exited with %d, expected 1: %s", code, stderr)
+	}
+	if !strings.Contains(stderr, "stopped on the first error in strict mode") || !strings.Contains(stderr, ts.URL+"/bad") {
+		t.Errorf("Expected the error for /bad to stop the crawl, got:\n%s", stderr)
+	}
+	if !strings.Contains(stdout, "error,") {
+		t.Errorf("Expected the error record in the output:\n%s", stdout)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if requested["/chain/20"] {
+		t.Errorf("The crawl went on down the chain after the error")
+	}
+}
+
+// Crawl a test server with a page that links to many others and test that
+// the done event is emitted exactly once, as the last record after every
+// data record has been written, with the counts of the crawl.
+func Test_DoneEvent(t *testing.T) {
+	const pages = 40
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			fmt.Fprint(w, `<html><body><a href="/">home</a></body></html>`)
+			return
+		}
+		fmt.Fprint(w, `<html><body>`)
+		for i := 0; i < pages; i++ {
+			fmt.Fprintf(w, `<a href="/page/%d">page</a>`, i)
+		}
+		fmt.Fprint(w, `</body></html>`)
+	}))
+	defer ts.Close()
+
+	stdout, stderr, code := runCrawl(t, "-domain", ts.URL, "-ignore-robots")
+	if code != 0 {
+		t.Fatalf("The crawl exited with %d, expected 0: %s", code, stderr)
+	}
+	lines := strings.Split(strings.TrimSuffix(stdout, "\n"), "\n")
+	var done []string
+	for _, line := range lines {
+		if strings.HasPrefix(line, "done,") {
+			done = append(done, line)
+		}
+	}
+	if len(done) != 1 {
+		t.Fatalf("Expected the done event exactly once, got %d:\n%s", len(done), stdout)
+	}
+	if last := lines[len(lines)-1]; last != done[0] {
+		t.Errorf("Expected the done event as the last record, got %s", last)
+	}
+
+	var event struct {
+		Fetched int `json:"fetched"`
+	}
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(done[0], "done,")), &event); err != nil {
+		t.Fatalf("Failed to decode the done event: %v", err)
+	}
+	if event.Fetched != pages+1 {
+		t.Errorf("The done event counted %d pages fetched, expected %d", event.Fetched, pages+1)
+	}
+	// Each page links back to the seed so every record is in the output
+	for i := 0; i < pages; i++ {
+		if record := fmt.Sprintf("data,200,%s/page/%d,%s", ts.URL, i, ts.URL); !strings.Contains(stdout, record+"\n") {
+			t.Errorf("Expected the record %s before the done event", record)
+		}
+	}
+}
+
+// Spawn a test server whose seed links to more pages disallowed by its
+// robots.txt than there are workers, and test that the crawl still finishes
+// with the allowed pages fetched rather than waiting on the skipped ones.
+func Test_RobotsDisallowed(t *testing.T) {
+	const disallowed = 30
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/robots.txt":
+			fmt.Fprint(w, "User-agent: *\nDisallow: /private\n")
+		case strings.HasPrefix(r.URL.Path, "/private"):
+			t.Errorf("The disallowed %s was requested", r.URL.Path)
+		case r.URL.Path == "/":
+			fmt.Fprint(w, `<html><body><a href="/public">public</a>`)
+			for i := 0; i < disallowed; i++ {
+				fmt.Fprintf(w, `<a href="/private/%d">private</a>`, i)
+			}
+			fmt.Fprint(w, `</body></html>`)
+		default:
+			fmt.Fprint(w, `<html><body></body></html>`)
+		}
+	}))
+	defer ts.Close()
+
+	stdout, stderr, code := runCrawl(t, "-domain", ts.URL)
+	if code != 0 {
+		t.Fatalf("The crawl exited with %d, expected 0: %s", code, stderr)
+	}
+	if count := strings.Count(stdout, ",disallowed\n"); count != disallowed {
+		t.Errorf("Expected %d disallowed records, got %d", disallowed, count)
+	}
+	if !strings.Contains(stdout, `"fetched":2`) {
+		t.Errorf("Expected the seed and the public page to be fetched:\n%s", stdout)
+	}
+}
+
+// Spawn a test server whose seed links to more pages than there are workers
+// that fail by closing the connection, and test that the crawl finishes once
+// the failed requests have run out of retries and the host is abandoned.
+func Test_FailedRequests(t *testing.T) {
+	const broken = 25
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/broken") {
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err == nil {
+				conn.Close()
+			}
+			return
+		}
+		fmt.Fprint(w, `<html><body>`)
+		for i := 0; i < broken; i++ {
+			fmt.Fprintf(w, `<a href="/broken/%d">broken</a>`, i)
+		}
+		fmt.Fprint(w, `</body></html>`)
+	}))
+	defer ts.Close()
+
+	stdout, stderr, code := runCrawl(t, "-domain", ts.URL, "-ignore-robots", "-max-errors-per-host", "1")
+	if code != 0 {
+		t.Fatalf("The crawl exited with %d, expected 0: %s", code, stderr)
+	}
+	if !strings.Contains(stdout, "data,abandoned,") || !strings.Contains(stdout, `"fetched":1`) {
+		t.Errorf("Expected the host to be abandoned after the seed was fetched:\n%s", stdout)
+	}
+}
+
+// Crawl a test server with every option that carries a credential and test
+// that none of the credentials are written to the done record.
+func Test_DoneSecrets(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><body></body></html>`)
+	}))
+	defer ts.Close()
+
+	secrets := map[string][]string{
+		"sekrit-header": {"-header", "Authorization: Bearer sekrit-header"},
+		"sekrit-cookie": {"-header", "Cookie: session=sekrit-cookie"},
+		"sekrit-pass":   {"-basic-user", "admin", "-basic-pass", "sekrit-pass"},
+		"sekrit-bearer": {"-bearer", "sekrit-bearer"},
+	}
+	// The server answers any request so it also serves as the proxy
+	proxy := strings.Replace(ts.URL, "http://", "http://crawl:sekrit-proxy@", 1)
+	secrets["sekrit-proxy"] = []string{"-proxy", proxy}
+	secrets["sekrit-domain"] = []string{"-domain", strings.Replace(ts.URL, "http://", "http://admin:sekrit-domain@", 1)}
+	args := []string{"-ignore-robots"}
+	for _, flags := range secrets {
+		args = append(args, flags...)
+	}
+	stdout, stderr, code := runCrawl(t, args...)
+	if code != 0 {
+		t.Fatalf("The crawl exited with %d, expected 0: %s", code, stderr)
+	}
+	var done string
+	for _, line := range strings.Split(stdout, "\n") {
+		if strings.HasPrefix(line, "done,") {
+			done = line
+		}
+	}
+	if len(done) == 0 {
+		t.Fatalf("Expected a done record in the output:\n%s", stdout)
+	}
+	for secret, flags := range secrets {
+		if strings.Contains(done, secret) {
+			t.Errorf("The done record holds the credential of %v: %s", flags, done)
+		}
+	}
+}
+
+// Spawn a test server whose seed links to a page with a comma in its path and
+// test that with -format json the link is written with its URL intact and
+// the time it was found.
+func Test_FormatJSON(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><body><a href="/a,b?x=1,2">comma</a></body></html>`)
+	}))
+	defer ts.Close()
+
+	start := time.Now()
+	stdout, stderr, code := runCrawl(t, "-domain", ts.URL, "-ignore-robots", "-format", "json")
+	if code != 0 {
+		t.Fatalf("The crawl exited with %d, expected 0: %s", code, stderr)
+	}
+	var found bool
+	for _, line := range strings.Split(strings.TrimSpace(stdout), "\n") {
+		var record struct {
+			Type   string          `json:"type"`
+			Record json.RawMessage `json:"record"`
+		}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("Expected a JSON record, got [%s]: %v", line, err)
+		}
+		var result struct {
+			FoundURL  string    `json:"found_url"`
+			Status    int       `json:"status"`
+			Timestamp time.Time `json:"timestamp"`
+		}
+		if record.Type != "data" || json.Unmarshal(record.Record, &result) != nil || !strings.HasSuffix(result.FoundURL, "/a,b?x=1,2") {
+			continue
+		}
+		found = true
+		if result.Status != 200 || result.Timestamp.Before(start.Add(-time.Second)) {
+			t.Errorf("Expected the link with the status 200 and the time it was found, got %s", record.Record)
+		}
+	}
+	if !found {
+		t.Errorf("Expected the link with the comma in the output:\n%s", stdout)
+	}
+}
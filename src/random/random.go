@@ -0,0 +1,40 @@
+package random
+
+// The random package holds the single source of randomness used by the
+// crawl, seeding it with the same value makes the randomized behaviour of
+// two runs the same so they can be reproduced when debugging and in tests.
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// The source is seeded from the time until Seed is called, the mutex is
+// needed as a rand.Rand is not safe for concurrent use.
+var (
+	mu     sync.Mutex
+	source = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// Seed replaces the source with one seeded with seed
+func Seed(seed int64) {
+	mu.Lock()
+	defer mu.Unlock()
+	source = rand.New(rand.NewSource(seed))
+}
+
+// Int63n returns a random number in the range [0, n), it panics if n <= 0
+func Int63n(n int64) int64 {
+	mu.Lock()
+	defer mu.Unlock()
+	return source.Int63n(n)
+}
+
+// Duration returns a random duration in the range [0, d), zero when d <= 0
+func Duration(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(Int63n(int64(d)))
+}
@@ -0,0 +1,37 @@
+package random
+
+import (
+	"testing"
+	"time"
+)
+
+// Test that seeding with the same value repeats the sequence of durations
+// and that a different seed gives a different sequence.
+func Test_Seed(t *testing.T) {
+	sequence := func(seed int64) []time.Duration {
+		Seed(seed)
+		var values []time.Duration
+		for i := 0; i < 5; i++ {
+			values = append(values, Duration(time.Second))
+		}
+		return values
+	}
+
+	first, second, other := sequence(42), sequence(42), sequence(7)
+	for i := range first {
+		if first[i] != second[i] {
+			t.Errorf("The sequences for the same seed differ: %v and %v", first, second)
+			break
+		}
+	}
+	same := true
+	for i := range first {
+		same = same && first[i] == other[i]
+	}
+	if same {
+		t.Errorf("The sequences for different seeds are the same: %v", first)
+	}
+	if Duration(0) != 0 {
+		t.Error("Expected a zero duration to stay zero")
+	}
+}
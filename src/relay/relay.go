@@ -0,0 +1,107 @@
+package relay
+
+// The relay package decouples the goroutines that write the output records
+// from the goroutine that prints them. Records written to the In channel are
+// forwarded onto a buffered Out channel, and the links found on the pages
+// written to the Results channel onto a buffered ResultsOut channel. When a
+// buffer is full the relay
+// either blocks until the consumer catches up or drops the record, so that
+// a slow consumer does not stall the crawl.
+
+import (
+	"fmt"
+	"linkcrawl/crawler"
+	"sync"
+	"sync/atomic"
+)
+
+// The overflow policies for a full buffer
+const (
+	Block = "block"
+	Drop  = "drop"
+)
+
+// Relay holds the channels the records are passed through along with the
+// policy used when the Out or ResultsOut buffer is full.
+type Relay struct {
+	In         chan string
+	Out        chan string
+	Results    chan crawler.Result
+	ResultsOut chan crawler.Result
+	Policy     string
+	// Filter, when set, is called with each link and only the links it
+	// allows are forwarded, the others are discarded without being counted.
+	Filter  func(result crawler.Result) bool
+	dropped atomic.Int64
+}
+
+// New returns a pointer to a relay.Relay with an Out buffer of size records,
+// an error is returned if the policy is not Block or Drop.
+func New(size int, policy string) (*Relay, error) {
+	if policy != Block && policy != Drop {
+		return nil, fmt.Errorf("Invalid output overflow policy %s, expected %s or %s", policy, Block, Drop)
+	}
+	if size < 0 {
+		size = 0
+	}
+	return &Relay{
+		In:         make(chan string),
+		Out:        make(chan string, size),
+		Results:    make(chan crawler.Result),
+		ResultsOut: make(chan crawler.Result, size),
+		Policy:     policy,
+	}, nil
+}
+
+// Start forwards the records from In to Out and the links from Results to
+// ResultsOut until both In and Results are closed, Out and ResultsOut are
+// then closed once the records that were accepted have been forwarded.
+func (r *Relay) Start(wg *sync.WaitGroup) {
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(r.Out)
+		defer close(r.ResultsOut)
+		in, results := r.In, r.Results
+		for in != nil || results != nil {
+			select {
+			case msg, ok := <-in:
+				if !ok {
+					in = nil
+					continue
+				}
+				if r.Policy == Block {
+					r.Out <- msg
+					continue
+				}
+				select {
+				case r.Out <- msg:
+				default:
+					r.dropped.Add(1)
+				}
+			case result, ok := <-results:
+				if !ok {
+					results = nil
+					continue
+				}
+				if r.Filter != nil && !r.Filter(result) {
+					continue
+				}
+				if r.Policy == Block {
+					r.ResultsOut <- result
+					continue
+				}
+				select {
+				case r.ResultsOut <- result:
+				default:
+					r.dropped.Add(1)
+				}
+			}
+		}
+	}()
+}
+
+// Dropped returns the number of records dropped because the buffer was full
+func (r *Relay) Dropped() int64 {
+	return r.dropped.Load()
+}
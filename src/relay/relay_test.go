@@ -0,0 +1,163 @@
+package relay
+
+import (
+	"fmt"
+	"linkcrawl/crawler"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// consume reads the Out channel slowly until it is closed, returning the
+// records that were received.
+func consume(r *Relay, delay time.Duration) <-chan []string {
+	received := make(chan []string, 1)
+	go func() {
+		var records []string
+		for msg := range r.Out {
+			time.Sleep(delay)
+			records = append(records, msg)
+		}
+		received <- records
+	}()
+	return received
+}
+
+// Write records faster than a slow consumer reads them and test that in
+// drop mode the writer is never held up, while the records that do not fit
+// in the buffer are counted as dropped.
+func Test_Drop(t *testing.T) {
+	r, err := New(5, Drop)
+	if err != nil {
+		t.Fatalf("Failed to create the relay: %v", err)
+	}
+	var wg sync.WaitGroup
+	r.Start(&wg)
+	received := consume(r, 20*time.Millisecond)
+
+	start := time.Now()
+	for i := 0; i < 100; i++ {
+		r.In <- "record"
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Writing to a full relay in drop mode blocked for %v", elapsed)
+	}
+	close(r.In)
+	close(r.Results)
+	wg.Wait()
+
+	records := <-received
+	if len(records) == 0 || len(records) == 100 {
+		t.Errorf("Expected some but not all of the records to be received, got %d", len(records))
+	}
+	if int64(len(records))+r.Dropped() != 100 {
+		t.Errorf("Received %d and dropped %d records, expected them to total 100", len(records), r.Dropped())
+	}
+}
+
+// Test that in block mode every record reaches the slow consumer in order
+// and none are dropped.
+func Test_Block(t *testing.T) {
+	r, err := New(2, Block)
+	if err != nil {
+		t.Fatalf("Failed to create the relay: %v", err)
+	}
+	var wg sync.WaitGroup
+	r.Start(&wg)
+	received := consume(r, 5*time.Millisecond)
+
+	expected := []string{"a", "b", "c", "d", "e", "f", "g", "h"}
+	for _, msg := range expected {
+		r.In <- msg
+	}
+	close(r.In)
+	close(r.Results)
+	wg.Wait()
+
+	records := <-received
+	if len(records) != len(expected) || r.Dropped() != 0 {
+		t.Fatalf("Expected all %d records with none dropped, got %v and %d dropped", len(expected), records, r.Dropped())
+	}
+	for i := range expected {
+		if records[i] != expected[i] {
+			t.Errorf("The records arrived out of order: %v", records)
+			break
+		}
+	}
+}
+
+// Test that an unknown overflow policy is an error
+func Test_NewPolicy(t *testing.T) {
+	if _, err := New(10, "discard"); err == nil {
+		t.Error("Expected an error for an unknown overflow policy")
+	}
+}
+
+// Pass a mix of page and other records through a relay filtered to the 404
+// and 5xx pages and test that only the matching page records are emitted.
+func Test_FilterStatus(t *testing.T) {
+	filter, err := ParseStatusFilter("404, 5xx")
+	if err != nil {
+		t.Fatalf("Failed to parse the status filter: %v", err)
+	}
+	r, err := New(10, Block)
+	if err != nil {
+		t.Fatalf("Failed to create the relay: %v", err)
+	}
+	r.Filter = filter.Allow
+	var wg sync.WaitGroup
+	r.Start(&wg)
+	received := consume(r, 0)
+	var results []string
+	collected := make(chan bool)
+	go func() {
+		for result := range r.ResultsOut {
+			results = append(results, result.String())
+		}
+		close(collected)
+	}()
+
+	for _, status := range []int{200, 404, 500, 301, 503} {
+		r.Results <- crawler.Result{SourceURL: "https://example.com/", FoundURL: fmt.Sprintf("https://example.com/%d", status), StatusCode: status}
+	}
+	r.In <- "size,https://example.com/,1024"
+	close(r.In)
+	close(r.Results)
+	wg.Wait()
+	<-collected
+
+	expected := []string{
+		"404,https://example.com/,https://example.com/404",
+		"500,https://example.com/,https://example.com/500",
+		"503,https://example.com/,https://example.com/503",
+	}
+	if strings.Join(results, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("The relay emitted %v, expected %v", results, expected)
+	}
+	if records := <-received; strings.Join(records, "\n") != "size,https://example.com/,1024" {
+		t.Errorf("The other records should always be emitted, got %v", records)
+	}
+	if r.Dropped() != 0 {
+		t.Errorf("The filtered records should not be counted as dropped, got %d", r.Dropped())
+	}
+}
+
+// Test the codes, ranges and classes of a status filter and that invalid
+// entries are rejected.
+func Test_ParseStatusFilter(t *testing.T) {
+	filter, err := ParseStatusFilter("301,400-403,5xx")
+	if err != nil {
+		t.Fatalf("Failed to parse the status filter: %v", err)
+	}
+	for code, match := range map[int]bool{200: false, 301: true, 302: false, 400: true, 403: true, 404: false, 500: true, 599: true} {
+		if filter.Match(code) != match {
+			t.Errorf("Status %d matched %v, expected %v", code, !match, match)
+		}
+	}
+	for _, spec := range []string{"", "abc", "600-500", "5x", "42"} {
+		if _, err := ParseStatusFilter(spec); err == nil {
+			t.Errorf("Expected an error for the status filter [%s]", spec)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package relay
+
+// Filter the page records by the status code of the page their link was
+// found on, i.e. the 200 of 200,<page>,<link>, the other records are always
+// passed through.
+
+import (
+	"fmt"
+	"linkcrawl/crawler"
+	"strconv"
+	"strings"
+)
+
+// statusRange is an inclusive range of status codes
+type statusRange struct {
+	low, high int
+}
+
+// StatusFilter matches the page records with one of its status codes
+type StatusFilter struct {
+	ranges []statusRange
+}
+
+// ParseStatusFilter returns a pointer to a relay.StatusFilter for a comma
+// separated list of codes and ranges such as 404,500-599 or 5xx.
+func ParseStatusFilter(spec string) (*StatusFilter, error) {
+	filter := &StatusFilter{}
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.ToLower(strings.TrimSpace(item))
+		if item == "" {
+			continue
+		}
+		var r statusRange
+		var err error
+		if low, high, ok := strings.Cut(item, "-"); ok {
+			r.low, err = parseStatus(low)
+			if err == nil {
+				r.high, err = parseStatus(high)
+			}
+		} else if class, ok := strings.CutSuffix(item, "xx"); ok && len(class) == 1 {
+			r.low, err = parseStatus(class + "00")
+			r.high = r.low + 99
+		} else {
+			r.low, err = parseStatus(item)
+			r.high = r.low
+		}
+		if err != nil || r.low > r.high {
+			return nil, fmt.Errorf("Invalid status %s, expected a code such as 404, a range such as 500-599 or a class such as 5xx", item)
+		}
+		filter.ranges = append(filter.ranges, r)
+	}
+	if len(filter.ranges) == 0 {
+		return nil, fmt.Errorf("Invalid status filter %s, no status codes given", spec)
+	}
+	return filter, nil
+}
+
+// parseStatus parses a three digit status code
+func parseStatus(value string) (int, error) {
+	code, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		return 0, err
+	}
+	if code < 100 || code > 999 {
+		return 0, fmt.Errorf("status %d out of range", code)
+	}
+	return code, nil
+}
+
+// Match reports whether the status code is in one of the ranges
+func (s *StatusFilter) Match(code int) bool {
+	for _, r := range s.ranges {
+		if code >= r.low && code <= r.high {
+			return true
+		}
+	}
+	return false
+}
+
+// Allow reports whether a page record is emitted, only when its status
+// matches.
+func (s *StatusFilter) Allow(result crawler.Result) bool {
+	return s.Match(result.StatusCode)
+}
@@ -0,0 +1,34 @@
+//go:build !windows
+
+package main
+
+import (
+	"linkcrawl/fetcher"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// resizeOnSignal raises the limit on the concurrent requests by one on each
+// SIGUSR1 and lowers it by one on each SIGUSR2 until done is closed, the
+// fetcher emits a concurrency record with each new limit. It is waited on
+// with wg so that no record is emitted once the output is closed.
+func resizeOnSignal(f *fetcher.Fetcher, done <-chan struct{}, wg *sync.WaitGroup) {
+	defer wg.Done()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1, syscall.SIGUSR2)
+	defer signal.Stop(signals)
+	for {
+		select {
+		case sig := <-signals:
+			if sig == syscall.SIGUSR1 {
+				f.Resize(1)
+			} else {
+				f.Resize(-1)
+			}
+		case <-done:
+			return
+		}
+	}
+}
@@ -0,0 +1,46 @@
+//go:build !windows
+
+package main
+
+import (
+	"linkcrawl/fetcher"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// Send a SIGUSR1 and a SIGUSR2 and test that each is reported with the new
+// limit, and that the wait group is done once the done channel is closed so
+// the output can be closed after it.
+func Test_ResizeOnSignal(t *testing.T) {
+	output := make(chan string)
+	done := make(chan struct{})
+	f := fetcher.NewFetcher(4, 0, time.Second, output, nil, nil, done)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go resizeOnSignal(f, done, &wg)
+	// The signals are only caught once the goroutine has registered for them
+	time.Sleep(100 * time.Millisecond)
+
+	for _, tc := range []struct {
+		sig      syscall.Signal
+		expected string
+	}{{syscall.SIGUSR2, "concurrency,3"}, {syscall.SIGUSR1, "concurrency,4"}} {
+		sig, expected := tc.sig, tc.expected
+		syscall.Kill(syscall.Getpid(), sig)
+		select {
+		case msg := <-output:
+			if msg != expected {
+				t.Errorf("Expected %s for %v, got %s", expected, sig, msg)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("No concurrency record for %v", sig)
+		}
+	}
+
+	close(done)
+	wg.Wait()
+	close(output)
+}
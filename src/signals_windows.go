@@ -0,0 +1,14 @@
+//go:build windows
+
+package main
+
+import (
+	"linkcrawl/fetcher"
+	"sync"
+)
+
+// resizeOnSignal does nothing as there are no SIGUSR1 and SIGUSR2 signals on
+// Windows, the concurrency stays at its configured limit.
+func resizeOnSignal(f *fetcher.Fetcher, done <-chan struct{}, wg *sync.WaitGroup) {
+	wg.Done()
+}
@@ -0,0 +1,80 @@
+package sink
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"linkcrawl/crawler"
+	"sync"
+)
+
+// JSONLines is an io.Writer that encodes each line of output records written
+// to it as newline delimited JSON, the type of the record is the tag before
+// its first comma, i.e. error,Failed to fetch is written as
+// {"type":"error","record":"Failed to fetch"}. A record that is already JSON,
+// such as the done record, is kept as an object, and the links found on the
+// pages are written with WriteResult as a crawler.Result.
+type JSONLines struct {
+	mu      sync.Mutex
+	w       io.Writer
+	partial []byte
+}
+
+// jsonLine is the JSON encoding of an output record
+type jsonLine struct {
+	Type   string `json:"type"`
+	Record any    `json:"record"`
+}
+
+// NewJSONLines returns a pointer to a sink.JSONLines that writes to w
+func NewJSONLines(w io.Writer) *JSONLines {
+	return &JSONLines{w: w}
+}
+
+// Write encodes the complete lines in p, the end of a line that is split
+// across writes is kept until the rest of it is written.
+func (j *JSONLines) Write(p []byte) (int, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	data := append(j.partial, p...)
+	for {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			break
+		}
+		if err := j.encode(data[:i]); err != nil {
+			return 0, err
+		}
+		data = data[i+1:]
+	}
+	j.partial = append([]byte{}, data...)
+	return len(p), nil
+}
+
+// encode writes a single record as a line of JSON
+func (j *JSONLines) encode(line []byte) error {
+	kind, record, _ := bytes.Cut(line, []byte(","))
+	encoded := jsonLine{Type: string(kind), Record: string(record)}
+	if bytes.HasPrefix(record, []byte("{")) && json.Valid(record) {
+		encoded.Record = json.RawMessage(record)
+	}
+	return j.write(encoded)
+}
+
+// WriteResult writes a link found on a page as a line of JSON with the type
+// data, its fields are kept as they are so a comma in the URLs is preserved.
+func (j *JSONLines) WriteResult(result crawler.Result) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.write(jsonLine{Type: "data", Record: result})
+}
+
+// write marshals an encoded record onto its own line
+func (j *JSONLines) write(encoded jsonLine) error {
+	b, err := json.Marshal(encoded)
+	if err != nil {
+		return err
+	}
+	_, err = j.w.Write(append(b, '\n'))
+	return err
+}
@@ -0,0 +1,36 @@
+package sink
+
+import (
+	"bytes"
+	"fmt"
+	"linkcrawl/crawler"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Write each kind of record to a JSONLines and test that the links are
+// encoded as a crawler.Result with their commas kept, the JSON records are
+// kept as objects and the others keep their fields as a string.
+func Test_JSONLines(t *testing.T) {
+	var buf bytes.Buffer
+	out := NewJSONLines(&buf)
+
+	found := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	out.WriteResult(crawler.Result{SourceURL: "https://a.com/x,y", FoundURL: "https://a.com/b?x=1,2", StatusCode: 200, Timestamp: found})
+	fmt.Fprintf(out, "data,%v\n", "size,https://a.com,512")
+	fmt.Fprintf(out, "error,%v\n", "404,https://a.com/c,Invalid Content Type: image/png")
+	fmt.Fprintf(out, "host,%s\n", "a.com")
+	fmt.Fprintf(out, "done,%s\n", `{"event":"done","fetched":2}`)
+
+	expected := []string{
+		`{"type":"data","record":{"source_url":"https://a.com/x,y","found_url":"https://a.com/b?x=1,2","status":200,"timestamp":"2024-05-01T12:00:00Z"}}`,
+		`{"type":"data","record":"size,https://a.com,512"}`,
+		`{"type":"error","record":"404,https://a.com/c,Invalid Content Type: image/png"}`,
+		`{"type":"host","record":"a.com"}`,
+		`{"type":"done","record":{"event":"done","fetched":2}}`,
+	}
+	if lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n"); strings.Join(lines, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("The records were encoded as\n%s\nexpected\n%s", strings.Join(lines, "\n"), strings.Join(expected, "\n"))
+	}
+}
@@ -0,0 +1,130 @@
+package sink
+
+// Kafka publishes each crawled page as a newline delimited JSON message to a
+// topic. The client is behind the Producer interface so that the Kafka
+// dependency is only compiled in with the kafka build tag, see
+// kafka_producer.go.
+
+import (
+	"encoding/json"
+	"fmt"
+	"linkcrawl/crawler"
+	"sync"
+	"time"
+)
+
+// Message is a single message published to the topic, the key is the page
+// URL so the messages for a page always go to the same partition.
+type Message struct {
+	Key   []byte
+	Value []byte
+}
+
+// Producer publishes a batch of messages to a topic
+type Producer interface {
+	Produce(topic string, messages []Message) error
+	Close() error
+}
+
+// Kafka holds the producer and topic along with the batch of messages
+// waiting to be published.
+type Kafka struct {
+	Topic      string
+	BatchSize  int
+	Retries    int
+	RetryDelay time.Duration
+	Producer   Producer
+
+	mu    sync.Mutex
+	batch []Message
+}
+
+// NewKafka returns a pointer to a sink.Kafka that publishes the pages to the
+// topic with the producer, in batches of batchSize.
+func NewKafka(producer Producer, topic string, batchSize int) *Kafka {
+	if batchSize < 1 {
+		batchSize = 1
+	}
+	return &Kafka{
+		Topic:      topic,
+		BatchSize:  batchSize,
+		Retries:    3,
+		RetryDelay: 1 * time.Second,
+		Producer:   producer,
+	}
+}
+
+// Send encodes a page as a JSON line and adds it to the batch, the batch is
+// published once it is full.
+func (k *Kafka) Send(page crawler.Page) error {
+	value, err := json.Marshal(page)
+	if err != nil {
+		return fmt.Errorf("Error encoding page %s: %v", page.URL, err)
+	}
+	message := Message{Key: []byte(page.URL), Value: append(value, '\n')}
+
+	k.mu.Lock()
+	k.batch = append(k.batch, message)
+	if len(k.batch) < k.BatchSize {
+		k.mu.Unlock()
+		return nil
+	}
+	batch := k.batch
+	k.batch = nil
+	k.mu.Unlock()
+
+	return k.publish(batch)
+}
+
+// Flush publishes any messages that are waiting in a partially filled batch
+func (k *Kafka) Flush() error {
+	k.mu.Lock()
+	batch := k.batch
+	k.batch = nil
+	k.mu.Unlock()
+
+	if len(batch) == 0 {
+		return nil
+	}
+	return k.publish(batch)
+}
+
+// Close publishes the remaining messages and closes the producer
+func (k *Kafka) Close() error {
+	err := k.Flush()
+	if closeErr := k.Producer.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("Error closing the Kafka producer: %v", closeErr)
+	}
+	return err
+}
+
+// publish sends a batch of messages to the topic, retrying when the
+// producer returns an error.
+func (k *Kafka) publish(batch []Message) error {
+	var err error
+	for retries := 0; retries <= k.Retries; retries++ {
+		if retries > 0 {
+			time.Sleep(k.RetryDelay)
+		}
+		if err = k.Producer.Produce(k.Topic, batch); err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("Failed to publish %d pages to %s after %d retries: %v", len(batch), k.Topic, k.Retries, err)
+}
+
+// Multi sends each page to all of its sinks, so the pages can be indexed and
+// published at the same time. Every sink is sent the page and the first
+// error is returned.
+type Multi []crawler.Sink
+
+// Send sends the page to each of the sinks
+func (m Multi) Send(page crawler.Page) error {
+	var first error
+	for _, s := range m {
+		if err := s.Send(page); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
@@ -0,0 +1,11 @@
+//go:build !kafka
+
+package sink
+
+import "fmt"
+
+// NewKafkaProducer returns an error as the Kafka producer is only built with
+// the kafka build tag.
+func NewKafkaProducer(brokers []string) (Producer, error) {
+	return nil, fmt.Errorf("Error, built without Kafka support, rebuild with -tags kafka to use -kafka-brokers")
+}
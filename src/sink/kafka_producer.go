@@ -0,0 +1,45 @@
+//go:build kafka
+
+package sink
+
+// The Kafka producer, it is only built with the kafka build tag so the
+// client library is not a dependency of the default build:
+//
+//	go build -tags kafka .
+
+import (
+	"context"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// kafkaProducer publishes the messages with a kafka.Writer
+type kafkaProducer struct {
+	writer *kafka.Writer
+}
+
+// NewKafkaProducer returns a Producer that publishes to the brokers, the
+// messages are spread over the partitions by their key.
+func NewKafkaProducer(brokers []string) (Producer, error) {
+	return &kafkaProducer{
+		writer: &kafka.Writer{
+			Addr:         kafka.TCP(brokers...),
+			Balancer:     &kafka.Hash{},
+			RequiredAcks: kafka.RequireAll,
+		},
+	}, nil
+}
+
+// Produce writes the batch of messages to the topic
+func (p *kafkaProducer) Produce(topic string, messages []Message) error {
+	batch := make([]kafka.Message, len(messages))
+	for i, message := range messages {
+		batch[i] = kafka.Message{Topic: topic, Key: message.Key, Value: message.Value}
+	}
+	return p.writer.WriteMessages(context.Background(), batch...)
+}
+
+// Close flushes and closes the writer
+func (p *kafkaProducer) Close() error {
+	return p.writer.Close()
+}
@@ -0,0 +1,103 @@
+package sink
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"linkcrawl/crawler"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mockProducer records the batches it is sent, failing the first fail
+// calls so that the retries are exercised.
+type mockProducer struct {
+	mu      sync.Mutex
+	fail    int
+	calls   int
+	batches [][]Message
+	topics  []string
+	closed  bool
+}
+
+func (p *mockProducer) Produce(topic string, messages []Message) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.calls++
+	if p.calls <= p.fail {
+		return fmt.Errorf("broker not available")
+	}
+	p.batches = append(p.batches, messages)
+	p.topics = append(p.topics, topic)
+	return nil
+}
+
+func (p *mockProducer) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	return nil
+}
+
+// Send five pages with a batch size of two to a producer that fails once and
+// test that a JSON line message is published for each page, keyed by its URL.
+func Test_Kafka(t *testing.T) {
+	producer := &mockProducer{fail: 1}
+	k := NewKafka(producer, "pages", 2)
+	k.RetryDelay = time.Millisecond
+
+	for i := 0; i < 5; i++ {
+		page := crawler.Page{URL: fmt.Sprintf("https://example.com/%d", i), StatusCode: 200}
+		if err := k.Send(page); err != nil {
+			t.Fatalf("Failed to send page %d: %v", i, err)
+		}
+	}
+	if err := k.Close(); err != nil {
+		t.Fatalf("Failed to close the sink: %v", err)
+	}
+
+	if !producer.closed {
+		t.Error("The producer should be closed with the sink")
+	}
+	if len(producer.batches) != 3 || producer.calls != 4 {
+		t.Fatalf("Expected 3 batches from 4 calls, got %d batches from %d calls", len(producer.batches), producer.calls)
+	}
+	published := 0
+	for i, batch := range producer.batches {
+		if producer.topics[i] != "pages" {
+			t.Errorf("Batch %d was published to %s, expected pages", i, producer.topics[i])
+		}
+		for _, message := range batch {
+			if !bytes.HasSuffix(message.Value, []byte("\n")) || bytes.Count(message.Value, []byte("\n")) != 1 {
+				t.Errorf("The message is not a single JSON line: %q", message.Value)
+			}
+			var page crawler.Page
+			if err := json.Unmarshal(message.Value, &page); err != nil {
+				t.Errorf("Failed to decode the message: %v", err)
+			}
+			if expected := fmt.Sprintf("https://example.com/%d", published); page.URL != expected || string(message.Key) != expected {
+				t.Errorf("The message for %s has the key %s, expected %s", page.URL, message.Key, expected)
+			}
+			published++
+		}
+	}
+	if published != 5 {
+		t.Errorf("Expected 5 messages to be published, got %d", published)
+	}
+}
+
+// Test that a producer that keeps failing is retried and the error returned
+func Test_KafkaRetriesExhausted(t *testing.T) {
+	producer := &mockProducer{fail: 10}
+	k := NewKafka(producer, "pages", 1)
+	k.Retries = 2
+	k.RetryDelay = time.Millisecond
+
+	if err := k.Send(crawler.Page{URL: "https://example.com/"}); err == nil {
+		t.Error("Expected an error once the retries are exhausted")
+	}
+	if producer.calls != 3 {
+		t.Errorf("Expected the producer to be called 3 times, got %d", producer.calls)
+	}
+}
@@ -0,0 +1,145 @@
+package sink
+
+// The sink package holds the implementations of crawler.Sink that store
+// the crawled pages outside of the streamed output.
+// OpenSearch batches the pages and indexes them in an Elasticsearch or
+// OpenSearch cluster using the bulk API, it only depends on net/http.
+// Kafka publishes the pages as JSON messages to a topic, the client is only
+// built with the kafka build tag.
+// WARC archives the raw responses as they were fetched.
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"linkcrawl/crawler"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OpenSearch holds the endpoint and index configuration along with the
+// batch of pages waiting to be sent.
+type OpenSearch struct {
+	URL        string
+	Index      string
+	BatchSize  int
+	Retries    int
+	RetryDelay time.Duration
+	Client     *http.Client
+
+	mu    sync.Mutex
+	batch []crawler.Page
+}
+
+// bulkResponse is the part of the bulk API response that is checked
+type bulkResponse struct {
+	Errors bool `json:"errors"`
+}
+
+// NewOpenSearch returns a pointer to a sink.OpenSearch that indexes pages
+// into index at the cluster url, sending them in batches of batchSize.
+func NewOpenSearch(url, index string, batchSize int) *OpenSearch {
+	if batchSize < 1 {
+		batchSize = 1
+	}
+	return &OpenSearch{
+		URL:        strings.TrimSuffix(url, "/"),
+		Index:      index,
+		BatchSize:  batchSize,
+		Retries:    3,
+		RetryDelay: 1 * time.Second,
+		Client:     &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Send adds a page to the batch, the batch is sent to the bulk endpoint once
+// it is full.
+func (o *OpenSearch) Send(page crawler.Page) error {
+	o.mu.Lock()
+	o.batch = append(o.batch, page)
+	if len(o.batch) < o.BatchSize {
+		o.mu.Unlock()
+		return nil
+	}
+	batch := o.batch
+	o.batch = nil
+	o.mu.Unlock()
+
+	return o.bulk(batch)
+}
+
+// Flush sends any pages that are waiting in a partially filled batch
+func (o *OpenSearch) Flush() error {
+	o.mu.Lock()
+	batch := o.batch
+	o.batch = nil
+	o.mu.Unlock()
+
+	if len(batch) == 0 {
+		return nil
+	}
+	return o.bulk(batch)
+}
+
+// Close flushes the remaining pages to the bulk endpoint
+func (o *OpenSearch) Close() error {
+	return o.Flush()
+}
+
+// bulk builds the newline delimited bulk request for a batch of pages and
+// posts it to the cluster, retrying when the request fails.
+func (o *OpenSearch) bulk(batch []crawler.Page) error {
+	var body bytes.Buffer
+	encoder := json.NewEncoder(&body)
+	for _, page := range batch {
+		action := map[string]map[string]string{
+			"index": {"_index": o.Index, "_id": page.URL},
+		}
+		if err := encoder.Encode(action); err != nil {
+			return fmt.Errorf("Error encoding bulk action: %v", err)
+		}
+		if err := encoder.Encode(page); err != nil {
+			return fmt.Errorf("Error encoding page %s: %v", page.URL, err)
+		}
+	}
+
+	var err error
+	for retries := 0; retries <= o.Retries; retries++ {
+		if retries > 0 {
+			time.Sleep(o.RetryDelay)
+		}
+		if err = o.post(body.Bytes()); err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("Failed to index %d pages after %d retries: %v", len(batch), o.Retries, err)
+}
+
+// post sends a single bulk request and checks the response for errors
+func (o *OpenSearch) post(body []byte) error {
+	resp, err := o.Client.Post(o.URL+"/_bulk", "application/x-ndjson", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("Error reading bulk response: %v", err)
+	}
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("Bulk request returned %d: %s", resp.StatusCode, content)
+	}
+
+	var result bulkResponse
+	if err := json.Unmarshal(content, &result); err != nil {
+		return fmt.Errorf("Error decoding bulk response: %v", err)
+	}
+	if result.Errors {
+		return fmt.Errorf("Bulk request reported errors: %s", content)
+	}
+	return nil
+}
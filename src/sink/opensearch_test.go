@@ -0,0 +1,107 @@
+package sink
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"linkcrawl/crawler"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Spawn a test server mimicking the bulk endpoint, it fails the first
+// request so the retry is exercised and records the documents it indexes.
+// Send five pages with a batch size of two and test that they arrive in
+// three batches once the sink is closed.
+func Test_OpenSearch(t *testing.T) {
+	var mu sync.Mutex
+	requests := 0
+	var batches [][]crawler.Page
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests++
+
+		if r.URL.Path != "/_bulk" || r.Method != http.MethodPost {
+			t.Errorf("Unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		if requests == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		var batch []crawler.Page
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			var action map[string]map[string]string
+			if err := json.Unmarshal(scanner.Bytes(), &action); err != nil {
+				t.Errorf("Failed to decode the bulk action: %v", err)
+			}
+			if action["index"]["_index"] != "pages" {
+				t.Errorf("Unexpected bulk action: %v", action)
+			}
+			if !scanner.Scan() {
+				t.Error("The bulk action is missing its document")
+				break
+			}
+			var page crawler.Page
+			if err := json.Unmarshal(scanner.Bytes(), &page); err != nil {
+				t.Errorf("Failed to decode the document: %v", err)
+			}
+			if action["index"]["_id"] != page.URL {
+				t.Errorf("The document id %s does not match the url %s", action["index"]["_id"], page.URL)
+			}
+			batch = append(batch, page)
+		}
+		batches = append(batches, batch)
+		fmt.Fprint(w, `{"took":1,"errors":false,"items":[]}`)
+	}))
+	defer ts.Close()
+
+	s := NewOpenSearch(ts.URL+"/", "pages", 2)
+	s.RetryDelay = 10 * time.Millisecond
+
+	for i := 0; i < 5; i++ {
+		page := crawler.Page{
+			URL:        fmt.Sprintf("https://example.com/page%d", i),
+			StatusCode: 200,
+			Title:      fmt.Sprintf("Page %d", i),
+			Text:       "Some body text",
+		}
+		if err := s.Send(page); err != nil {
+			t.Errorf("Failed to send page %d: %v", i, err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Errorf("Failed to flush the remaining pages: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(batches) != 3 || len(batches[0]) != 2 || len(batches[1]) != 2 || len(batches[2]) != 1 {
+		t.Fatalf("Expected batches of 2, 2 and 1 documents, got %v", batches)
+	}
+	if batches[0][0].Title != "Page 0" || batches[2][0].URL != "https://example.com/page4" {
+		t.Errorf("The documents were not sent in order: %v", batches)
+	}
+}
+
+// Test that an error is returned when the bulk response reports errors
+func Test_OpenSearchErrors(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"took":1,"errors":true,"items":[]}`)
+	}))
+	defer ts.Close()
+
+	s := NewOpenSearch(ts.URL, "pages", 1)
+	s.Retries = 1
+	s.RetryDelay = time.Millisecond
+
+	if err := s.Send(crawler.Page{URL: "https://example.com"}); err == nil {
+		t.Error("Expected an error when the bulk request reports errors")
+	}
+}
@@ -0,0 +1,88 @@
+package sink
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// socketWriteTimeout is how long a client has to read a record before it is
+// disconnected, so a client that stops reading does not hold up the crawl.
+const socketWriteTimeout = time.Second
+
+// Socket is an io.Writer that listens on a Unix domain socket and writes
+// everything written to it to each of the connected clients. A client that
+// disconnects or stops reading is dropped without affecting the others, the
+// records written while no client is connected are discarded.
+type Socket struct {
+	path     string
+	listener net.Listener
+
+	mu      sync.Mutex
+	clients map[net.Conn]bool
+	wg      sync.WaitGroup
+}
+
+// Listen creates the Unix domain socket at path and returns a pointer to a
+// sink.Socket that accepts clients on it. A socket left at the path by an
+// earlier crawl is replaced.
+func Listen(path string) (*Socket, error) {
+	if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("Error listening on the socket %s: %v", path, err)
+	}
+	s := &Socket{path: path, listener: listener, clients: map[net.Conn]bool{}}
+	s.wg.Add(1)
+	go s.accept()
+	return s, nil
+}
+
+// accept adds the clients that connect until the listener is closed
+func (s *Socket) accept() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.clients[conn] = true
+		s.mu.Unlock()
+	}
+}
+
+// Write writes p to every connected client, a client that cannot be written
+// to is closed and dropped. It never returns an error so that the crawl is
+// not stopped by a client.
+func (s *Socket) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.clients {
+		conn.SetWriteDeadline(time.Now().Add(socketWriteTimeout))
+		if _, err := conn.Write(p); err != nil {
+			conn.Close()
+			delete(s.clients, conn)
+		}
+	}
+	return len(p), nil
+}
+
+// Close stops accepting clients, disconnects the connected ones and removes
+// the socket.
+func (s *Socket) Close() error {
+	err := s.listener.Close()
+	s.wg.Wait()
+	s.mu.Lock()
+	for conn := range s.clients {
+		conn.Close()
+		delete(s.clients, conn)
+	}
+	s.mu.Unlock()
+	os.Remove(s.path)
+	return err
+}
@@ -0,0 +1,90 @@
+package sink
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"linkcrawl/crawler"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// connected returns the number of clients connected to the socket
+func (s *Socket) connected() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.clients)
+}
+
+// Test_Socket checks the records written to a socket are received by a client
+// as NDJSON, and that the socket keeps working after the client disconnects
+func Test_Socket(t *testing.T) {
+	// Unix socket paths are limited in length so avoid the long test temp dir
+	dir, err := os.MkdirTemp("", "sock")
+	if err != nil {
+		t.Fatalf("Failed to create a temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "crawl.sock")
+
+	socket, err := Listen(path)
+	if err != nil {
+		t.Fatalf("Failed to listen on the socket: %v", err)
+	}
+	defer socket.Close()
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("Failed to connect to the socket: %v", err)
+	}
+	for start := time.Now(); socket.connected() == 0; time.Sleep(time.Millisecond) {
+		if time.Since(start) > time.Second {
+			t.Fatalf("Expected the client to be connected")
+		}
+	}
+
+	out := NewJSONLines(socket)
+	out.WriteResult(crawler.Result{SourceURL: "https://a.com", FoundURL: "https://a.com/b", StatusCode: 200, Timestamp: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)})
+	// A record split across writes is sent once it is complete
+	fmt.Fprintf(out, "error,Error fetching ")
+	fmt.Fprintf(out, "https://a.com/c\n")
+	fmt.Fprintf(out, "done,{\"pages\":2}\n")
+
+	type line struct {
+		Type   string          `json:"type"`
+		Record json.RawMessage `json:"record"`
+	}
+	expected := []line{
+		{"data", json.RawMessage(`{"source_url":"https://a.com","found_url":"https://a.com/b","status":200,"timestamp":"2024-05-01T12:00:00Z"}`)},
+		{"error", json.RawMessage(`"Error fetching https://a.com/c"`)},
+		{"done", json.RawMessage(`{"pages":2}`)},
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	scanner := bufio.NewScanner(conn)
+	for _, want := range expected {
+		if !scanner.Scan() {
+			t.Fatalf("Expected a record, got %v", scanner.Err())
+		}
+		var got line
+		if err := json.Unmarshal(scanner.Bytes(), &got); err != nil {
+			t.Fatalf("Expected a JSON record, got [%s]: %v", scanner.Text(), err)
+		}
+		if got.Type != want.Type || string(got.Record) != string(want.Record) {
+			t.Errorf("Expected [%s %s], got [%s %s]", want.Type, want.Record, got.Type, got.Record)
+		}
+	}
+
+	// The client is dropped once it disconnects and the writes still succeed
+	conn.Close()
+	for start := time.Now(); socket.connected() > 0; time.Sleep(time.Millisecond) {
+		if time.Since(start) > time.Second {
+			t.Fatalf("Expected the disconnected client to be dropped")
+		}
+		if _, err := fmt.Fprintf(out, "data,200,https://a.com,https://a.com/d\n"); err != nil {
+			t.Fatalf("Expected the write to succeed, got %v", err)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package sink
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// WARC writes each fetched request and response to a file as a pair of
+// WARC/1.0 records, the file starts with a warcinfo record.
+type WARC struct {
+	Now func() time.Time
+
+	mu     sync.Mutex
+	file   *os.File
+	writer *bufio.Writer
+}
+
+// NewWARC creates the file at path and returns a pointer to a sink.WARC
+// that writes the records to it.
+func NewWARC(path string) (*WARC, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating WARC file: %v", err)
+	}
+	w := &WARC{
+		Now:    time.Now,
+		file:   file,
+		writer: bufio.NewWriter(file),
+	}
+
+	info := []byte("software: linkcrawl\r\nformat: WARC File Format 1.0\r\n")
+	if err := w.record("warcinfo", recordID(), "", "", "application/warc-fields", info); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// Archive writes the response that was received along with its body, which
+// has been read by the caller, followed by the request that was sent for it.
+func (w *WARC) Archive(resp *http.Response, body []byte) error {
+	req := resp.Request
+	target := req.URL.String()
+
+	var request bytes.Buffer
+	fmt.Fprintf(&request, "%s %s HTTP/1.1\r\n", req.Method, req.URL.RequestURI())
+	host := req.Host
+	if len(host) == 0 {
+		host = req.URL.Host
+	}
+	fmt.Fprintf(&request, "Host: %s\r\n", host)
+	req.Header.Write(&request)
+	request.WriteString("\r\n")
+
+	var response bytes.Buffer
+	fmt.Fprintf(&response, "HTTP/%d.%d %s\r\n", resp.ProtoMajor, resp.ProtoMinor, resp.Status)
+	resp.Header.Write(&response)
+	response.WriteString("\r\n")
+	response.Write(body)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	id := recordID()
+	if err := w.record("response", id, target, "", "application/http; msgtype=response", response.Bytes()); err != nil {
+		return err
+	}
+	return w.record("request", recordID(), target, id, "application/http; msgtype=request", request.Bytes())
+}
+
+// Close flushes the buffered records and closes the file
+func (w *WARC) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.writer.Flush(); err != nil {
+		w.file.Close()
+		return fmt.Errorf("Error writing WARC file: %v", err)
+	}
+	return w.file.Close()
+}
+
+// record writes a single WARC record, the target and the ID of the record it
+// is concurrent to are left out when they are empty.
+func (w *WARC) record(kind, id, target, concurrentTo, contentType string, block []byte) error {
+	fmt.Fprintf(w.writer, "WARC/1.0\r\n")
+	fmt.Fprintf(w.writer, "WARC-Type: %s\r\n", kind)
+	fmt.Fprintf(w.writer, "WARC-Record-ID: %s\r\n", id)
+	fmt.Fprintf(w.writer, "WARC-Date: %s\r\n", w.Now().UTC().Format(time.RFC3339))
+	if len(target) > 0 {
+		fmt.Fprintf(w.writer, "WARC-Target-URI: %s\r\n", target)
+	}
+	if len(concurrentTo) > 0 {
+		fmt.Fprintf(w.writer, "WARC-Concurrent-To: %s\r\n", concurrentTo)
+	}
+	fmt.Fprintf(w.writer, "Content-Type: %s\r\n", contentType)
+	fmt.Fprintf(w.writer, "Content-Length: %d\r\n\r\n", len(block))
+	w.writer.Write(block)
+	if _, err := w.writer.WriteString("\r\n\r\n"); err != nil {
+		return fmt.Errorf("Error writing WARC record: %v", err)
+	}
+	return nil
+}
+
+// recordID returns a new random urn:uuid to identify a record
+func recordID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40 // version 4
+	b[8] = b[8]&0x3f | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("<urn:uuid:%x-%x-%x-%x-%x>", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
@@ -0,0 +1,148 @@
+package sink
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// warcRecord is a record read back from a WARC file
+type warcRecord struct {
+	headers map[string]string
+	block   string
+}
+
+// readWARC parses the records in a WARC file, failing the test if any of
+// them are malformed.
+func readWARC(t *testing.T, path string) []warcRecord {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open the WARC file: %v", err)
+	}
+	defer file.Close()
+
+	var records []warcRecord
+	reader := bufio.NewReader(file)
+	for {
+		version, err := reader.ReadString('\n')
+		if err == io.EOF && len(version) == 0 {
+			return records
+		}
+		if version != "WARC/1.0\r\n" {
+			t.Fatalf("Expected a WARC/1.0 record, got [%q]", version)
+		}
+
+		record := warcRecord{headers: map[string]string{}}
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				t.Fatalf("Failed to read the record headers: %v", err)
+			}
+			if line == "\r\n" {
+				break
+			}
+			name, value, ok := strings.Cut(strings.TrimSuffix(line, "\r\n"), ": ")
+			if !ok {
+				t.Fatalf("Malformed record header [%q]", line)
+			}
+			record.headers[name] = value
+		}
+
+		length, err := strconv.Atoi(record.headers["Content-Length"])
+		if err != nil {
+			t.Fatalf("Invalid record Content-Length: %v", err)
+		}
+		block := make([]byte, length+4)
+		if _, err := io.ReadFull(reader, block); err != nil {
+			t.Fatalf("Failed to read the record block: %v", err)
+		}
+		if string(block[length:]) != "\r\n\r\n" {
+			t.Fatalf("The record block is not followed by two CRLFs")
+		}
+		record.block = string(block[:length])
+		records = append(records, record)
+	}
+}
+
+// Archive an html page and an image and test the structure of the WARC
+// file, a warcinfo record followed by a response and request pair for each
+// of the URLs with their headers and bodies.
+func Test_WARC(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/image.png" {
+			w.Header().Set("Content-Type", "image/png")
+			fmt.Fprint(w, "\x89PNG")
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<html><a href="/image.png">image</a></html>`)
+	}))
+	defer ts.Close()
+
+	path := filepath.Join(t.TempDir(), "crawl.warc")
+	archive, err := NewWARC(path)
+	if err != nil {
+		t.Fatalf("Failed to create the WARC file: %v", err)
+	}
+
+	urls := []string{ts.URL + "/", ts.URL + "/image.png"}
+	bodies := map[string]string{}
+	for _, u := range urls {
+		resp, err := http.Get(u)
+		if err != nil {
+			t.Fatalf("Failed to get %s: %v", u, err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", u, err)
+		}
+		bodies[u] = string(body)
+		if err := archive.Archive(resp, body); err != nil {
+			t.Fatalf("Failed to archive %s: %v", u, err)
+		}
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatalf("Failed to close the WARC file: %v", err)
+	}
+
+	records := readWARC(t, path)
+	if len(records) != 5 {
+		t.Fatalf("Expected 5 records, got %d", len(records))
+	}
+	if records[0].headers["WARC-Type"] != "warcinfo" {
+		t.Errorf("The first record is a %s, expected warcinfo", records[0].headers["WARC-Type"])
+	}
+
+	for i, u := range urls {
+		response, request := records[1+2*i], records[2+2*i]
+		if response.headers["WARC-Type"] != "response" || request.headers["WARC-Type"] != "request" {
+			t.Fatalf("Expected a response and request pair for %s", u)
+		}
+		for _, record := range []warcRecord{response, request} {
+			if record.headers["WARC-Target-URI"] != u {
+				t.Errorf("The record targets %s, expected %s", record.headers["WARC-Target-URI"], u)
+			}
+			if !strings.HasPrefix(record.headers["WARC-Record-ID"], "<urn:uuid:") || len(record.headers["WARC-Date"]) == 0 {
+				t.Errorf("The record is missing its ID or date: %v", record.headers)
+			}
+		}
+		if request.headers["WARC-Concurrent-To"] != response.headers["WARC-Record-ID"] {
+			t.Errorf("The request for %s does not refer to its response", u)
+		}
+		if !strings.HasPrefix(response.block, "HTTP/1.1 200 OK\r\n") || !strings.HasSuffix(response.block, "\r\n\r\n"+bodies[u]) {
+			t.Errorf("Unexpected response block for %s: %q", u, response.block)
+		}
+		if !strings.HasPrefix(request.block, "GET "+strings.TrimPrefix(u, ts.URL)+" HTTP/1.1\r\n") {
+			t.Errorf("Unexpected request block for %s: %q", u, request.block)
+		}
+	}
+}